@@ -0,0 +1,280 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"RTSPtoWebRTC/rtsp"
+)
+
+// 默认监听参数
+const (
+	DefaultHTTPAddr  = ":8080"
+	DefaultStaticDir = "web/static/"
+)
+
+// Config 配置文件
+type Config struct {
+	HTTPAddr  string `json:"http_addr,omitempty"`
+	StaticDir string `json:"static_dir,omitempty"`
+	// ICE 所有流默认的 ICE 设置
+	ICE     ICE               `json:"ice"`
+	Streams map[string]Stream `json:"streams"`
+}
+
+// ICE turn/stun 服务器与候选策略
+type ICE struct {
+	URL      string `json:"url,omitempty"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Policy relay/all, 为空时 turn 地址为 relay, stun 地址为 all; relay 必须配置 turn 地址
+	Policy string `json:"policy,omitempty"`
+}
+
+// Stream 一路摄像机流
+type Stream struct {
+	URL string `json:"url"`
+	// Transport tcp/udp/multicast, 为空时为 tcp
+	Transport          string `json:"transport,omitempty"`
+	MulticastInterface string `json:"multicast_interface,omitempty"`
+	// Username/Password 覆盖 URL 中的认证信息
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// Codecs 启用的编码, 为空时全部启用
+	Codecs         []string `json:"codecs,omitempty"`
+	AudioTranscode string   `json:"audio_transcode,omitempty"`
+	// OnDemand 有观看者时才拉流
+	OnDemand bool `json:"on_demand,omitempty"`
+	// IdleTimeout 按需模式下无观看者后断开的等待时间, 如 "30s", 为空时使用默认值
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	// GOPCacheSize GOP 缓存字节上限, 0 为默认值, -1 关闭
+	GOPCacheSize int `json:"gop_cache_size,omitempty"`
+	// ReorderWindow UDP/组播传输时按序号重排等待的包数, 0 为默认值, -1 不等待
+	ReorderWindow int `json:"reorder_window,omitempty"`
+	// KeyframeParameter 摄像机支持时, 收到 PLI/FIR 后作为 SET_PARAMETER 请求体请求关键帧
+	KeyframeParameter string `json:"keyframe_parameter,omitempty"`
+	// ICE 覆盖默认 ICE 设置
+	ICE *ICE `json:"ice,omitempty"`
+	// TLS rtsps 地址的证书设置
+	TLS *TLS `json:"tls,omitempty"`
+}
+
+// TLS rtsps 连接的证书文件 (PEM)
+type TLS struct {
+	// CA 校验摄像机证书的 CA, 为空时使用系统 CA
+	CA string `json:"ca,omitempty"`
+	// Cert/Key 摄像机要求客户端证书时设置
+	Cert string `json:"cert,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// InsecureSkipVerify 不校验摄像机证书, 只用于自签名证书的调试
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+}
+
+// Load 读取并校验配置文件
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse 解析并校验配置
+func Parse(data []byte) (*Config, error) {
+	config := &Config{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	if config.HTTPAddr == "" {
+		config.HTTPAddr = DefaultHTTPAddr
+	}
+	if config.StaticDir == "" {
+		config.StaticDir = DefaultStaticDir
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate 校验配置, 返回第一个错误; 允许没有流, 之后可通过接口添加
+func (config *Config) Validate() error {
+	if err := config.ICE.validate(); err != nil {
+		return fmt.Errorf("config: ice: %v", err)
+	}
+	for _, name := range config.Names() {
+		if err := ValidateName(name); err != nil {
+			return fmt.Errorf("config: %v", err)
+		}
+		if err := config.Streams[name].Validate(); err != nil {
+			return fmt.Errorf("config: stream %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Names 按名称排序的流列表
+func (config *Config) Names() []string {
+	names := make([]string, 0, len(config.Streams))
+	for name := range config.Streams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ValidateName 流名称用于 url 路径, 不能为空或包含 /
+func ValidateName(name string) error {
+	if name == "" || strings.ContainsAny(name, "/?#") {
+		return fmt.Errorf("invalid stream name %q", name)
+	}
+	return nil
+}
+
+// Validate 校验单路流配置
+func (stream Stream) Validate() error {
+	u, err := url.Parse(stream.URL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "rtsp" && u.Scheme != "rtsps" && u.Scheme != rtsp.TunnelScheme) || u.Host == "" {
+		return fmt.Errorf("invalid rtsp url %q", stream.URL)
+	}
+	switch stream.Transport {
+	case "", rtsp.TransportTCP:
+	case rtsp.TransportUDP, rtsp.TransportMulticast:
+		if u.Scheme == rtsp.TunnelScheme {
+			return fmt.Errorf("transport %q is not available through http tunnel", stream.Transport)
+		}
+	default:
+		return fmt.Errorf("unknown transport %q", stream.Transport)
+	}
+	for _, codec := range stream.Codecs {
+		if !knownCodec(codec) {
+			return fmt.Errorf("unknown codec %q", codec)
+		}
+	}
+	switch stream.AudioTranscode {
+	case rtsp.TranscodeOff, rtsp.TranscodeOpus, rtsp.TranscodePCMU, rtsp.TranscodePCMA:
+	default:
+		return fmt.Errorf("unknown audio transcode %q", stream.AudioTranscode)
+	}
+	if err := rtsp.TranscodeAvailable(stream.AudioTranscode); err != nil {
+		return fmt.Errorf("audio transcode %q: %v", stream.AudioTranscode, err)
+	}
+	if stream.IdleTimeout != "" {
+		if d, err := time.ParseDuration(stream.IdleTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid idle timeout %q", stream.IdleTimeout)
+		}
+	}
+	if stream.ICE != nil {
+		if err := stream.ICE.validate(); err != nil {
+			return fmt.Errorf("ice: %v", err)
+		}
+	}
+	if stream.TLS != nil {
+		if _, err := stream.TLS.Config(); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+	}
+	return nil
+}
+
+func knownCodec(name string) bool {
+	for _, codec := range rtsp.Codecs {
+		if strings.EqualFold(codec, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ice ICE) validate() error {
+	switch ice.Policy {
+	case rtsp.ICEPolicyRelay:
+		// 只用中继而没有 turn 服务器时收集不到任何候选
+		if !rtsp.IsTURN(ice.URL) {
+			return errors.New("relay policy requires a turn: url")
+		}
+		return nil
+	case "", rtsp.ICEPolicyAll:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q", ice.Policy)
+}
+
+// Config 读取证书文件, 生成 rtsps 连接的 TLS 设置
+func (t TLS) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CA != "" {
+		data, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate in ca %q", t.CA)
+		}
+	}
+	if (t.Cert == "") != (t.Key == "") {
+		return nil, errors.New("cert and key must be set together")
+	}
+	if t.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// RTSPURL 合并认证信息后的拉流地址
+func (stream Stream) RTSPURL() string {
+	if stream.Username == "" {
+		return stream.URL
+	}
+	u, err := url.Parse(stream.URL)
+	if err != nil {
+		return stream.URL
+	}
+	u.User = url.UserPassword(stream.Username, stream.Password)
+	return u.String()
+}
+
+// Hub 按配置创建分发中心, 未设置 ICE 时使用 defaults
+func (stream Stream) Hub(name string, defaults ICE) *rtsp.Hub {
+	ice := defaults
+	if stream.ICE != nil {
+		ice = *stream.ICE
+	}
+	hub := rtsp.HubNew(name, stream.RTSPURL(), &rtsp.StunConfig{
+		URL:      ice.URL,
+		UserName: ice.Username,
+		PassWord: ice.Password,
+		Policy:   ice.Policy,
+	})
+	if stream.Transport != "" {
+		hub.SetTransport(stream.Transport)
+	}
+	hub.MulticastInterface = stream.MulticastInterface
+	hub.AudioTranscode = stream.AudioTranscode
+	hub.Codecs = stream.Codecs
+	hub.OnDemand = stream.OnDemand
+	hub.IdleTimeout, _ = time.ParseDuration(stream.IdleTimeout)
+	hub.GOPCacheSize = stream.GOPCacheSize
+	hub.KeyframeParameter = stream.KeyframeParameter
+	hub.ReorderWindow = stream.ReorderWindow
+	if stream.TLS != nil {
+		hub.TLSConfig, _ = stream.TLS.Config()
+	}
+	return hub
+}
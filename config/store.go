@@ -0,0 +1,229 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sync"
+
+	"RTSPtoWebRTC/rtsp"
+)
+
+// 流增删改错误
+var (
+	ErrStreamExists   = errors.New("stream already exists")
+	ErrStreamNotFound = errors.New("stream not found")
+)
+
+// SaveError 写配置文件失败, 内存配置未修改
+type SaveError struct {
+	Err error
+}
+
+func (e *SaveError) Error() string {
+	return "save config: " + e.Err.Error()
+}
+
+// redactedPassword 接口返回时替代密码
+const redactedPassword = "xxxxxx"
+
+// Store 运行中的流配置, 每次修改启停对应的分发中心并写回配置文件
+type Store struct {
+	mutex  sync.Mutex
+	path   string // 为空时修改只在内存中生效
+	config *Config
+	hubs   map[string]*rtsp.Hub
+}
+
+// StoreNew 创建配置存储, 不启动任何流
+func StoreNew(path string, config *Config) *Store {
+	return &Store{path: path, config: config, hubs: make(map[string]*rtsp.Hub)}
+}
+
+// Start 启动配置中的所有流
+func (store *Store) Start() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for _, name := range store.config.Names() {
+		store.startLocked(name, store.config.Streams[name])
+	}
+}
+
+// Names 按名称排序的流列表
+func (store *Store) Names() []string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.config.Names()
+}
+
+// Get 返回流配置的副本, 修改不影响运行中的配置
+func (store *Store) Get(name string) (Stream, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	stream, ok := store.config.Streams[name]
+	if stream.ICE != nil {
+		ice := *stream.ICE
+		stream.ICE = &ice
+	}
+	if stream.TLS != nil {
+		t := *stream.TLS
+		stream.TLS = &t
+	}
+	stream.Codecs = append([]string(nil), stream.Codecs...)
+	return stream, ok
+}
+
+// Add 新增并启动流
+func (store *Store) Add(name string, stream Stream) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if err := stream.Validate(); err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.config.Streams[name]; ok {
+		return ErrStreamExists
+	}
+	if err := store.saveLocked(name, &stream); err != nil {
+		return err
+	}
+	store.startLocked(name, stream)
+	return nil
+}
+
+// Update 替换流配置, 旧连接与观看者关闭后按新配置重新拉流
+func (store *Store) Update(name string, stream Stream) error {
+	if err := stream.Validate(); err != nil {
+		return err
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.config.Streams[name]; !ok {
+		return ErrStreamNotFound
+	}
+	if err := store.saveLocked(name, &stream); err != nil {
+		return err
+	}
+	store.stopLocked(name)
+	store.startLocked(name, stream)
+	return nil
+}
+
+// Remove 停止并删除流
+func (store *Store) Remove(name string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	if _, ok := store.config.Streams[name]; !ok {
+		return ErrStreamNotFound
+	}
+	if err := store.saveLocked(name, nil); err != nil {
+		return err
+	}
+	store.stopLocked(name)
+	return nil
+}
+
+func (store *Store) startLocked(name string, stream Stream) {
+	hub := stream.Hub(name, store.config.ICE)
+	store.hubs[name] = hub
+	rtsp.RegisterHub(hub)
+	hub.Start()
+}
+
+func (store *Store) stopLocked(name string) {
+	hub := store.hubs[name]
+	if hub == nil {
+		return
+	}
+	delete(store.hubs, name)
+	rtsp.UnregisterHub(hub)
+	hub.Stop()
+}
+
+// saveLocked 修改内存配置并写回文件, stream 为 nil 表示删除; 写入失败时内存配置不变
+func (store *Store) saveLocked(name string, stream *Stream) error {
+	streams := make(map[string]Stream, len(store.config.Streams)+1)
+	for n, s := range store.config.Streams {
+		streams[n] = s
+	}
+	if stream == nil {
+		delete(streams, name)
+	} else {
+		streams[name] = *stream
+	}
+	next := *store.config
+	next.Streams = streams
+	if store.path != "" {
+		if err := next.Save(store.path); err != nil {
+			return &SaveError{Err: err}
+		}
+	}
+	*store.config = next
+	return nil
+}
+
+// Save 写入配置文件, 先写临时文件再重命名, 避免写一半的文件
+func (config *Config) Save(path string) error {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Redacted 隐藏密码后的流配置, 用于接口返回
+func (stream Stream) Redacted() Stream {
+	if stream.Password != "" {
+		stream.Password = redactedPassword
+	}
+	if u, err := url.Parse(stream.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedPassword)
+			stream.URL = u.String()
+		}
+	}
+	if stream.ICE != nil && stream.ICE.Password != "" {
+		ice := *stream.ICE
+		ice.Password = redactedPassword
+		stream.ICE = &ice
+	}
+	return stream
+}
+
+// Unredacted 将 Redacted 留下的占位密码还原为 stored 中的密码, 客户端取回配置后原样提交时不会覆盖真实密码
+func (stream Stream) Unredacted(stored Stream) Stream {
+	if stream.Password == redactedPassword {
+		stream.Password = stored.Password
+	}
+	if u, err := url.Parse(stream.URL); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password == redactedPassword {
+			password = ""
+			if s, err := url.Parse(stored.URL); err == nil && s.User != nil {
+				password, _ = s.User.Password()
+			}
+			u.User = url.UserPassword(u.User.Username(), password)
+			stream.URL = u.String()
+		}
+	}
+	if stream.ICE != nil && stream.ICE.Password == redactedPassword {
+		ice := *stream.ICE
+		ice.Password = ""
+		if stored.ICE != nil {
+			ice.Password = stored.ICE.Password
+		}
+		stream.ICE = &ice
+	}
+	return stream
+}
@@ -5,7 +5,11 @@ go 1.12
 require (
 	github.com/deepch/av v0.0.0-20160612005306-c437a98c9300
 	github.com/gorilla/mux v1.7.3
+	github.com/pion/rtcp v1.2.1
+	github.com/pion/rtp v1.1.3
+	github.com/pion/sdp/v2 v2.3.0
+	github.com/pion/srtp v1.2.6
 	github.com/pion/webrtc/v2 v2.1.6-0.20191007070345-5a752da6831a
 	github.com/sirupsen/logrus v1.4.2
-	gopkg.in/ini.v1 v1.48.0
+	golang.org/x/net v0.0.0-20191002035440-2ec189313ef0
 )
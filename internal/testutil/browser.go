@@ -0,0 +1,49 @@
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+)
+
+// NewBrowser 模拟浏览器的 PeerConnection, 只接收视频, 返回 offer sdp
+func NewBrowser(t *testing.T) (*webrtc.PeerConnection, string) {
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterDefaultCodecs()
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+	browser, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := browser.AddTransceiver(webrtc.RTPCodecTypeVideo, webrtc.RtpTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := browser.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := browser.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	return browser, offer.SDP
+}
+
+// ConnectBrowser 设置 answer 并等待 ICE 连通;
+// pion 在 SetRemoteDescription 后异步启动 ICE, 启动前 Close 会 panic
+func ConnectBrowser(t *testing.T, browser *webrtc.PeerConnection, answer string) {
+	connected := make(chan struct{})
+	browser.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		if state == webrtc.ICEConnectionStateConnected {
+			close(connected)
+		}
+	})
+	if err := browser.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-connected:
+	case <-time.After(10 * time.Second):
+		t.Fatal("browser not connected")
+	}
+}
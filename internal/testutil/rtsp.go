@@ -0,0 +1,166 @@
+package testutil
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SDP 一路 H264 视频
+const SDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=stand-in\r\nt=0 0\r\nm=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:trackID=0\r\n"
+
+// Request 替身服务器收到的 RTSP 请求
+type Request struct {
+	Method string
+	URL    string
+	Header textproto.MIMEHeader
+}
+
+// Handler 处理一个请求, 返回状态码, 附加头与 body
+type Handler func(req *Request, conn net.Conn) (int, string, string)
+
+// RTSPServer 本地 RTSP 替身服务器
+type RTSPServer struct {
+	Listener net.Listener
+	Scheme   string
+	Handler  Handler
+}
+
+// NewRTSPServer 在 127.0.0.1 的随机端口上启动替身服务器
+func NewRTSPServer(t *testing.T, handler Handler) *RTSPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &RTSPServer{Listener: listener, Scheme: "rtsp", Handler: handler}
+	go server.Serve()
+	return server
+}
+
+// NewRTSPSServer rtsps 替身服务器, 证书自签名, 返回信任该证书的 CA 池
+func NewRTSPSServer(t *testing.T, handler Handler) (*RTSPServer, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "stand-in camera"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &RTSPServer{Listener: listener, Scheme: "rtsps", Handler: handler}
+	go server.Serve()
+	return server, pool
+}
+
+// URL 替身服务器上 path 的地址
+func (server *RTSPServer) URL(path string) string {
+	return server.Scheme + "://" + server.Listener.Addr().String() + path
+}
+
+// Close 停止监听, 已建立的连接由对端关闭
+func (server *RTSPServer) Close() {
+	server.Listener.Close()
+}
+
+// Serve 接受连接直到 Close
+func (server *RTSPServer) Serve() {
+	for {
+		conn, err := server.Listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.Handle(conn)
+	}
+}
+
+// Handle 在 conn 上逐个读取请求并应答
+func (server *RTSPServer) Handle(conn net.Conn) {
+	defer conn.Close()
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+		header, err := reader.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		req := &Request{Method: fields[0], URL: fields[1], Header: header}
+		status, add, body := server.Handler(req, conn)
+		response := "RTSP/1.0 " + strconv.Itoa(status) + " " + statusText(status) + "\r\nCSeq: " + header.Get("CSeq") + "\r\n" + add
+		if body != "" {
+			response += "Content-Type: application/sdp\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n"
+		}
+		response += "\r\n" + body
+		if _, err := conn.Write([]byte(response)); err != nil {
+			return
+		}
+	}
+}
+
+func statusText(status int) string {
+	switch status {
+	case 200:
+		return "OK"
+	case 401:
+		return "Unauthorized"
+	case 461:
+		return "Unsupported Transport"
+	}
+	return "Error"
+}
+
+// Camera 返回只应答信令的摄像机 Handler: DESCRIBE 返回 sdp, SETUP 接受客户端请求的 TCP 交织通道, 不发送媒体
+func Camera(sdp string) Handler {
+	return func(req *Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", sdp
+		case "SETUP":
+			return 200, "Session: 12345678;timeout=60\r\nTransport: " + req.Header.Get("Transport") + "\r\n", ""
+		}
+		return 200, "", ""
+	}
+}
@@ -0,0 +1,164 @@
+package rtsp
+
+import (
+	"errors"
+
+	"github.com/pion/rtp"
+)
+
+// aacSampleRates AudioSpecificConfig 中 samplingFrequencyIndex 对应的采样率
+var aacSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// aacConfig AudioSpecificConfig (ISO 14496-3 1.6.2.1) 中转码需要的字段
+type aacConfig struct {
+	ObjectType int
+	SampleRate int
+	Channels   int
+}
+
+// parseAACConfig 解析 SDP fmtp 中的 config
+func parseAACConfig(config []byte) (c aacConfig, err error) {
+	reader := &bitReader{data: config}
+	if c.ObjectType, err = reader.readBits(5); err != nil {
+		return
+	}
+	if c.ObjectType == 31 {
+		var ext int
+		if ext, err = reader.readBits(6); err != nil {
+			return
+		}
+		c.ObjectType = 32 + ext
+	}
+	index, err := reader.readBits(4)
+	if err != nil {
+		return
+	}
+	if index == 0xF {
+		if c.SampleRate, err = reader.readBits(24); err != nil {
+			return
+		}
+	} else if index < len(aacSampleRates) {
+		c.SampleRate = aacSampleRates[index]
+	} else {
+		return c, errors.New("invalid aac sampling frequency index")
+	}
+	if c.Channels, err = reader.readBits(4); err != nil {
+		return
+	}
+	if c.Channels == 7 {
+		c.Channels = 8
+	}
+	if c.Channels == 0 {
+		return c, errors.New("aac program config element channels not supported")
+	}
+	return c, nil
+}
+
+// errAACSizeLength fmtp 缺少 sizelength 时无法解析 AU 头
+var errAACSizeLength = errors.New("aac sizelength missing in fmtp")
+
+// aacDepacketizer RFC 3640 mpeg4-generic 解包, 输出 access unit
+type aacDepacketizer struct {
+	sizeLength       int
+	indexLength      int
+	indexDeltaLength int
+	fragment         []byte
+	fragmentSize     int
+}
+
+// Unpack 解析一个 RTP 包, 返回其中完整的 AU; 跨包分片的 AU 在 marker 包到达后返回
+func (d *aacDepacketizer) Unpack(packet *rtp.Packet) ([][]byte, error) {
+	if d.sizeLength <= 0 {
+		return nil, errAACSizeLength
+	}
+	payload := packet.Payload
+	if len(payload) < 2 {
+		return nil, errors.New("aac payload too short")
+	}
+	headersBits := int(payload[0])<<8 | int(payload[1])
+	headersLen := (headersBits + 7) / 8
+	if len(payload) < 2+headersLen {
+		return nil, errors.New("aac au headers truncated")
+	}
+	reader := &bitReader{data: payload[2 : 2+headersLen]}
+	data := payload[2+headersLen:]
+
+	// 每个 AU 头至少消耗 sizelength 位, 循环必定前进
+	var sizes []int
+	for consumed := 0; consumed < headersBits; {
+		size, err := reader.readBits(d.sizeLength)
+		if err != nil {
+			return nil, err
+		}
+		indexBits := d.indexDeltaLength
+		if len(sizes) == 0 {
+			indexBits = d.indexLength
+		}
+		if _, err := reader.readBits(indexBits); err != nil {
+			return nil, err
+		}
+		consumed += d.sizeLength + indexBits
+		sizes = append(sizes, size)
+	}
+
+	// 单个 AU 分片: 头中的大小为整个 AU 的大小
+	if len(sizes) == 1 && (sizes[0] > len(data) || d.fragment != nil) {
+		if d.fragment == nil {
+			d.fragmentSize = sizes[0]
+		}
+		d.fragment = append(d.fragment, data...)
+		if !packet.Marker {
+			return nil, nil
+		}
+		au := d.fragment
+		d.fragment = nil
+		if len(au) != d.fragmentSize {
+			return nil, errors.New("aac fragmented au size mismatch")
+		}
+		return [][]byte{au}, nil
+	}
+
+	aus := make([][]byte, 0, len(sizes))
+	for _, size := range sizes {
+		if size > len(data) {
+			return aus, errors.New("aac au truncated")
+		}
+		aus = append(aus, data[:size])
+		data = data[size:]
+	}
+	return aus, nil
+}
+
+// bitReader 按位读取
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+// readBits 读取 n 位 (n <= 32)
+func (r *bitReader) readBits(n int) (int, error) {
+	value := 0
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			return 0, errors.New("bit reader out of data")
+		}
+		bit := r.data[r.pos/8] >> uint(7-r.pos%8) & 1
+		value = value<<1 | int(bit)
+		r.pos++
+	}
+	return value, nil
+}
+
+// skip 跳过 n 位
+func (r *bitReader) skip(n int) error {
+	if r.pos+n > len(r.data)*8 {
+		return errors.New("bit reader out of data")
+	}
+	r.pos += n
+	return nil
+}
+
+// byteAlign 跳到下一个字节边界
+func (r *bitReader) byteAlign() {
+	r.pos = (r.pos + 7) / 8 * 8
+}
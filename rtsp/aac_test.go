@@ -0,0 +1,157 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"bytes"
+	"testing"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
+)
+
+// AAC-LC 16kHz 单声道
+var testAACConfig = []byte{0x14, 0x08}
+
+func TestParseAACConfig(t *testing.T) {
+	config, err := parseAACConfig(testAACConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ObjectType != 2 || config.SampleRate != 16000 || config.Channels != 1 {
+		t.Errorf("config = %+v", config)
+	}
+}
+
+func TestAACDepacketizer(t *testing.T) {
+	// AAC-hbr: sizelength=13, indexlength=3, indexdeltalength=3
+	depacketizer := &aacDepacketizer{sizeLength: 13, indexLength: 3, indexDeltaLength: 3}
+
+	// 两个 AU, 大小 2 与 3
+	packet := &rtp.Packet{Header: rtp.Header{Marker: true}, Payload: []byte{
+		0x00, 0x20, 0x00, 0x10, 0x00, 0x18,
+		0xaa, 0xbb, 0xcc, 0xdd, 0xee,
+	}}
+	aus, err := depacketizer.Unpack(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aus) != 2 || !bytes.Equal(aus[0], []byte{0xaa, 0xbb}) || !bytes.Equal(aus[1], []byte{0xcc, 0xdd, 0xee}) {
+		t.Fatalf("aus = %x", aus)
+	}
+
+	// 一个大小为 4 的 AU 分成两个包
+	first := &rtp.Packet{Payload: []byte{0x00, 0x10, 0x00, 0x20, 0x01, 0x02}}
+	last := &rtp.Packet{Header: rtp.Header{Marker: true}, Payload: []byte{0x00, 0x10, 0x00, 0x20, 0x03, 0x04}}
+	if aus, err = depacketizer.Unpack(first); err != nil || aus != nil {
+		t.Fatalf("first fragment = %x, %v", aus, err)
+	}
+	if aus, err = depacketizer.Unpack(last); err != nil {
+		t.Fatal(err)
+	}
+	if len(aus) != 1 || !bytes.Equal(aus[0], []byte{0x01, 0x02, 0x03, 0x04}) {
+		t.Fatalf("fragmented au = %x", aus)
+	}
+}
+
+func TestG711Encode(t *testing.T) {
+	tests := []struct {
+		sample int16
+		ulaw   byte
+		alaw   byte
+	}{
+		{0, 0xFF, 0xD5},
+		{32767, 0x80, 0xAA},
+		{-32768, 0x00, 0x2A},
+	}
+	for _, test := range tests {
+		if got := linearToMulaw(test.sample); got != test.ulaw {
+			t.Errorf("ulaw(%d) = %#x, want %#x", test.sample, got, test.ulaw)
+		}
+		if got := linearToAlaw(test.sample); got != test.alaw {
+			t.Errorf("alaw(%d) = %#x, want %#x", test.sample, got, test.alaw)
+		}
+	}
+}
+
+// constDecoder 每个 AU 解码为 1024 个固定值采样
+type constDecoder struct{}
+
+func (constDecoder) Decode(frame []byte) ([]int16, error) {
+	pcm := make([]int16, 1024)
+	for i := range pcm {
+		pcm[i] = 1000
+	}
+	return pcm, nil
+}
+
+func TestAudioTranscoderPCMU(t *testing.T) {
+	RegisterAACDecoder(func(config []byte) (AudioDecoder, error) {
+		return constDecoder{}, nil
+	})
+	defer RegisterAACDecoder(newAACDecoder)
+
+	info := sdp.Info{Type: av.AAC, Config: testAACConfig, SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+	transcoder, err := newAudioTranscoder(info, TranscodePCMU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcoder.output != av.PCM_MULAW {
+		t.Fatalf("output = %#x", transcoder.output)
+	}
+	// 每个 AU 1024 个 16kHz 采样, 重采样到 8kHz 约 512 个, 两个 AU 至少输出 6 个 20ms 帧
+	packet := &rtp.Packet{Header: rtp.Header{Marker: true}, Payload: []byte{0x00, 0x20, 0x00, 0x08, 0x00, 0x08, 0x01, 0x02}}
+	samples, err := transcoder.transcode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 6 {
+		t.Fatalf("got %d samples", len(samples))
+	}
+	for _, sample := range samples {
+		if len(sample.Data) != g711FrameSize || sample.Samples != g711FrameSize {
+			t.Errorf("sample size %d/%d", len(sample.Data), sample.Samples)
+		}
+	}
+	// 首帧从静音插值开始, 之后为稳定值
+	if want := linearToMulaw(1000); samples[1].Data[0] != want {
+		t.Errorf("steady sample = %#x, want %#x", samples[1].Data[0], want)
+	}
+}
+
+func TestAudioTranscoderWithoutDecoder(t *testing.T) {
+	RegisterAACDecoder(nil)
+	defer RegisterAACDecoder(newAACDecoder)
+	info := sdp.Info{Type: av.AAC, Config: testAACConfig, SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+	if _, err := newAudioTranscoder(info, TranscodePCMU); err != ErrNoAACDecoder {
+		t.Errorf("err = %v, want %v", err, ErrNoAACDecoder)
+	}
+}
+
+func TestAACMissingSizeLength(t *testing.T) {
+	depacketizer := &aacDepacketizer{}
+	packet := &rtp.Packet{Header: rtp.Header{Marker: true}, Payload: []byte{0x00, 0x10, 0x00, 0x08, 0x01}}
+	if _, err := depacketizer.Unpack(packet); err != errAACSizeLength {
+		t.Errorf("unpack err = %v", err)
+	}
+	info := sdp.Info{Type: av.AAC, Config: testAACConfig}
+	if _, err := newAudioTranscoder(info, TranscodePCMU); err != errAACSizeLength {
+		t.Errorf("transcoder err = %v", err)
+	}
+}
+
+func TestTranscodeAvailable(t *testing.T) {
+	RegisterAACDecoder(nil)
+	defer RegisterAACDecoder(newAACDecoder)
+	if err := TranscodeAvailable(TranscodePCMA); err != ErrNoAACDecoder || len(TranscodeModes()) != 0 {
+		t.Errorf("without decoder: %v, modes %v", err, TranscodeModes())
+	}
+	RegisterAACDecoder(func(config []byte) (AudioDecoder, error) {
+		return constDecoder{}, nil
+	})
+	if err := TranscodeAvailable(TranscodeOpus); err != ErrNoOpusEncoder {
+		t.Errorf("opus without encoder: %v", err)
+	}
+	if modes := TranscodeModes(); len(modes) != 2 || modes[0] != TranscodePCMU || modes[1] != TranscodePCMA {
+		t.Errorf("modes = %v", modes)
+	}
+}
@@ -0,0 +1,1097 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+)
+
+// aacFrameLength 每个 AU 每声道输出的采样数
+const aacFrameLength = 1024
+
+// AAC 语法元素 (ISO 14496-3 4.5.2.1)
+const (
+	aacElementSCE = 0
+	aacElementCPE = 1
+	aacElementCCE = 2
+	aacElementLFE = 3
+	aacElementDSE = 4
+	aacElementPCE = 5
+	aacElementFIL = 6
+	aacElementEND = 7
+)
+
+// 窗序列
+const (
+	aacOnlyLong   = 0
+	aacLongStart  = 1
+	aacEightShort = 2
+	aacLongStop   = 3
+)
+
+// 码本, 12 保留, 13-15 不携带频谱数据
+const (
+	aacZeroHCB       = 0
+	aacEscHCB        = 11
+	aacNoiseHCB      = 13
+	aacIntensityHCB2 = 14
+	aacIntensityHCB  = 15
+)
+
+// TNS 最大阶数 (LC)
+const (
+	aacTNSMaxOrderLong  = 12
+	aacTNSMaxOrderShort = 7
+)
+
+var errAACBitstream = errors.New("aac: invalid bitstream")
+
+// huffmanTree 二叉解码树, 子节点为负数时是叶子 -(符号+1), 为 0 时码字无效
+type huffmanTree [][2]int32
+
+// newHuffmanTree 由码字与码长建树
+func newHuffmanTree(codes func(i int) uint32, bits []uint8) huffmanTree {
+	tree := huffmanTree{{0, 0}}
+	for symbol, length := range bits {
+		code := codes(symbol)
+		node := 0
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := code >> uint(i) & 1
+			if i == 0 {
+				tree[node][bit] = int32(-symbol - 1)
+				break
+			}
+			if tree[node][bit] == 0 {
+				tree = append(tree, [2]int32{0, 0})
+				tree[node][bit] = int32(len(tree) - 1)
+			}
+			node = int(tree[node][bit])
+		}
+	}
+	return tree
+}
+
+// decode 读取一个码字, 返回符号
+func (tree huffmanTree) decode(r *bitReader) (int, error) {
+	node := 0
+	for {
+		bit, err := r.readBits(1)
+		if err != nil {
+			return 0, err
+		}
+		next := tree[node][bit]
+		if next < 0 {
+			return int(-next - 1), nil
+		}
+		if next == 0 {
+			return 0, errAACBitstream
+		}
+		node = int(next)
+	}
+}
+
+var (
+	aacSpectralTrees   [11]huffmanTree
+	aacScaleFactorTree huffmanTree
+)
+
+func init() {
+	for i := range aacSpectralTrees {
+		codes := aacSpectralCodes[i]
+		aacSpectralTrees[i] = newHuffmanTree(func(symbol int) uint32 { return uint32(codes[symbol]) }, aacSpectralBits[i])
+	}
+	aacScaleFactorTree = newHuffmanTree(func(symbol int) uint32 { return aacScaleFactorCodes[symbol] }, aacScaleFactorBits)
+}
+
+// aacCodebookShape 频谱码本的维数, 每维取值个数与是否有符号
+var aacCodebookShape = [11]struct {
+	dim, mod int
+	signed   bool
+}{
+	{4, 3, true}, {4, 3, true}, {4, 3, false}, {4, 3, false},
+	{2, 9, true}, {2, 9, true}, {2, 8, false}, {2, 8, false},
+	{2, 13, false}, {2, 13, false}, {2, 17, false},
+}
+
+// aacTNS 一个声道的 TNS 滤波器, lpc[w][f] 为 LPC 系数 (lpc[0] = 1)
+type aacTNS struct {
+	present   bool
+	filters   [8]int
+	length    [8][4]int
+	order     [8][4]int
+	direction [8][4]bool
+	lpc       [8][4][32]float64
+}
+
+// aacICS individual_channel_stream, 短窗时频谱按窗顺序存放, 窗 w 从 w*128 开始
+type aacICS struct {
+	windowSequence int
+	windowShape    int
+	maxSFB         int
+	numWindows     int
+	numGroups      int
+	groupLength    [8]int
+	swbOffset      []int
+	bandType       [8][64]int
+	scaleFactor    [8][64]int
+	tns            aacTNS
+	quant          [aacFrameLength]int
+	spec           [aacFrameLength]float64
+}
+
+// aacChannel 声道跨帧的滤波器组状态
+type aacChannel struct {
+	overlap     [aacFrameLength]float64
+	windowShape int
+}
+
+// aacDecoder 纯 Go 的 AAC-LC 解码器, HE-AAC 只解核心层 (不做 SBR/PS)
+type aacDecoder struct {
+	config   aacConfig
+	sfIndex  int
+	channels []*aacChannel
+	random   uint32
+}
+
+// newAACDecoder 默认注册的 AACDecoderFactory
+func newAACDecoder(config []byte) (AudioDecoder, error) {
+	c, err := parseAACConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	switch c.ObjectType {
+	case 2, 5, 29: // LC, 以及以 LC 为核心层的 SBR/PS
+	default:
+		return nil, fmt.Errorf("aac object type %d not supported", c.ObjectType)
+	}
+	return &aacDecoder{config: c, sfIndex: aacSampleRateIndex(c.SampleRate), random: 0x1f2e3d4c}, nil
+}
+
+// aacSampleRateIndex 采样率对应的频带表下标, 非标准采样率取最接近的一档
+func aacSampleRateIndex(rate int) int {
+	for i, min := range []int{92017, 75132, 55426, 46009, 37566, 27713, 23004, 18783, 13856, 11502, 9391} {
+		if rate >= min {
+			return i
+		}
+	}
+	return 11
+}
+
+// Decode 实现 AudioDecoder, 输出 config 声道数的交织 PCM, 多余的声道丢弃
+func (d *aacDecoder) Decode(frame []byte) ([]int16, error) {
+	r := &bitReader{data: frame}
+	out := make([]int16, aacFrameLength*d.config.Channels)
+	channel := 0
+	for {
+		id, err := r.readBits(3)
+		if err != nil {
+			return nil, err
+		}
+		switch id {
+		case aacElementSCE, aacElementLFE:
+			if _, err := r.readBits(4); err != nil {
+				return nil, err
+			}
+			ics := &aacICS{}
+			if err := d.readICS(r, ics, false); err != nil {
+				return nil, err
+			}
+			d.dequantize(ics)
+			d.applyTNS(ics)
+			d.output(out, channel, ics)
+			channel++
+		case aacElementCPE:
+			if err := d.readCPE(r, out, channel); err != nil {
+				return nil, err
+			}
+			channel += 2
+		case aacElementCCE:
+			return nil, errors.New("aac coupling channel element not supported")
+		case aacElementDSE:
+			if err := skipDSE(r); err != nil {
+				return nil, err
+			}
+		case aacElementPCE:
+			if err := skipPCE(r); err != nil {
+				return nil, err
+			}
+		case aacElementFIL:
+			if err := skipFIL(r); err != nil {
+				return nil, err
+			}
+		case aacElementEND:
+			if channel == 0 {
+				return nil, errors.New("aac frame without audio elements")
+			}
+			return out, nil
+		}
+	}
+}
+
+// readCPE channel_pair_element, common_window 时读取 M/S 信息并做立体声处理
+func (d *aacDecoder) readCPE(r *bitReader, out []int16, channel int) error {
+	if _, err := r.readBits(4); err != nil {
+		return err
+	}
+	common, err := r.readBits(1)
+	if err != nil {
+		return err
+	}
+	left, right := &aacICS{}, &aacICS{}
+	msMask := 0
+	var msUsed [8][64]bool
+	if common == 1 {
+		if err := d.readICSInfo(r, left); err != nil {
+			return err
+		}
+		right.copyInfo(left)
+		if msMask, err = r.readBits(2); err != nil {
+			return err
+		}
+		if msMask == 1 {
+			for g := 0; g < left.numGroups; g++ {
+				for sfb := 0; sfb < left.maxSFB; sfb++ {
+					bit, err := r.readBits(1)
+					if err != nil {
+						return err
+					}
+					msUsed[g][sfb] = bit == 1
+				}
+			}
+		}
+	}
+	if err := d.readICS(r, left, common == 1); err != nil {
+		return err
+	}
+	if err := d.readICS(r, right, common == 1); err != nil {
+		return err
+	}
+	d.dequantize(left)
+	d.dequantize(right)
+	if common == 1 {
+		applyMS(left, right, msMask, &msUsed)
+		applyIntensity(left, right, msMask, &msUsed)
+	}
+	d.applyTNS(left)
+	d.applyTNS(right)
+	d.output(out, channel, left)
+	d.output(out, channel+1, right)
+	return nil
+}
+
+// copyInfo 复制 common_window 共用的 ics_info
+func (ics *aacICS) copyInfo(from *aacICS) {
+	ics.windowSequence = from.windowSequence
+	ics.windowShape = from.windowShape
+	ics.maxSFB = from.maxSFB
+	ics.numWindows = from.numWindows
+	ics.numGroups = from.numGroups
+	ics.groupLength = from.groupLength
+	ics.swbOffset = from.swbOffset
+}
+
+// readICSInfo ics_info
+func (d *aacDecoder) readICSInfo(r *bitReader, ics *aacICS) (err error) {
+	if _, err = r.readBits(1); err != nil {
+		return
+	}
+	if ics.windowSequence, err = r.readBits(2); err != nil {
+		return
+	}
+	if ics.windowShape, err = r.readBits(1); err != nil {
+		return
+	}
+	ics.numGroups = 1
+	ics.groupLength[0] = 1
+	if ics.windowSequence == aacEightShort {
+		if ics.maxSFB, err = r.readBits(4); err != nil {
+			return
+		}
+		grouping, err := r.readBits(7)
+		if err != nil {
+			return err
+		}
+		ics.numWindows = 8
+		for i := 6; i >= 0; i-- {
+			if grouping>>uint(i)&1 == 1 {
+				ics.groupLength[ics.numGroups-1]++
+			} else {
+				ics.numGroups++
+				ics.groupLength[ics.numGroups-1] = 1
+			}
+		}
+		ics.swbOffset = aacSWBOffsetShort[d.sfIndex]
+	} else {
+		if ics.maxSFB, err = r.readBits(6); err != nil {
+			return
+		}
+		predictor, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		if predictor == 1 {
+			return errors.New("aac prediction not supported")
+		}
+		ics.numWindows = 1
+		ics.swbOffset = aacSWBOffsetLong[d.sfIndex]
+	}
+	if ics.maxSFB > len(ics.swbOffset)-1 {
+		return errAACBitstream
+	}
+	return nil
+}
+
+// readICS individual_channel_stream, common 为 true 时 ics_info 已在 CPE 中读取
+func (d *aacDecoder) readICS(r *bitReader, ics *aacICS, common bool) error {
+	globalGain, err := r.readBits(8)
+	if err != nil {
+		return err
+	}
+	if !common {
+		if err := d.readICSInfo(r, ics); err != nil {
+			return err
+		}
+	}
+	if err := ics.readSections(r); err != nil {
+		return err
+	}
+	if err := ics.readScaleFactors(r, globalGain); err != nil {
+		return err
+	}
+
+	var pulseOffsets, pulseAmps []int
+	pulse, err := r.readBits(1)
+	if err != nil {
+		return err
+	}
+	if pulse == 1 {
+		if ics.windowSequence == aacEightShort {
+			return errAACBitstream
+		}
+		if pulseOffsets, pulseAmps, err = ics.readPulses(r); err != nil {
+			return err
+		}
+	}
+	tns, err := r.readBits(1)
+	if err != nil {
+		return err
+	}
+	if tns == 1 {
+		if err := ics.readTNS(r); err != nil {
+			return err
+		}
+	}
+	gainControl, err := r.readBits(1)
+	if err != nil {
+		return err
+	}
+	if gainControl == 1 {
+		return errors.New("aac gain control not supported")
+	}
+	if err := ics.readSpectral(r); err != nil {
+		return err
+	}
+	for i, k := range pulseOffsets {
+		if ics.quant[k] > 0 {
+			ics.quant[k] += pulseAmps[i]
+		} else {
+			ics.quant[k] -= pulseAmps[i]
+		}
+	}
+	return nil
+}
+
+// readSections section_data, 为每个 (窗组, 频带) 记录码本
+func (ics *aacICS) readSections(r *bitReader) error {
+	bits := 5
+	if ics.windowSequence == aacEightShort {
+		bits = 3
+	}
+	escape := 1<<uint(bits) - 1
+	for g := 0; g < ics.numGroups; g++ {
+		for k := 0; k < ics.maxSFB; {
+			cb, err := r.readBits(4)
+			if err != nil {
+				return err
+			}
+			if cb == 12 {
+				return errAACBitstream
+			}
+			length := 0
+			for {
+				incr, err := r.readBits(bits)
+				if err != nil {
+					return err
+				}
+				length += incr
+				if incr != escape {
+					break
+				}
+			}
+			if length == 0 || k+length > ics.maxSFB {
+				return errAACBitstream
+			}
+			for ; length > 0; length-- {
+				ics.bandType[g][k] = cb
+				k++
+			}
+		}
+	}
+	return nil
+}
+
+// readScaleFactors scale_factor_data, 强度立体声与噪声频带分别累加各自的差分
+func (ics *aacICS) readScaleFactors(r *bitReader, globalGain int) error {
+	sf, position, noise := globalGain, 0, globalGain-90
+	noiseFirst := true
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cb := ics.bandType[g][sfb]
+			if cb == aacZeroHCB {
+				continue
+			}
+			if cb == aacNoiseHCB && noiseFirst {
+				delta, err := r.readBits(9)
+				if err != nil {
+					return err
+				}
+				noise += delta - 256
+				noiseFirst = false
+				ics.scaleFactor[g][sfb] = noise
+				continue
+			}
+			symbol, err := aacScaleFactorTree.decode(r)
+			if err != nil {
+				return err
+			}
+			delta := symbol - 60
+			switch cb {
+			case aacIntensityHCB, aacIntensityHCB2:
+				position += delta
+				ics.scaleFactor[g][sfb] = position
+			case aacNoiseHCB:
+				noise += delta
+				ics.scaleFactor[g][sfb] = noise
+			default:
+				sf += delta
+				if sf < 0 || sf > 255 {
+					return errAACBitstream
+				}
+				ics.scaleFactor[g][sfb] = sf
+			}
+		}
+	}
+	return nil
+}
+
+// readPulses pulse_data, 返回脉冲所在的频谱下标与幅度
+func (ics *aacICS) readPulses(r *bitReader) (offsets, amps []int, err error) {
+	count, err := r.readBits(2)
+	if err != nil {
+		return nil, nil, err
+	}
+	start, err := r.readBits(6)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start >= len(ics.swbOffset)-1 {
+		return nil, nil, errAACBitstream
+	}
+	k := ics.swbOffset[start]
+	for i := 0; i <= count; i++ {
+		offset, err := r.readBits(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		amp, err := r.readBits(4)
+		if err != nil {
+			return nil, nil, err
+		}
+		k += offset
+		if k >= aacFrameLength {
+			return nil, nil, errAACBitstream
+		}
+		offsets = append(offsets, k)
+		amps = append(amps, amp)
+	}
+	return offsets, amps, nil
+}
+
+// readTNS tns_data, 读取时即换算为 LPC 系数
+func (ics *aacICS) readTNS(r *bitReader) error {
+	short := ics.windowSequence == aacEightShort
+	filterBits, lengthBits, orderBits := 2, 6, 5
+	if short {
+		filterBits, lengthBits, orderBits = 1, 4, 3
+	}
+	tns := &ics.tns
+	tns.present = true
+	for w := 0; w < ics.numWindows; w++ {
+		filters, err := r.readBits(filterBits)
+		if err != nil {
+			return err
+		}
+		tns.filters[w] = filters
+		if filters == 0 {
+			continue
+		}
+		coefRes, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		for f := 0; f < filters; f++ {
+			if tns.length[w][f], err = r.readBits(lengthBits); err != nil {
+				return err
+			}
+			order, err := r.readBits(orderBits)
+			if err != nil {
+				return err
+			}
+			tns.order[w][f] = order
+			if order == 0 {
+				continue
+			}
+			direction, err := r.readBits(1)
+			if err != nil {
+				return err
+			}
+			tns.direction[w][f] = direction == 1
+			compress, err := r.readBits(1)
+			if err != nil {
+				return err
+			}
+			resBits := coefRes + 3
+			coefBits := resBits - compress
+			iqfac := (float64(int(1)<<uint(resBits-1)) - 0.5) / (math.Pi / 2)
+			iqfacM := (float64(int(1)<<uint(resBits-1)) + 0.5) / (math.Pi / 2)
+			var parcor [32]float64
+			for i := 0; i < order; i++ {
+				value, err := r.readBits(coefBits)
+				if err != nil {
+					return err
+				}
+				if value >= 1<<uint(coefBits-1) {
+					value -= 1 << uint(coefBits)
+				}
+				if value >= 0 {
+					parcor[i] = math.Sin(float64(value) / iqfac)
+				} else {
+					parcor[i] = math.Sin(float64(value) / iqfacM)
+				}
+			}
+			// PARCOR 转 LPC (ISO 14496-3 4.6.9.3)
+			lpc := &tns.lpc[w][f]
+			lpc[0] = 1
+			var b [32]float64
+			for m := 1; m <= order; m++ {
+				for i := 1; i < m; i++ {
+					b[i] = lpc[i] + parcor[m-1]*lpc[m-i]
+				}
+				for i := 1; i < m; i++ {
+					lpc[i] = b[i]
+				}
+				lpc[m] = parcor[m-1]
+			}
+		}
+	}
+	return nil
+}
+
+// readSpectral spectral_data, 同一窗组内按频带, 窗, 频率的顺序排列
+func (ics *aacICS) readSpectral(r *bitReader) error {
+	first := 0
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cb := ics.bandType[g][sfb]
+			if cb == aacZeroHCB || cb >= aacNoiseHCB {
+				continue
+			}
+			shape := aacCodebookShape[cb-1]
+			for w := first; w < first+ics.groupLength[g]; w++ {
+				end := w*128 + ics.swbOffset[sfb+1]
+				for k := w*128 + ics.swbOffset[sfb]; k < end; k += shape.dim {
+					if err := readCodeword(r, cb, ics.quant[k:k+shape.dim]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		first += ics.groupLength[g]
+	}
+	return nil
+}
+
+// readCodeword 读取一个频谱码字及其符号位与转义值
+func readCodeword(r *bitReader, cb int, values []int) error {
+	shape := aacCodebookShape[cb-1]
+	symbol, err := aacSpectralTrees[cb-1].decode(r)
+	if err != nil {
+		return err
+	}
+	for i := shape.dim - 1; i >= 0; i-- {
+		values[i] = symbol % shape.mod
+		symbol /= shape.mod
+		if shape.signed {
+			values[i] -= shape.mod / 2
+		}
+	}
+	if shape.signed {
+		return nil
+	}
+	for i, v := range values {
+		if v == 0 {
+			continue
+		}
+		sign, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		if sign == 1 {
+			values[i] = -v
+		}
+	}
+	if cb != aacEscHCB {
+		return nil
+	}
+	for i, v := range values {
+		if v != 16 && v != -16 {
+			continue
+		}
+		n := 0
+		for {
+			bit, err := r.readBits(1)
+			if err != nil {
+				return err
+			}
+			if bit == 0 {
+				break
+			}
+			if n++; n > 8 {
+				return errAACBitstream
+			}
+		}
+		escape, err := r.readBits(n + 4)
+		if err != nil {
+			return err
+		}
+		escape += 1 << uint(n+4)
+		if v < 0 {
+			escape = -escape
+		}
+		values[i] = escape
+	}
+	return nil
+}
+
+// dequantize 反量化并填充噪声频带
+func (d *aacDecoder) dequantize(ics *aacICS) {
+	first := 0
+	for g := 0; g < ics.numGroups; g++ {
+		for sfb := 0; sfb < ics.maxSFB; sfb++ {
+			cb := ics.bandType[g][sfb]
+			if cb == aacZeroHCB || cb == aacIntensityHCB || cb == aacIntensityHCB2 {
+				continue
+			}
+			for w := first; w < first+ics.groupLength[g]; w++ {
+				band := ics.spec[w*128+ics.swbOffset[sfb] : w*128+ics.swbOffset[sfb+1]]
+				if cb == aacNoiseHCB {
+					d.fillNoise(band, ics.scaleFactor[g][sfb])
+					continue
+				}
+				quant := ics.quant[w*128+ics.swbOffset[sfb] : w*128+ics.swbOffset[sfb+1]]
+				gain := math.Pow(2, 0.25*float64(ics.scaleFactor[g][sfb]-100))
+				for i, q := range quant {
+					band[i] = dequantize(q) * gain
+				}
+			}
+		}
+		first += ics.groupLength[g]
+	}
+}
+
+// dequantize sign(q) * |q|^(4/3)
+func dequantize(q int) float64 {
+	if q < 0 {
+		return -math.Pow(float64(-q), 4.0/3)
+	}
+	return math.Pow(float64(q), 4.0/3)
+}
+
+// fillNoise 感知噪声替代 (PNS), 频带能量为 2^(nrg/2)
+func (d *aacDecoder) fillNoise(band []float64, nrg int) {
+	energy := 0.0
+	for i := range band {
+		d.random = d.random*1664525 + 1013904223
+		band[i] = float64(int32(d.random))
+		energy += band[i] * band[i]
+	}
+	if energy == 0 {
+		return
+	}
+	scale := math.Pow(2, 0.25*float64(nrg)) / math.Sqrt(energy)
+	for i := range band {
+		band[i] *= scale
+	}
+}
+
+// applyMS M/S 立体声, 强度与噪声频带除外
+func applyMS(left, right *aacICS, msMask int, msUsed *[8][64]bool) {
+	if msMask == 0 {
+		return
+	}
+	first := 0
+	for g := 0; g < left.numGroups; g++ {
+		for sfb := 0; sfb < left.maxSFB; sfb++ {
+			if msMask == 1 && !msUsed[g][sfb] {
+				continue
+			}
+			if left.bandType[g][sfb] == aacNoiseHCB || right.bandType[g][sfb] >= aacNoiseHCB {
+				continue
+			}
+			for w := first; w < first+left.groupLength[g]; w++ {
+				for k := w*128 + left.swbOffset[sfb]; k < w*128+left.swbOffset[sfb+1]; k++ {
+					l, r := left.spec[k], right.spec[k]
+					left.spec[k], right.spec[k] = l+r, l-r
+				}
+			}
+		}
+		first += left.groupLength[g]
+	}
+}
+
+// applyIntensity 强度立体声, 右声道由左声道按 0.5^(position/4) 缩放得到
+func applyIntensity(left, right *aacICS, msMask int, msUsed *[8][64]bool) {
+	first := 0
+	for g := 0; g < right.numGroups; g++ {
+		for sfb := 0; sfb < right.maxSFB; sfb++ {
+			cb := right.bandType[g][sfb]
+			if cb != aacIntensityHCB && cb != aacIntensityHCB2 {
+				continue
+			}
+			scale := math.Pow(0.5, 0.25*float64(right.scaleFactor[g][sfb]))
+			if cb == aacIntensityHCB2 {
+				scale = -scale
+			}
+			if msMask == 1 && msUsed[g][sfb] {
+				scale = -scale
+			}
+			for w := first; w < first+right.groupLength[g]; w++ {
+				for k := w*128 + right.swbOffset[sfb]; k < w*128+right.swbOffset[sfb+1]; k++ {
+					right.spec[k] = left.spec[k] * scale
+				}
+			}
+		}
+		first += right.groupLength[g]
+	}
+}
+
+// applyTNS 按滤波方向对频谱做全极点滤波, 在 M/S 与强度立体声之后
+func (d *aacDecoder) applyTNS(ics *aacICS) {
+	if !ics.tns.present {
+		return
+	}
+	maxBands, maxOrder := aacTNSMaxBandsLong[d.sfIndex], aacTNSMaxOrderLong
+	if ics.windowSequence == aacEightShort {
+		maxBands, maxOrder = aacTNSMaxBandsShort[d.sfIndex], aacTNSMaxOrderShort
+	}
+	if maxBands > ics.maxSFB {
+		maxBands = ics.maxSFB
+	}
+	numSWB := len(ics.swbOffset) - 1
+	tns := &ics.tns
+	for w := 0; w < ics.numWindows; w++ {
+		bottom := numSWB
+		for f := 0; f < tns.filters[w]; f++ {
+			top := bottom
+			bottom = top - tns.length[w][f]
+			if bottom < 0 {
+				bottom = 0
+			}
+			order := tns.order[w][f]
+			if order > maxOrder {
+				order = maxOrder
+			}
+			if order == 0 {
+				continue
+			}
+			start := ics.swbOffset[minInt(bottom, maxBands)]
+			end := ics.swbOffset[minInt(top, maxBands)]
+			size := end - start
+			if size <= 0 {
+				continue
+			}
+			pos, inc := w*128+start, 1
+			if tns.direction[w][f] {
+				pos, inc = w*128+end-1, -1
+			}
+			lpc := &tns.lpc[w][f]
+			for m := 0; m < size; m++ {
+				y := ics.spec[pos]
+				for i := 1; i <= order && i <= m; i++ {
+					y -= lpc[i] * ics.spec[pos-i*inc]
+				}
+				ics.spec[pos] = y
+				pos += inc
+			}
+		}
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// output 滤波器组 (IMDCT, 加窗, 重叠相加) 后写入交织 PCM
+func (d *aacDecoder) output(out []int16, channel int, ics *aacICS) {
+	for len(d.channels) <= channel {
+		d.channels = append(d.channels, &aacChannel{})
+	}
+	state := d.channels[channel]
+	var block [2 * aacFrameLength]float64
+	synthesize(ics, state.windowShape, &block)
+	state.windowShape = ics.windowShape
+	if channel >= d.config.Channels {
+		copy(state.overlap[:], block[aacFrameLength:])
+		return
+	}
+	for n := 0; n < aacFrameLength; n++ {
+		out[n*d.config.Channels+channel] = clamp16(math.Round(block[n] + state.overlap[n]))
+	}
+	copy(state.overlap[:], block[aacFrameLength:])
+}
+
+// synthesize 按窗序列做 IMDCT 并加窗, 输出 2048 点待重叠的块
+func synthesize(ics *aacICS, prevShape int, block *[2 * aacFrameLength]float64) {
+	longPrev, longCur := aacLongWindows[prevShape], aacLongWindows[ics.windowShape]
+	shortPrev, shortCur := aacShortWindows[prevShape], aacShortWindows[ics.windowShape]
+	if ics.windowSequence == aacEightShort {
+		var x [256]float64
+		for w := 0; w < 8; w++ {
+			imdct(ics.spec[w*128:(w+1)*128], x[:])
+			rise := shortCur
+			if w == 0 {
+				rise = shortPrev
+			}
+			offset := 448 + 128*w
+			for n := 0; n < 128; n++ {
+				block[offset+n] += x[n] * rise[n]
+				block[offset+128+n] += x[128+n] * shortCur[127-n]
+			}
+		}
+		return
+	}
+	var x [2 * aacFrameLength]float64
+	imdct(ics.spec[:], x[:])
+	switch ics.windowSequence {
+	case aacOnlyLong, aacLongStart:
+		for n := 0; n < aacFrameLength; n++ {
+			block[n] = x[n] * longPrev[n]
+		}
+	case aacLongStop:
+		for n := 448; n < 576; n++ {
+			block[n] = x[n] * shortPrev[n-448]
+		}
+		for n := 576; n < aacFrameLength; n++ {
+			block[n] = x[n]
+		}
+	}
+	switch ics.windowSequence {
+	case aacOnlyLong, aacLongStop:
+		for n := 0; n < aacFrameLength; n++ {
+			block[aacFrameLength+n] = x[aacFrameLength+n] * longCur[aacFrameLength-1-n]
+		}
+	case aacLongStart:
+		for n := 1024; n < 1472; n++ {
+			block[n] = x[n]
+		}
+		for n := 1472; n < 1600; n++ {
+			block[n] = x[n] * shortCur[127-(n-1472)]
+		}
+	}
+}
+
+// 窗函数的上升半窗, 下标为 window_shape (0 正弦窗, 1 KBD 窗)
+var (
+	aacLongWindows  = [2][]float64{sineWindow(2048), kbdWindow(2048, 4)}
+	aacShortWindows = [2][]float64{sineWindow(256), kbdWindow(256, 6)}
+)
+
+// sineWindow 长度 n 的正弦窗的前半
+func sineWindow(n int) []float64 {
+	w := make([]float64, n/2)
+	for i := range w {
+		w[i] = math.Sin(math.Pi / float64(n) * (float64(i) + 0.5))
+	}
+	return w
+}
+
+// kbdWindow 长度 n 的 Kaiser-Bessel 派生窗的前半
+func kbdWindow(n int, alpha float64) []float64 {
+	kernel := make([]float64, n/2+1)
+	total := 0.0
+	for i := range kernel {
+		x := float64(4*i)/float64(n) - 1
+		kernel[i] = besselI0(math.Pi * alpha * math.Sqrt(1-x*x))
+		total += kernel[i]
+	}
+	w := make([]float64, n/2)
+	sum := 0.0
+	for i := range w {
+		sum += kernel[i]
+		w[i] = math.Sqrt(sum / total)
+	}
+	return w
+}
+
+// besselI0 第一类零阶修正贝塞尔函数
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > sum*1e-12; k++ {
+		term *= (x / 2 / float64(k)) * (x / 2 / float64(k))
+		sum += term
+	}
+	return sum
+}
+
+// imdct 逆 MDCT: len(out) = 2*len(spec), 含 2/N 归一化, 输出即 16 位 PCM 幅度
+// y[n] = 2/N * sum X[k] cos(2π/N (n + N/4 + 1/2)(k + 1/2)), 由 N/2 点 DCT-IV 展开得到
+func imdct(spec, out []float64) {
+	m := len(spec)
+	u := dct4(spec)
+	scale := 2 / float64(2*m)
+	for n := 0; n < m/2; n++ {
+		out[n] = u[n+m/2] * scale
+	}
+	for n := m / 2; n < 3*m/2; n++ {
+		out[n] = -u[3*m/2-1-n] * scale
+	}
+	for n := 3 * m / 2; n < 2*m; n++ {
+		out[n] = -u[n-3*m/2] * scale
+	}
+}
+
+// dct4 DCT-IV, 用 len/2 点复数 FFT 计算
+func dct4(x []float64) []float64 {
+	m := len(x)
+	z := make([]complex128, m/2)
+	for j := range z {
+		z[j] = complex(x[2*j], x[m-1-2*j]) * cmplx.Exp(complex(0, -math.Pi*float64(j)/float64(m)))
+	}
+	fft(z)
+	u := make([]float64, m)
+	for p, v := range z {
+		v *= cmplx.Exp(complex(0, -math.Pi*(float64(p)+0.25)/float64(m)))
+		u[2*p] = real(v)
+		u[m-1-2*p] = -imag(v)
+	}
+	return u
+}
+
+// fft 原地基 2 复数 FFT, len(a) 须为 2 的幂
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even, odd := a[start+k], a[start+k+size/2]*w
+				a[start+k], a[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
+
+// skipDSE data_stream_element
+func skipDSE(r *bitReader) error {
+	if _, err := r.readBits(4); err != nil {
+		return err
+	}
+	align, err := r.readBits(1)
+	if err != nil {
+		return err
+	}
+	count, err := r.readBits(8)
+	if err != nil {
+		return err
+	}
+	if count == 255 {
+		extra, err := r.readBits(8)
+		if err != nil {
+			return err
+		}
+		count += extra
+	}
+	if align == 1 {
+		r.byteAlign()
+	}
+	return r.skip(8 * count)
+}
+
+// skipPCE program_config_element, 声道布局以 AudioSpecificConfig 为准
+func skipPCE(r *bitReader) error {
+	// element_instance_tag, object_type, sampling_frequency_index
+	if err := r.skip(4 + 2 + 4); err != nil {
+		return err
+	}
+	counts := make([]int, 6)
+	for i, bits := range []int{4, 4, 4, 2, 3, 4} {
+		var err error
+		if counts[i], err = r.readBits(bits); err != nil {
+			return err
+		}
+	}
+	for _, bits := range []int{4, 4, 3} {
+		present, err := r.readBits(1)
+		if err != nil {
+			return err
+		}
+		if present == 1 {
+			if err := r.skip(bits); err != nil {
+				return err
+			}
+		}
+	}
+	// front/side/back 各 5 位, lfe 4 位, assoc data 4 位, cc 5 位
+	elementBits := 5*(counts[0]+counts[1]+counts[2]) + 4*(counts[3]+counts[4]) + 5*counts[5]
+	if err := r.skip(elementBits); err != nil {
+		return err
+	}
+	r.byteAlign()
+	comment, err := r.readBits(8)
+	if err != nil {
+		return err
+	}
+	return r.skip(8 * comment)
+}
+
+// skipFIL fill_element, SBR 等扩展数据不解码
+func skipFIL(r *bitReader) error {
+	count, err := r.readBits(4)
+	if err != nil {
+		return err
+	}
+	if count == 15 {
+		extra, err := r.readBits(8)
+		if err != nil {
+			return err
+		}
+		count += extra - 1
+	}
+	return r.skip(8 * count)
+}
@@ -0,0 +1,209 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
+)
+
+func TestAACHuffmanTables(t *testing.T) {
+	trees := append(aacSpectralTrees[:], aacScaleFactorTree)
+	for i, tree := range trees {
+		// 码表必须是完备的前缀码: 每个内部节点都有两个子节点, 叶子数等于符号数
+		leaves := 0
+		for _, node := range tree {
+			for _, child := range node {
+				if child == 0 {
+					t.Errorf("codebook %d: incomplete tree", i+1)
+				}
+				if child < 0 {
+					leaves++
+				}
+			}
+		}
+		symbols := len(aacScaleFactorBits)
+		if i < len(aacSpectralBits) {
+			symbols = len(aacSpectralBits[i])
+		}
+		if leaves != symbols {
+			t.Errorf("codebook %d: %d leaves for %d symbols", i+1, leaves, symbols)
+		}
+	}
+}
+
+func TestAACSWBOffsets(t *testing.T) {
+	for index := range aacSampleRates {
+		for _, table := range []struct {
+			offsets []int
+			length  int
+		}{{aacSWBOffsetLong[index], 1024}, {aacSWBOffsetShort[index], 128}} {
+			offsets := table.offsets
+			if offsets[0] != 0 || offsets[len(offsets)-1] != table.length {
+				t.Errorf("index %d: offsets %v", index, offsets)
+			}
+			for i := 1; i < len(offsets); i++ {
+				if width := offsets[i] - offsets[i-1]; width <= 0 || width%4 != 0 {
+					t.Errorf("index %d: band %d width %d", index, i-1, width)
+				}
+			}
+		}
+	}
+}
+
+func TestIMDCT(t *testing.T) {
+	for _, m := range []int{128, 1024} {
+		spec := make([]float64, m)
+		for k := range spec {
+			spec[k] = rand.Float64()*2 - 1
+		}
+		out := make([]float64, 2*m)
+		imdct(spec, out)
+		n := float64(2 * m)
+		for i := range out {
+			want := 0.0
+			for k, x := range spec {
+				want += x * math.Cos(2*math.Pi/n*(float64(i)+n/4+0.5)*(float64(k)+0.5))
+			}
+			want *= 2 / n
+			if math.Abs(out[i]-want) > 1e-9 {
+				t.Fatalf("m=%d: out[%d] = %g, want %g", m, i, out[i], want)
+			}
+		}
+	}
+}
+
+func TestAACDecodeSilence(t *testing.T) {
+	// 常见编码器输出的 AAC-LC 44.1kHz 立体声静音帧
+	decoder, err := newAACDecoder([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pcm, err := decoder.Decode([]byte{0x21, 0x10, 0x04, 0x60, 0x8c, 0x1c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm) != 2*aacFrameLength {
+		t.Fatalf("got %d samples", len(pcm))
+	}
+	for i, v := range pcm {
+		if v != 0 {
+			t.Fatalf("sample %d = %d", i, v)
+		}
+	}
+}
+
+// testToneBin testToneAU 中的频谱下标, 16kHz 下约 1004Hz
+const testToneBin = 128
+
+// testToneAU 构造 16kHz 单声道 AU: 长窗, 只有 testToneBin 一根谱线, 用码本 11 的转义值编码
+func testToneAU() []byte {
+	w := &bitWriter{}
+	w.u(aacElementSCE, 3).u(0, 4)
+	w.u(156, 8)                         // global_gain
+	w.u(0, 1).u(aacOnlyLong, 2).u(0, 1) // ics_info
+	w.u(15, 6).u(0, 1)                  // max_sfb, predictor_data_present
+	w.u(aacZeroHCB, 4).u(14, 5)         // 频带 0-13 为零
+	w.u(aacEscHCB, 4).u(1, 5)           // 频带 14 (124-136) 用码本 11
+	w.u(int(aacScaleFactorCodes[60]), int(aacScaleFactorBits[60]))
+	w.u(0, 1).u(0, 1).u(0, 1) // pulse, tns, gain control
+	codes, bits := aacSpectralCodes[aacEscHCB-1], aacSpectralBits[aacEscHCB-1]
+	for k := 124; k < 136; k += 2 {
+		if k != testToneBin {
+			w.u(int(codes[0]), int(bits[0]))
+			continue
+		}
+		// (16, 0), 正号, 转义 100 = 2^6 + 36
+		w.u(int(codes[16*17]), int(bits[16*17])).u(0, 1)
+		w.u(0x6, 3).u(36, 6)
+	}
+	w.u(aacElementEND, 3)
+	return w.data
+}
+
+// tonePower Goertzel 算法求 freq 处的能量
+func tonePower(pcm []float64, rate, freq float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*freq/rate)
+	var s1, s2 float64
+	for _, x := range pcm {
+		s1, s2 = x+coeff*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+func TestAACDecodeTone(t *testing.T) {
+	decoder, err := newAACDecoder(testAACConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	au := testToneAU()
+	var pcm []int16
+	for i := 0; i < 3; i++ {
+		if pcm, err = decoder.Decode(au); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samples := make([]float64, len(pcm))
+	energy := 0.0
+	for i, v := range pcm {
+		samples[i] = float64(v)
+		energy += samples[i] * samples[i]
+	}
+	if rms := math.Sqrt(energy / float64(len(pcm))); rms < 1000 {
+		t.Errorf("rms = %.0f", rms)
+	}
+	tone := float64(testToneBin) + 0.5
+	freq := tone * 16000 / 2048
+	if on, off := tonePower(samples, 16000, freq), tonePower(samples, 16000, 2*freq); on < 100*off {
+		t.Errorf("power at %.0fHz = %g, at %.0fHz = %g", freq, on, 2*freq, off)
+	}
+}
+
+// mulawToLinear µ-law 转 16 位线性 PCM
+func mulawToLinear(u byte) int16 {
+	u = ^u
+	sample := (int(u&0x0F)<<3 + 0x84) << (u >> 4 & 7)
+	sample -= 0x84
+	if u&0x80 != 0 {
+		return int16(-sample)
+	}
+	return int16(sample)
+}
+
+func TestAudioTranscoderAAC(t *testing.T) {
+	// 默认注册的解码器: AAC AU 经解码, 重采样到 8kHz 后输出浏览器可播放的 PCMU
+	info := sdp.Info{Type: av.AAC, Config: testAACConfig, SizeLength: 13, IndexLength: 3, IndexDeltaLength: 3}
+	transcoder, err := newAudioTranscoder(info, TranscodePCMU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	au := testToneAU()
+	payload := append([]byte{0x00, 0x10, byte(len(au) >> 5), byte(len(au) << 3)}, au...)
+	var pcm []float64
+	for i := 0; i < 4; i++ {
+		samples, err := transcoder.transcode(&rtp.Packet{Header: rtp.Header{Marker: true}, Payload: payload})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, sample := range samples {
+			if len(sample.Data) != g711FrameSize || sample.Samples != g711FrameSize {
+				t.Fatalf("sample size %d/%d", len(sample.Data), sample.Samples)
+			}
+			for _, u := range sample.Data {
+				pcm = append(pcm, float64(mulawToLinear(u)))
+			}
+		}
+	}
+	// 4 个 AU 共 4096 个 16kHz 采样, 8kHz 下 12 个完整的 20ms 帧
+	if len(pcm) != 12*g711FrameSize {
+		t.Fatalf("got %d samples", len(pcm))
+	}
+	steady := pcm[len(pcm)/2:]
+	freq := (float64(testToneBin) + 0.5) * 16000 / 2048
+	if on, off := tonePower(steady, g711SampleRate, freq), tonePower(steady, g711SampleRate, 3000); on < 100*off {
+		t.Errorf("power at %.0fHz = %g, at 3000Hz = %g", freq, on, off)
+	}
+}
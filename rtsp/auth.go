@@ -0,0 +1,237 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ErrUnsupportedAuth 服务器没有提供支持的认证方式
+var ErrUnsupportedAuth = errors.New("no supported www-authenticate challenge")
+
+// authChallenge WWW-Authenticate 中的一个质询 (RFC 7235), 参数名为小写
+type authChallenge struct {
+	Scheme string
+	Params map[string]string
+}
+
+// parseChallenges 解析所有 WWW-Authenticate 头, 一个头中可以有多个以逗号分隔的质询
+func parseChallenges(values []string) (challenges []authChallenge) {
+	for _, value := range values {
+		lexer := &authLexer{input: value}
+		var current *authChallenge
+		for {
+			lexer.skip(" \t,")
+			token := lexer.token()
+			if token == "" {
+				break
+			}
+			lexer.skip(" \t")
+			if lexer.peek() != '=' {
+				// 新的质询
+				challenges = append(challenges, authChallenge{Scheme: token, Params: make(map[string]string)})
+				current = &challenges[len(challenges)-1]
+				continue
+			}
+			lexer.pos++
+			lexer.skip(" \t")
+			var param string
+			if lexer.peek() == '"' {
+				param = lexer.quoted()
+			} else {
+				param = lexer.token()
+			}
+			if current != nil {
+				current.Params[strings.ToLower(token)] = param
+			}
+		}
+	}
+	return
+}
+
+// authLexer 解析质询中的 token 与 quoted-string
+type authLexer struct {
+	input string
+	pos   int
+}
+
+func (l *authLexer) peek() byte {
+	if l.pos >= len(l.input) {
+		return 0
+	}
+	return l.input[l.pos]
+}
+
+func (l *authLexer) skip(chars string) {
+	for l.pos < len(l.input) && strings.IndexByte(chars, l.input[l.pos]) >= 0 {
+		l.pos++
+	}
+}
+
+// token 读取到空白, 逗号, 等号或引号为止
+func (l *authLexer) token() string {
+	start := l.pos
+	for l.pos < len(l.input) && strings.IndexByte(" \t,=\"", l.input[l.pos]) < 0 {
+		l.pos++
+	}
+	return l.input[start:l.pos]
+}
+
+// quoted 读取引号内的字符串, 处理反斜杠转义
+func (l *authLexer) quoted() string {
+	l.pos++
+	var out strings.Builder
+	for l.pos < len(l.input) {
+		c := l.input[l.pos]
+		l.pos++
+		switch {
+		case c == '\\' && l.pos < len(l.input):
+			out.WriteByte(l.input[l.pos])
+			l.pos++
+		case c == '"':
+			return out.String()
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// authenticator 按服务器的质询生成 Authorization 头
+type authenticator struct {
+	username  string
+	password  string
+	digest    bool
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string // 质询中的原始写法, 为空时按 MD5 计算且不回送
+	qop       string // "auth" 或空
+	sess      bool
+	hash      func() hash.Hash
+	nc        int
+	cnonce    string
+}
+
+// digestAlgorithms 支持的摘要算法, 越靠前越优先
+var digestAlgorithms = []struct {
+	name string
+	hash func() hash.Hash
+}{
+	{"SHA-256", sha256.New},
+	{"MD5", md5.New},
+}
+
+// newAuthenticator 从质询中选择认证方式: 优先 SHA-256 摘要, 其次 MD5 摘要, 最后 Basic
+func newAuthenticator(challenges []authChallenge, username, password string) (*authenticator, error) {
+	for _, algorithm := range digestAlgorithms {
+		for _, challenge := range challenges {
+			if !strings.EqualFold(challenge.Scheme, "Digest") {
+				continue
+			}
+			name := challenge.Params["algorithm"]
+			sess := strings.HasSuffix(strings.ToUpper(name), "-SESS")
+			base := strings.TrimSuffix(strings.ToUpper(name), "-SESS")
+			if base == "" {
+				base = "MD5"
+			}
+			if base != algorithm.name {
+				continue
+			}
+			qop := ""
+			if offered, ok := challenge.Params["qop"]; ok {
+				for _, option := range strings.Split(offered, ",") {
+					if strings.TrimSpace(strings.ToLower(option)) == "auth" {
+						qop = "auth"
+					}
+				}
+				if qop == "" {
+					// 只提供 auth-int
+					continue
+				}
+			}
+			return &authenticator{
+				username:  username,
+				password:  password,
+				digest:    true,
+				realm:     challenge.Params["realm"],
+				nonce:     challenge.Params["nonce"],
+				opaque:    challenge.Params["opaque"],
+				algorithm: name,
+				qop:       qop,
+				sess:      sess,
+				hash:      algorithm.hash,
+			}, nil
+		}
+	}
+	for _, challenge := range challenges {
+		if strings.EqualFold(challenge.Scheme, "Basic") {
+			return &authenticator{username: username, password: password}, nil
+		}
+	}
+	return nil, ErrUnsupportedAuth
+}
+
+// staleChallenge 质询中是否带 stale=true, 即只是 nonce 过期, 凭据本身正确
+func staleChallenge(challenges []authChallenge) bool {
+	for _, challenge := range challenges {
+		if strings.EqualFold(challenge.Scheme, "Digest") && strings.EqualFold(challenge.Params["stale"], "true") {
+			return true
+		}
+	}
+	return false
+}
+
+// header 生成一个请求的 Authorization 头 (含结尾 \r\n)
+func (a *authenticator) header(method, uri string) string {
+	if !a.digest {
+		return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(a.username+":"+a.password)) + "\r\n"
+	}
+	a.nc++
+	nc := fmt.Sprintf("%08x", a.nc)
+	if a.cnonce == "" {
+		buf := make([]byte, 8)
+		rand.Read(buf)
+		a.cnonce = hex.EncodeToString(buf)
+	}
+	ha1 := a.sum(a.username + ":" + a.realm + ":" + a.password)
+	if a.sess {
+		ha1 = a.sum(ha1 + ":" + a.nonce + ":" + a.cnonce)
+	}
+	ha2 := a.sum(method + ":" + uri)
+	var response string
+	if a.qop != "" {
+		response = a.sum(ha1 + ":" + a.nonce + ":" + nc + ":" + a.cnonce + ":" + a.qop + ":" + ha2)
+	} else {
+		response = a.sum(ha1 + ":" + a.nonce + ":" + ha2)
+	}
+	header := `Authorization: Digest username="` + quote(a.username) + `", realm="` + quote(a.realm) + `", nonce="` + quote(a.nonce) + `", uri="` + quote(uri) + `", response="` + response + `"`
+	if a.algorithm != "" {
+		header += ", algorithm=" + a.algorithm
+	}
+	if a.opaque != "" {
+		header += `, opaque="` + quote(a.opaque) + `"`
+	}
+	if a.qop != "" {
+		header += ", qop=" + a.qop + ", nc=" + nc + `, cnonce="` + a.cnonce + `"`
+	}
+	return header + "\r\n"
+}
+
+// sum 十六进制摘要
+func (a *authenticator) sum(text string) string {
+	h := a.hash()
+	h.Write([]byte(text))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// quote 转义 quoted-string 中的引号与反斜杠
+func quote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
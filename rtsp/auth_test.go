@@ -0,0 +1,165 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	challenges := parseChallenges([]string{
+		`Digest realm="cam, \"front\"", nonce="abc", qop="auth,auth-int", algorithm=SHA-256, Basic realm="cam"`,
+		`digest realm=x,nonce=y,stale=TRUE`,
+	})
+	if len(challenges) != 3 {
+		t.Fatalf("challenges = %+v", challenges)
+	}
+	if c := challenges[0]; c.Scheme != "Digest" || c.Params["realm"] != `cam, "front"` || c.Params["qop"] != "auth,auth-int" || c.Params["algorithm"] != "SHA-256" {
+		t.Errorf("digest = %+v", c)
+	}
+	if c := challenges[1]; c.Scheme != "Basic" || c.Params["realm"] != "cam" {
+		t.Errorf("basic = %+v", c)
+	}
+	if !staleChallenge(challenges[2:]) || staleChallenge(challenges[:2]) {
+		t.Error("stale flag not detected")
+	}
+
+	auth, err := newAuthenticator(challenges[:2], "u", "p")
+	if err != nil || !auth.digest || auth.qop != "auth" || auth.algorithm != "SHA-256" {
+		t.Errorf("selected %+v, %v", auth, err)
+	}
+	if _, err := newAuthenticator(parseChallenges([]string{`Digest realm="r", nonce="n", qop="auth-int"`}), "u", "p"); err != ErrUnsupportedAuth {
+		t.Errorf("auth-int only = %v", err)
+	}
+}
+
+// digestServer 校验 SHA-256 qop=auth 摘要的替身服务器, SETUP 第一次返回 stale nonce
+type digestServer struct {
+	mutex   sync.Mutex
+	nonce   string
+	staled  bool
+	headers []string
+	basic   bool
+}
+
+func (s *digestServer) handle(req *testutil.Request, conn net.Conn) (int, string, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	header := req.Header.Get("Authorization")
+	s.headers = append(s.headers, header)
+	challenge := func(stale string) string {
+		return "WWW-Authenticate: Digest realm=\"cam\", nonce=\"" + s.nonce + "\", algorithm=MD5, qop=\"auth\"\r\n" +
+			"WWW-Authenticate: Digest realm=\"cam\", nonce=\"" + s.nonce + "\", algorithm=SHA-256, qop=\"auth\", " + stale + "opaque=\"op\"\r\n" +
+			"WWW-Authenticate: Basic realm=\"cam\"\r\n"
+	}
+	if !s.valid(req.Method, req.URL, header) {
+		return 401, challenge(""), ""
+	}
+	switch req.Method {
+	case "DESCRIBE":
+		return 200, "", testutil.SDP
+	case "SETUP":
+		if !s.staled {
+			s.staled = true
+			s.nonce = "n2"
+			return 401, challenge("stale=true, "), ""
+		}
+		return 200, "Session: 1;timeout=60\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+	}
+	return 200, "", ""
+}
+
+func (s *digestServer) valid(method, uri, header string) bool {
+	if strings.Contains(header, "Basic") {
+		s.basic = true
+	}
+	challenges := parseChallenges([]string{header})
+	if len(challenges) != 1 || challenges[0].Scheme != "Digest" {
+		return false
+	}
+	p := challenges[0].Params
+	if p["algorithm"] != "SHA-256" || p["nonce"] != s.nonce || p["opaque"] != "op" || p["qop"] != "auth" || p["uri"] != uri {
+		return false
+	}
+	sum := func(text string) string {
+		h := sha256.Sum256([]byte(text))
+		return hex.EncodeToString(h[:])
+	}
+	ha1 := sum("admin:cam:secret")
+	ha2 := sum(method + ":" + uri)
+	return p["response"] == sum(ha1+":"+s.nonce+":"+p["nc"]+":"+p["cnonce"]+":auth:"+ha2)
+}
+
+func TestDigestAuth(t *testing.T) {
+	digest := &digestServer{nonce: "n1"}
+	server := testutil.NewRTSPServer(t, digest.handle)
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = strings.Replace(server.URL("/live"), "rtsp://", "rtsp://admin:secret@", 1)
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	digest.mutex.Lock()
+	defer digest.mutex.Unlock()
+	if digest.basic {
+		t.Error("basic credentials sent alongside digest")
+	}
+	// OPTIONS (无凭据) -> 401 -> OPTIONS, DESCRIBE, SETUP (stale) -> SETUP, PLAY
+	wantNC := []string{"", "00000001", "00000002", "00000003", "00000001", "00000002"}
+	if len(digest.headers) != len(wantNC) {
+		t.Fatalf("got %d requests: %q", len(digest.headers), digest.headers)
+	}
+	for i, header := range digest.headers {
+		if wantNC[i] == "" {
+			if header != "" {
+				t.Errorf("request %d sent credentials before challenge", i)
+			}
+			continue
+		}
+		if nc := parseChallenges([]string{header})[0].Params["nc"]; nc != wantNC[i] {
+			t.Errorf("request %d nc = %s, want %s", i, nc, wantNC[i])
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	var mutex sync.Mutex
+	var headers []string
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		headers = append(headers, req.Header.Get("Authorization"))
+		if req.Header.Get("Authorization") != "Basic YWRtaW46c2VjcmV0" {
+			return 401, "WWW-Authenticate: Basic realm=\"cam\"\r\n", ""
+		}
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			return 200, "Session: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = strings.Replace(server.URL("/live"), "rtsp://", "rtsp://admin:secret@", 1)
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	client = ClientNew()
+	client.URL = strings.Replace(server.URL("/live"), "rtsp://", "rtsp://admin:wrong@", 1)
+	if err := client.Open(); err == nil || !strings.Contains(err.Error(), "Authorization failed") {
+		t.Errorf("wrong password: %v", err)
+	}
+	client.Close()
+}
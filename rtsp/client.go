@@ -2,9 +2,8 @@ package rtsp
 
 import (
 	sdp "RTSPtoWebRTC/rtsp/sdp"
-	"crypto/md5"
-	"encoding/base64"
-	"encoding/hex"
+	"bufio"
+	"crypto/tls"
 	"errors"
 	"html"
 	"io"
@@ -12,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pion/webrtc/v2"
@@ -20,30 +20,61 @@ import (
 
 //Client 属性
 type Client struct {
-	URL           string          // rtsp 地址
-	Debug         bool            // 是否开启调试
-	VideoTracks   []*webrtc.Track // webrtc 对端
-	Name          string
-	rtspTimeOut   time.Duration
-	rtptimeout    time.Duration
-	keepalivetime int
-	cseq          int
-	uri           string
-	host          string
-	port          string
-	login         string
-	password      string
-	session       string
-	bauth         string
-	nonce         string
-	realm         string
-	sdp           string
-	track         []string
-	socket        net.Conn
-	firstvideots  int
-	firstaudiots  int
-	Signals       chan bool
-	Outgoing      chan []byte
+	URL                string          // rtsp 地址
+	Debug              bool            // 是否开启调试
+	VideoTracks        []*webrtc.Track // webrtc 对端
+	Name               string
+	rtspTimeOut        time.Duration
+	rtptimeout         time.Duration
+	keepalivetime      int // 保活间隔 (秒), SETUP 应答的 Session 带 timeout 时按其计算
+	cseq               int
+	uri                string
+	host               string
+	port               string
+	login              string
+	password           string
+	session            string
+	auth               *authenticator // 收到 401 质询后创建
+	sdp                string
+	track              []string
+	infos              []sdp.Info
+	socket             net.Conn
+	reader             *bufio.Reader // socket 的读缓冲, 应答与 interleaved 数据都从这里读取
+	firstvideots       int
+	firstaudiots       int
+	Signals            chan bool
+	Outgoing           chan []byte
+	Transport          string      // TransportTCP, TransportUDP 或 TransportMulticast
+	MulticastInterface string      // 加入组播的网卡名, 为空时由系统选择
+	KeyframeParameter  string      // 非空时通过 SET_PARAMETER 请求关键帧, 内容为请求体
+	TLSConfig          *tls.Config // rtsps 连接的 TLS 设置, 为空时按系统 CA 校验
+	secure             bool        // rtsps, 控制连接使用 TLS
+	tunnel             bool        // rtsp+http, 经 HTTP 隧道连接, 只能使用 TCP 传输
+	path               string      // 地址中的路径与查询, 隧道的 GET/POST 使用
+	keyframeRequests   chan struct{}
+	getParameter       bool           // OPTIONS 应答的 Public 中有 GET_PARAMETER, 用于保活
+	pending            map[int]string // 不等待应答的请求, 按 CSeq 索引
+	replies            chan *Response // UDP 模式下控制连接上收到的应答
+	rtcpState          *rtcpState
+	transport          transportHeader
+	keyMgmt            string                // 最近一次 SETUP 应答中的 KeyMgmt 头
+	srtp               map[byte]*srtpSession // RTP/SAVP 媒体的 SRTP 状态, 按媒体序号索引
+	udp                []*udpPair
+	udpFirstTimeout    time.Duration // UDP 首个 RTP 包的等待时间, 超时后回退 TCP
+	err                error         // 读循环退出原因
+	exitOnce           sync.Once
+	closed             chan struct{}
+	closeOnce          sync.Once
+}
+
+// StatusError RTSP 返回非 200 状态码
+type StatusError struct {
+	Method string
+	Code   int
+}
+
+func (e *StatusError) Error() string {
+	return "Method " + e.Method + " Return bad status code " + strconv.Itoa(e.Code)
 }
 
 func init() {
@@ -53,12 +84,19 @@ func init() {
 // ClientNew 新建客户端
 func ClientNew() *Client {
 	return &Client{
-		cseq:          1,
-		rtspTimeOut:   3,
-		rtptimeout:    10,
-		keepalivetime: 20,
-		Signals:       make(chan bool, 1),
-		Outgoing:      make(chan []byte, 100000)}
+		cseq:             1,
+		rtspTimeOut:      3,
+		rtptimeout:       10,
+		keepalivetime:    20,
+		Transport:        TransportTCP,
+		Signals:          make(chan bool, 1),
+		closed:           make(chan struct{}),
+		keyframeRequests: make(chan struct{}, 1),
+		pending:          make(map[int]string),
+		replies:          make(chan *Response, 8),
+		rtcpState:        newRTCPState(),
+		udpFirstTimeout:  udpFirstTimeout,
+		Outgoing:         make(chan []byte, 100000)}
 }
 
 //Open 打开 rtsp 连接
@@ -75,99 +113,192 @@ func (client *Client) Open() (err error) {
 	if err := client.Write("DESCRIBE", "", "", false, false); err != nil {
 		return err
 	}
+	if client.tunnel && client.Transport != TransportTCP {
+		log.Warnf("[%s] %s transport is not available through http tunnel, using tcp", client.Name, client.Transport)
+		client.Transport = TransportTCP
+	}
 	i := 0
 	p := 1
-	for _, track := range client.track {
-		if err := client.Write("SETUP", "/"+track, "Transport: RTP/AVP/TCP;unicast;interleaved="+strconv.Itoa(i)+"-"+strconv.Itoa(p)+"\r\n", false, false); err != nil {
+	for n, track := range client.track {
+		profile, keyMgmt, err := client.srtpOffer(n)
+		if err != nil {
+			return err
+		}
+		if client.Transport == TransportUDP {
+			err = client.setupUDP(track, profile, keyMgmt)
+			if se, ok := err.(*StatusError); ok && se.Code == 461 && n == 0 {
+				log.Warnf("[%s] udp transport rejected, fallback to tcp", client.Name)
+				client.Transport = TransportTCP
+			}
+		}
+		switch client.Transport {
+		case TransportUDP:
+			// 已在上面发送 SETUP
+		case TransportMulticast:
+			err = client.setupMulticast(track, profile, keyMgmt)
+		default:
+			err = client.Write("SETUP", "/"+track, "Transport: "+profile+"/TCP;unicast;interleaved="+strconv.Itoa(i)+"-"+strconv.Itoa(p)+"\r\n"+keyMgmt, false, false)
+			i += 2
+			p += 2
+		}
+		if err != nil {
+			return err
+		}
+		if err := client.srtpAnswer(n); err != nil {
 			return err
 		}
-		i++
-		p++
 	}
 	if err := client.Write("PLAY", "", "", false, false); err != nil {
 		return err
 	}
-	go client.RtspRtpLoop()
+	if client.Transport == TransportUDP || client.Transport == TransportMulticast {
+		go client.udpLoop()
+	} else {
+		go client.RtspRtpLoop()
+	}
 	return
 }
 
-//Connect tcp 连接
+//Connect tcp 连接, rtsps 时建立 TLS, rtsp+http 时建立 HTTP 隧道
 func (client *Client) Connect() (err error) {
 	var socket net.Conn
 	option := &net.Dialer{Timeout: client.rtspTimeOut * time.Second}
-	if socket, err = option.Dial("tcp", client.host+":"+client.port); err != nil {
+	address := client.host + ":" + client.port
+	if client.tunnel {
+		socket, err = client.dialTunnel(option, address)
+	} else if client.secure {
+		config := &tls.Config{}
+		if client.TLSConfig != nil {
+			config = client.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = client.host
+		}
+		socket, err = tls.DialWithDialer(option, "tcp", address, config)
+	} else {
+		socket, err = option.Dial("tcp", address)
+	}
+	if err != nil {
 		log.Error(err)
 		return err
 	}
 	client.socket = socket
+	client.reader = bufio.NewReader(socket)
 	return
 }
 
-// Write write
+// Write 发送请求并读取应答; 收到 401 时按质询认证后重试, nonce 过期 (stale=true) 时更新 nonce 再试一次, stage 为 true 时不重试
 func (client *Client) Write(method string, track, add string, stage bool, noread bool) (err error) {
-	client.cseq++
-	status := 0
-	if err := client.socket.SetDeadline(time.Now().Add(client.rtspTimeOut * time.Second)); err != nil {
-		return err
-	}
-	message := method + " " + client.uri + track + " RTSP/1.0\r\nCSeq: " + strconv.Itoa(client.cseq) + "\r\n" + add + client.session + client.Dauth(method) + client.bauth + "User-Agent: Lavf57.8.102\r\n\r\n"
-	if client.Debug {
-		log.Println(message)
-	}
-	if _, err := client.socket.Write([]byte(message)); err != nil {
-		return err
-	}
-	if noread {
-		return
-	}
-	if responce, err := client.Read(); err != nil {
-		return err
-	} else {
-		fLines := strings.SplitN(string(responce), " ", 3)
-		if status, err = strconv.Atoi(fLines[1]); err != nil {
+	retried, staleRetried := stage, stage
+	for {
+		client.cseq++
+		deadline := time.Now().Add(client.rtspTimeOut * time.Second)
+		if noread {
+			// 不读应答时不影响读循环的读超时
+			err = client.socket.SetWriteDeadline(deadline)
+		} else {
+			err = client.socket.SetDeadline(deadline)
+		}
+		if err != nil {
+			return err
+		}
+		message := method + " " + client.uri + track + " RTSP/1.0\r\nCSeq: " + strconv.Itoa(client.cseq) + "\r\n" + add + client.session + client.authorization(method, track) + "User-Agent: Lavf57.8.102\r\n\r\n"
+		if client.Debug {
+			log.Println(message)
+		}
+		if _, err := client.socket.Write([]byte(message)); err != nil {
+			return err
+		}
+		if noread {
+			client.expectReply(method)
+			return nil
+		}
+		resp, err := client.ReadResponse()
+		// 跳过之前未读取的应答 (不读应答的保活请求)
+		for err == nil && resp.CSeq() >= 0 && resp.CSeq() < client.cseq {
+			resp, err = client.ReadResponse()
+		}
+		if err != nil {
 			return err
 		}
-		if status == 401 && !stage {
-			client.bauth = "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(client.login+":"+client.password)) + "\r\n"
-			client.nonce = ParseDirective(string(responce), "nonce")
-			client.realm = ParseDirective(string(responce), "realm")
-			if err := client.Write(method, "", "", true, false); err != nil {
+		status := resp.StatusCode
+		if status == 401 {
+			stale, err := client.challenged(resp)
+			if err != nil {
 				return err
 			}
-		} else if status == 401 {
+			if !retried {
+				retried = true
+				continue
+			}
+			if stale && !staleRetried {
+				staleRetried = true
+				continue
+			}
 			return errors.New("Method " + method + " Authorization failed")
 		} else if status != 200 {
-			return errors.New("Method " + method + " Return bad status code")
-		} else {
-			switch method {
-			case "SETUP":
-				client.ParseSetup(string(responce))
-			case "DESCRIBE":
-				client.ParseDescribe(string(responce))
-			case "PLAY":
-				client.ParsePlay(string(responce))
-			}
+			return &StatusError{Method: method, Code: status}
 		}
+		switch method {
+		case "OPTIONS":
+			client.ParseOptions(resp)
+		case "SETUP":
+			client.ParseSetup(resp)
+		case "DESCRIBE":
+			client.ParseDescribe(resp)
+		case "PLAY":
+			client.ParsePlay(resp)
+		}
+		return nil
 	}
-	return
 }
 
-//Read read
-func (client *Client) Read() (buffer []byte, err error) {
-	buffer = make([]byte, 4096)
-	if err = client.socket.SetDeadline(time.Now().Add(client.rtspTimeOut * time.Second)); err != nil {
+// challenged 按 401 应答的质询更新认证方式, 返回质询是否只是 nonce 过期
+func (client *Client) challenged(resp *Response) (stale bool, err error) {
+	challenges := parseChallenges(resp.Header["Www-Authenticate"])
+	auth, err := newAuthenticator(challenges, client.login, client.password)
+	if err != nil {
+		return false, err
+	}
+	client.auth = auth
+	return staleChallenge(challenges), nil
+}
+
+// authorization 请求的 Authorization 头, 摘要中的 uri 与请求行一致; 尚未收到质询时为空
+func (client *Client) authorization(method, track string) string {
+	if client.auth == nil {
+		return ""
+	}
+	return client.auth.header(method, client.uri+track)
+}
+
+// ReadResponse 读取一条 RTSP 应答, 应答之前到达的 interleaved 数据照常投递
+func (client *Client) ReadResponse() (*Response, error) {
+	if err := client.socket.SetDeadline(time.Now().Add(client.rtspTimeOut * time.Second)); err != nil {
 		log.Error(err)
 		return nil, err
 	}
-
-	var n int
-	if n, err = client.socket.Read(buffer); err != nil || n <= 2 {
+	resp, err := readResponse(client.reader, client.deliver)
+	if err != nil {
 		return nil, err
 	}
 	if client.Debug {
-		log.Println(string(buffer[:n]))
+		log.Println(resp.Proto, resp.StatusCode, resp.Status, resp.Header, string(resp.Body))
+	}
+	return resp, nil
+}
+
+// deliver 解密, 统计并投递一个 interleaved 帧 (含 4 字节头)
+func (client *Client) deliver(frame []byte) {
+	if client.srtp[frame[1]/2] != nil {
+		packet, ok := client.unprotect(frame[1], frame[4:])
+		if !ok {
+			return
+		}
+		frame = append([]byte{36, frame[1], byte(len(packet) >> 8), byte(len(packet))}, packet...)
 	}
-	return buffer[:n], nil
+	client.observe(frame[1], frame[4:], time.Now())
+	client.Outgoing <- frame
 }
 
 //ParseURL parse urls
@@ -176,9 +307,21 @@ func (client *Client) ParseURL(uri string) (err error) {
 	if err != nil {
 		return err
 	}
+	scheme := "rtsp"
+	client.secure = strings.EqualFold(elemets.Scheme, "rtsps")
+	client.tunnel = strings.EqualFold(elemets.Scheme, TunnelScheme)
+	if client.secure {
+		scheme = "rtsps"
+	}
 	if host, port, err := net.SplitHostPort(elemets.Host); err == nil {
 		client.host = host
 		client.port = port
+	} else if client.secure {
+		client.host = elemets.Host
+		client.port = "322"
+	} else if client.tunnel {
+		client.host = elemets.Host
+		client.port = "80"
 	} else {
 		client.host = elemets.Host
 		client.port = "554"
@@ -187,89 +330,74 @@ func (client *Client) ParseURL(uri string) (err error) {
 		client.login = elemets.User.Username()
 		client.password, _ = elemets.User.Password()
 	}
+	client.path = elemets.Path
+	if client.path == "" {
+		client.path = "/"
+	}
 	if elemets.RawQuery != "" {
-		client.uri = "rtsp://" + client.host + ":" + client.port + elemets.Path + "?" + elemets.RawQuery
+		client.path += "?" + elemets.RawQuery
+		client.uri = scheme + "://" + client.host + ":" + client.port + elemets.Path + "?" + elemets.RawQuery
 	} else {
-		client.uri = "rtsp://" + client.host + ":" + client.port + elemets.Path
+		client.uri = scheme + "://" + client.host + ":" + client.port + elemets.Path
 	}
 	return
 }
 
-//Dauth auth
-func (client *Client) Dauth(phase string) string {
-	dauth := ""
-	if client.nonce != "" {
-		hs1 := client.GetMD5Hash(client.login + ":" + client.realm + ":" + client.password)
-		hs2 := client.GetMD5Hash(phase + ":" + client.uri)
-		responce := client.GetMD5Hash(hs1 + ":" + client.nonce + ":" + hs2)
-		dauth = `Authorization: Digest username="` + client.login + `", realm="` + client.realm + `", nonce="` + client.nonce + `", uri="` + client.uri + `", response="` + responce + `"` + "\r\n"
-	}
-	return dauth
-}
-
-//ParseDirective directive
-func ParseDirective(message, name string) string {
-	index := strings.Index(message, name)
-	if index == -1 {
-		return ""
+// ParseSetup setup
+func (client *Client) ParseSetup(resp *Response) {
+	if transport := resp.Header.Get("Transport"); transport != "" {
+		client.transport = parseTransport(strings.TrimSpace(transport))
+	}
+	client.keyMgmt = resp.Header.Get("KeyMgmt")
+	if session := strings.TrimSpace(resp.Header.Get("Session")); session != "" {
+		client.session = "Session: " + strings.TrimSpace(strings.Split(session, ";")[0]) + "\r\n"
+		if timeout := sessionTimeout(session); timeout > 0 {
+			client.keepalivetime = keepaliveInterval(timeout)
+		}
 	}
-	start := 1 + index + strings.Index(message[index:], `"`)
-	end := start + strings.Index(message[start:], `"`)
-	return strings.TrimSpace(message[start:end])
-}
-
-//GetMD5Hash md5
-func (client *Client) GetMD5Hash(text string) string {
-	hash := md5.Sum([]byte(text))
-	return hex.EncodeToString(hash[:])
 }
 
-// ParseSetup setup
-func (client *Client) ParseSetup(message string) {
-	mparsed := strings.Split(message, "\r\n")
-	for _, element := range mparsed {
-		if strings.Contains(element, "Session:") {
-			if strings.Contains(element, ";") {
-				fist := strings.Split(element, ";")[0]
-				client.session = "Session: " + fist[9:] + "\r\n"
-			} else {
-				client.session = "Session: " + element[9:] + "\r\n"
+// ParseOptions options, Public 中有 GET_PARAMETER 时用它保活
+func (client *Client) ParseOptions(resp *Response) {
+	for _, public := range resp.Header["Public"] {
+		for _, method := range strings.Split(public, ",") {
+			if strings.EqualFold(strings.TrimSpace(method), "GET_PARAMETER") {
+				client.getParameter = true
 			}
 		}
 	}
 }
 
 // ParseDescribe desc
-func (client *Client) ParseDescribe(message string) {
-	sdpstring := strings.Split(message, "\r\n\r\n")
-	if len(sdpstring) > 1 {
-		client.sdp = sdpstring[1]
-		for _, info := range sdp.Decode(sdpstring[1]) {
-			client.track = append(client.track, info.Control)
-		}
-	} else {
+func (client *Client) ParseDescribe(resp *Response) {
+	if len(resp.Body) == 0 {
 		if client.Debug {
 			log.Println("SDP not found")
 		}
+		return
+	}
+	client.sdp = string(resp.Body)
+	client.infos = sdp.Decode(client.sdp)
+	for _, info := range client.infos {
+		client.track = append(client.track, info.Control)
 	}
 }
 
-// ParsePlay play
-func (client *Client) ParsePlay(message string) {
+// ParsePlay play, 从 RTP-Info 取各路媒体的起始时间戳
+func (client *Client) ParsePlay(resp *Response) {
 	fist := true
-	mparsed := strings.Split(message, "\r\n")
-	for _, element := range mparsed {
-		if strings.Contains(element, "RTP-Info") {
-			mparseds := strings.Split(element, ",")
-			for _, elements := range mparseds {
-				mparsedss := strings.Split(elements, ";")
-				if len(mparsedss) > 2 {
-					if fist {
-						client.firstvideots, _ = strconv.Atoi(mparsedss[2][8:])
-						fist = false
-					} else {
-						client.firstaudiots, _ = strconv.Atoi(mparsedss[2][8:])
-					}
+	for _, element := range resp.Header["Rtp-Info"] {
+		for _, stream := range strings.Split(element, ",") {
+			for _, param := range strings.Split(stream, ";") {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "rtptime=") {
+					continue
+				}
+				if fist {
+					client.firstvideots, _ = strconv.Atoi(param[8:])
+					fist = false
+				} else {
+					client.firstaudiots, _ = strconv.Atoi(param[8:])
 				}
 			}
 		}
@@ -278,31 +406,63 @@ func (client *Client) ParsePlay(message string) {
 
 //RtspRtpLoop loop
 func (client *Client) RtspRtpLoop() {
-	defer func() {
-		client.Signals <- true
-	}()
+	defer client.exit(nil)
 	header := make([]byte, 4)
 	payload := make([]byte, 16384)
 	sync_b := make([]byte, 1)
 	timer := time.Now()
+	reportTimer := time.Now()
 	start_t := true
 
 	for {
+		select {
+		case <-client.keyframeRequests:
+			if err := client.sendKeyframeRequest(); err != nil {
+				client.err = err
+				return
+			}
+		default:
+		}
 		if int(time.Now().Sub(timer).Seconds()) > client.keepalivetime {
-			if err := client.Write("OPTIONS", "", "", false, true); err != nil {
+			if err := client.sendKeepalive(); err != nil {
+				client.err = err
 				return
 			}
 			timer = time.Now()
 		}
+		if now := time.Now(); now.Sub(reportTimer) > rtcpInterval {
+			if err := client.sendReceiverReportsTCP(now); err != nil {
+				client.err = err
+				return
+			}
+			reportTimer = now
+		}
 		if start_t {
 			client.socket.SetDeadline(time.Now().Add(50 * time.Second))
 		} else {
 			client.socket.SetDeadline(time.Now().Add(client.rtptimeout * time.Second))
 		}
-		if n, err := io.ReadFull(client.socket, header); err != nil || n != 4 {
+		// 保活等请求的应答
+		if peek, err := client.reader.Peek(4); err == nil && string(peek) == "RTSP" {
+			resp, err := readResponse(client.reader, client.deliver)
+			if err != nil {
+				client.err = err
+				return
+			}
+			if client.controlReply(resp) {
+				if err := client.sendKeepalive(); err != nil {
+					client.err = err
+					return
+				}
+				timer = time.Now()
+			}
+			continue
+		}
+		if n, err := io.ReadFull(client.reader, header); err != nil || n != 4 {
 			if client.Debug {
 				log.Println("read header error", err)
 			}
+			client.err = err
 			return
 		}
 		if header[0] != 36 {
@@ -321,19 +481,20 @@ func (client *Client) RtspRtpLoop() {
 					if client.Debug {
 						log.Println("desync fatal miss position rtp packet", client.uri)
 					}
+					client.err = errors.New("desync fatal miss position rtp packet")
 					return
 				}
-				if n, err := io.ReadFull(client.socket, sync_b); err != nil && n != 1 {
+				if n, err := io.ReadFull(client.reader, sync_b); err != nil && n != 1 {
 					return
 				}
 				if sync_b[0] == 36 {
 					header[0] = sync_b[0]
-					if n, err := io.ReadFull(client.socket, sync_b); err != nil && n != 1 {
+					if n, err := io.ReadFull(client.reader, sync_b); err != nil && n != 1 {
 						return
 					}
 					if sync_b[0] == 0 || sync_b[0] == 1 || sync_b[0] == 2 || sync_b[0] == 3 {
 						header[1] = sync_b[0]
-						if n, err := io.ReadFull(client.socket, header[2:]); err != nil && n == 2 {
+						if n, err := io.ReadFull(client.reader, header[2:]); err != nil && n == 2 {
 							return
 						}
 						if !rtsp {
@@ -351,30 +512,57 @@ func (client *Client) RtspRtpLoop() {
 			}
 		}
 		payloadLen := (int)(header[2])<<8 + (int)(header[3])
-		if payloadLen > 16384 || payloadLen < 12 {
+		if payloadLen > 16384 || (payloadLen < 12 && header[1]%2 == 0) {
 			if client.Debug {
 				log.Println("fatal size desync", client.uri, payloadLen)
 			}
 			continue
 		}
-		if n, err := io.ReadFull(client.socket, payload[:payloadLen]); err != nil || n != payloadLen {
+		if n, err := io.ReadFull(client.reader, payload[:payloadLen]); err != nil || n != payloadLen {
 			if client.Debug {
 				log.Println("read payload error", payloadLen, err)
 			}
+			client.err = err
 			return
 		} else {
 			start_t = false
-			client.Outgoing <- append(header, payload[:n]...)
+			client.deliver(append(header, payload[:n]...))
 		}
 	}
 }
 
+// Infos DESCRIBE 返回的媒体信息, 第 i 个媒体使用 interleaved 通道 2i/2i+1
+func (client *Client) Infos() []sdp.Info {
+	return client.infos
+}
+
+// Err 读循环退出原因, 在 Signals 触发后读取
+func (client *Client) Err() error {
+	return client.err
+}
+
+// exit 记录退出原因并只通知一次 Signals
+func (client *Client) exit(err error) {
+	client.exitOnce.Do(func() {
+		if err != nil {
+			client.err = err
+		}
+		client.Signals <- true
+	})
+}
+
 // Close 关闭
 func (client *Client) Close() {
+	client.closeOnce.Do(func() {
+		close(client.closed)
+	})
 	if client.socket != nil {
 		if err := client.socket.Close(); err != nil {
 		}
 	}
+	for _, pair := range client.udp {
+		pair.Close()
+	}
 }
 
 //IsConnect 判断是否连接
@@ -0,0 +1,164 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+func TestMulticastTransport(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface:", err)
+	}
+	group := net.IPv4(239, 255, 77, 77)
+	port := 20000 + 2*(time.Now().Nanosecond()%5000)
+
+	sender, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	packetConn := ipv4.NewPacketConn(sender)
+	if err := packetConn.SetMulticastInterface(lo); err != nil {
+		t.Skip("multicast on loopback unavailable:", err)
+	}
+	packetConn.SetMulticastLoopback(true)
+
+	played := make(chan struct{})
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			if !strings.Contains(req.Header.Get("Transport"), "multicast") {
+				return 461, "", ""
+			}
+			return 200, "Session: 12345678;timeout=60\r\nTransport: RTP/AVP;multicast;destination=" + group.String() + ";port=" + strconv.Itoa(port) + "-" + strconv.Itoa(port+1) + ";ttl=1\r\n", ""
+		case "PLAY":
+			close(played)
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	client.Transport = TransportMulticast
+	client.MulticastInterface = "lo"
+	if err := client.Open(); err != nil {
+		if strings.Contains(err.Error(), "no such device") || strings.Contains(err.Error(), "setsockopt") {
+			t.Skip("cannot join multicast group on loopback:", err)
+		}
+		t.Fatal(err)
+	}
+	defer client.Close()
+	<-played
+
+	packet := []byte{0x80, 96, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0x65, 0xaa}
+	dst := &net.UDPAddr{IP: group, Port: port}
+	deadline := time.After(3 * time.Second)
+	for {
+		if _, err := sender.WriteTo(packet, dst); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case data := <-client.Outgoing:
+			if data[0] != 36 || data[1] != 0 {
+				t.Fatalf("unexpected interleaved header %v", data[:4])
+			}
+			if string(data[4:]) != string(packet) {
+				t.Fatalf("payload mismatch %v", data[4:])
+			}
+			return
+		case <-deadline:
+			t.Skip("no multicast loopback delivery in this environment")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestOpenLargeDescribe(t *testing.T) {
+	// 超过旧的 4096 字节读缓冲, 且 PLAY 应答之前先到达 RTP
+	largeSDP := testutil.SDP + strings.Repeat("a=x-padding:0123456789abcdef\r\n", 300)
+	rtp := "$\x00\x00\x0e" + string(rtpPacket(1, 3600, 0x1234)) + "\x65\xaa"
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", largeSDP
+		case "SETUP":
+			return 200, "session: 12345678;timeout=60\r\ntransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			conn.Write([]byte(rtp))
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if infos := client.Infos(); len(infos) != 1 || infos[0].Control != "trackID=0" {
+		t.Fatalf("infos = %+v", infos)
+	}
+	if client.session != "Session: 12345678\r\n" {
+		t.Errorf("session header = %q", client.session)
+	}
+	select {
+	case data := <-client.Outgoing:
+		if string(data) != rtp {
+			t.Errorf("outgoing = %x", data)
+		}
+	case <-time.After(time.Second):
+		t.Error("rtp before play reply was lost")
+	}
+}
+
+func TestRTSPS(t *testing.T) {
+	client := ClientNew()
+	if err := client.ParseURL("rtsps://cam.local/live"); err != nil || client.port != "322" || client.uri != "rtsps://cam.local:322/live" {
+		t.Errorf("parse = %s %s, %v", client.port, client.uri, err)
+	}
+
+	server, pool := testutil.NewRTSPSServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			return 200, "Session: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		config *tls.Config
+		ok     bool
+	}{
+		{"trusted ca", &tls.Config{RootCAs: pool}, true},
+		{"unknown ca", nil, false},
+		{"insecure", &tls.Config{InsecureSkipVerify: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := ClientNew()
+			client.URL = server.URL("/live")
+			client.TLSConfig = test.config
+			err := client.Open()
+			defer client.Close()
+			if (err == nil) != test.ok {
+				t.Errorf("open = %v, want ok %v", err, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/deepch/av"
+	psdp "github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+// pion 未内置的编码名, 仅在浏览器 offer 中包含时协商
+const (
+	codecH265 = "H265"
+	codecPCMU = "PCMU"
+	codecPCMA = "PCMA"
+	codecAAC  = "AAC"
+)
+
+// Codecs 可在流配置中启用的编码名
+var Codecs = []string{webrtc.H264, codecH265, codecPCMU, codecPCMA, webrtc.Opus, codecAAC}
+
+// streamCodecs 流的音视频编码 (sdp.Info.Type), audio 为 0 表示没有可透传的音频
+type streamCodecs struct {
+	video int
+	audio int
+}
+
+// videoCodecName 流编码对应的 WebRTC 编码名
+func videoCodecName(codec int) string {
+	if codec == sdp.H265 {
+		return codecH265
+	}
+	return webrtc.H264
+}
+
+// audioCodecName 可透传的音频编码对应的 WebRTC 编码名, 不支持时返回空
+func audioCodecName(codec int) string {
+	switch codec {
+	case av.PCM_MULAW:
+		return codecPCMU
+	case av.PCM_ALAW:
+		return codecPCMA
+	case sdp.OPUS:
+		return webrtc.Opus
+	}
+	return ""
+}
+
+// offeredCodec offer 中的一个编码
+type offeredCodec struct {
+	payloadType uint8
+	codec       psdp.Codec
+}
+
+// codecsFromOffer 列出 offer 中指定媒体类型下名称匹配的编码
+func codecsFromOffer(parsed *psdp.SessionDescription, kind, name string) (codecs []offeredCodec) {
+	for _, md := range parsed.MediaDescriptions {
+		if md.MediaName.Media != kind {
+			continue
+		}
+		for _, format := range md.MediaName.Formats {
+			pt, err := strconv.Atoi(format)
+			if err != nil {
+				continue
+			}
+			codec, err := parsed.GetCodecForPayloadType(uint8(pt))
+			if err != nil || !strings.EqualFold(codec.Name, name) {
+				continue
+			}
+			codecs = append(codecs, offeredCodec{payloadType: uint8(pt), codec: codec})
+		}
+	}
+	return
+}
+
+// videoCodecFromOffer 在浏览器 offer 中选出与流编码匹配的视频编码, 沿用浏览器的 payload type
+func videoCodecFromOffer(parsed *psdp.SessionDescription, codec int) (*webrtc.RTPCodec, error) {
+	name := videoCodecName(codec)
+	var selected *webrtc.RTPCodec
+	for _, offered := range codecsFromOffer(parsed, "video", name) {
+		switch name {
+		case webrtc.H264:
+			// 只支持 packetization-mode=1, 优先 constrained baseline
+			if !strings.Contains(offered.codec.Fmtp, "packetization-mode=1") {
+				continue
+			}
+			if selected != nil && !strings.Contains(offered.codec.Fmtp, "profile-level-id=42e01f") {
+				continue
+			}
+			selected = webrtc.NewRTPH264Codec(offered.payloadType, offered.codec.ClockRate)
+			selected.SDPFmtpLine = offered.codec.Fmtp
+		case codecH265:
+			if selected == nil {
+				selected = webrtc.NewRTPCodec(webrtc.RTPCodecTypeVideo, codecH265, offered.codec.ClockRate, 0, offered.codec.Fmtp, offered.payloadType, &h265Payloader{})
+			}
+		}
+	}
+	if selected == nil {
+		return nil, errors.New("browser offer does not support " + name)
+	}
+	return selected, nil
+}
+
+// audioCodecFromOffer 在浏览器 offer 中查找流的音频编码, 浏览器不支持时返回 nil, 只转发视频
+func audioCodecFromOffer(parsed *psdp.SessionDescription, codec int) *webrtc.RTPCodec {
+	name := audioCodecName(codec)
+	if name == "" {
+		return nil
+	}
+	offered := codecsFromOffer(parsed, "audio", name)
+	if len(offered) == 0 {
+		return nil
+	}
+	first := offered[0]
+	switch name {
+	case webrtc.Opus:
+		return webrtc.NewRTPOpusCodec(first.payloadType, first.codec.ClockRate)
+	default:
+		return webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio, name, first.codec.ClockRate, 0, "", first.payloadType, &g711Payloader{})
+	}
+}
+
+// g711Payloader G.711 按 mtu 切分, 音频走 RTP 透传, 这里只用于满足 NewTrack
+type g711Payloader struct{}
+
+// Payload 实现 rtp.Payloader
+func (p *g711Payloader) Payload(mtu int, payload []byte) (payloads [][]byte) {
+	for len(payload) > 0 {
+		size := mtu
+		if size > len(payload) {
+			size = len(payload)
+		}
+		out := make([]byte, size)
+		copy(out, payload[:size])
+		payloads = append(payloads, out)
+		payload = payload[size:]
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"testing"
+
+	"github.com/deepch/av"
+	psdp "github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+func TestAudioCodecFromOffer(t *testing.T) {
+	const header = "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 H264/90000\r\n"
+	const all = header + "m=audio 9 UDP/TLS/RTP/SAVPF 111 9 0 8\r\n" +
+		"a=rtpmap:111 opus/48000/2\r\na=rtpmap:9 G722/8000\r\na=rtpmap:0 PCMU/8000\r\na=rtpmap:8 PCMA/8000\r\n"
+	const opusOnly = header + "m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\n"
+	tests := []struct {
+		name        string
+		offer       string
+		codec       int
+		want        string
+		payloadType uint8
+		clockRate   uint32
+	}{
+		{"pcmu", all, av.PCM_MULAW, codecPCMU, 0, 8000},
+		{"pcma", all, av.PCM_ALAW, codecPCMA, 8, 8000},
+		{"opus", all, sdp.OPUS, webrtc.Opus, 111, 48000},
+		{"aac not passed through", all, av.AAC, "", 0, 0},
+		{"pcma not offered", opusOnly, av.PCM_ALAW, "", 0, 0},
+		{"no audio section", header, av.PCM_MULAW, "", 0, 0},
+	}
+	for _, test := range tests {
+		parsed := psdp.SessionDescription{}
+		if err := parsed.Unmarshal([]byte(test.offer)); err != nil {
+			t.Fatal(err)
+		}
+		selected := audioCodecFromOffer(&parsed, test.codec)
+		if test.want == "" {
+			if selected != nil {
+				t.Errorf("%s: selected %s", test.name, selected.Name)
+			}
+			continue
+		}
+		if selected == nil || selected.Name != test.want || selected.PayloadType != test.payloadType || selected.ClockRate != test.clockRate {
+			t.Errorf("%s: selected %+v", test.name, selected)
+		}
+	}
+}
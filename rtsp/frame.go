@@ -0,0 +1,182 @@
+package rtsp
+
+import (
+	"time"
+)
+
+// frameAssemblerMaxNALUs 未出现编码数据时最多暂存的 NAL 数, 超过时丢弃
+const frameAssemblerMaxNALUs = 64
+
+// Frame 一个完整的视频访问单元, 由 RTP 时间戳与 marker 位划分, 所有输出 (WebRTC, 录像, HLS) 共用
+type Frame struct {
+	Codec int // av.H264 或 sdp.H265
+	// NALUs 按收到的顺序排列, 包括摄像机发送的 AUD/SEI; 关键帧缺少参数集时已补齐
+	NALUs    [][]byte
+	Keyframe bool
+	// PTS 相对会话开始的显示时间, Duration 为到下一帧的估计时长
+	PTS      time.Duration
+	Duration time.Duration
+	// Timestamp 原始 RTP 时间戳
+	Timestamp uint32
+	// WallClock 按摄像机 RTCP SR 换算的采集时间, 尚未收到 SR 时为零值
+	WallClock time.Time
+	data      []byte
+}
+
+// AnnexB 带起始码的帧数据
+func (frame *Frame) AnnexB() []byte {
+	if frame.data == nil {
+		return annexB(frame.NALUs...)
+	}
+	return frame.data
+}
+
+// Size 帧数据字节数
+func (frame *Frame) Size() int {
+	return len(frame.AnnexB())
+}
+
+// samples 时长换算为 rate 时钟下的样本数, 以 PTS 两端取整, 连续的帧不累计误差
+func (frame *Frame) samples(rate int) uint32 {
+	return uint32(durationTicks(frame.PTS+frame.Duration, rate) - durationTicks(frame.PTS, rate))
+}
+
+// FrameSink 视频帧的消费者, 不能修改 frame; Hub 与 Viewer 的 WriteFrame 在 RTSP 读循环中调用, 不能阻塞,
+// Hub.AddSink 附加的输出经独立的队列调用
+type FrameSink interface {
+	WriteFrame(frame *Frame)
+}
+
+// nalKind 访问单元中 NAL 的类别
+type nalKind int
+
+const (
+	nalVCL          nalKind = iota // 编码数据
+	nalKeyVCL                      // IDR/IRAP 编码数据
+	nalParameterSet                // VPS/SPS/PPS
+	nalDelimiter                   // AUD, 必须在访问单元开头
+	nalOther                       // SEI 等其他非编码数据
+)
+
+// frameAssembler 将 NAL 按 RTP 时间戳聚合为 Frame, 时间戳变化或收到 marker 位时输出;
+// 第一个关键帧之前的帧被丢弃
+type frameAssembler struct {
+	codec int
+	timer *frameTimer
+	sink  FrameSink
+	// parameterSets 返回当前的参数集, 关键帧内没有时插入到 AUD 之后
+	parameterSets func() [][]byte
+	// onDiscontinuity 时间戳不连续并重新对齐时调用
+	onDiscontinuity func(ts uint32)
+	// wallClock 将 RTP 时间戳换算为摄像机的墙上时间, 为 nil 或尚未收到 SR 时帧不带 WallClock
+	wallClock func(ts uint32) (time.Time, bool)
+	synced    bool
+	started   bool
+	ts        int64
+	nalus     [][]byte
+	kinds     []nalKind
+}
+
+// newFrameAssembler timer 为 nil 时新建, Hub 传入跨会话保留的计时器使 PTS 在重连后继续递增
+func newFrameAssembler(codec int, clockRate int, timer *frameTimer, sink FrameSink) *frameAssembler {
+	if timer == nil {
+		timer = newFrameTimer(clockRate, videoClockRate)
+	}
+	return &frameAssembler{codec: codec, timer: timer, sink: sink}
+}
+
+// push 放入时间戳为 ts 的一个 NAL, 时间戳变化时先输出上一帧
+func (a *frameAssembler) push(ts int64, nalu []byte, kind nalKind) {
+	if len(nalu) == 0 {
+		return
+	}
+	if a.started && ts != a.ts {
+		a.flush()
+	}
+	a.started = true
+	a.ts = ts
+	a.nalus = append(a.nalus, nalu)
+	a.kinds = append(a.kinds, kind)
+}
+
+// mark 收到时间戳为 ts 的 marker 位, 当前帧已完整
+func (a *frameAssembler) mark(ts int64) {
+	if a.started && ts == a.ts {
+		a.flush()
+	}
+}
+
+// drop 丢弃未输出的 NAL
+func (a *frameAssembler) drop() {
+	a.nalus = nil
+	a.kinds = nil
+}
+
+// lose 丢包后调用: 丢弃未输出的 NAL, 之后的帧引用缺失的数据, 等待下一个关键帧
+func (a *frameAssembler) lose() {
+	a.drop()
+	a.synced = false
+}
+
+// flush 输出当前帧; 只有参数集/AUD/SEI 而没有编码数据时暂存, 并入下一帧
+func (a *frameAssembler) flush() {
+	vcl, keyframe, parameterSets := false, false, false
+	for _, kind := range a.kinds {
+		switch kind {
+		case nalVCL:
+			vcl = true
+		case nalKeyVCL:
+			vcl, keyframe = true, true
+		case nalParameterSet:
+			parameterSets = true
+		}
+	}
+	if !vcl {
+		if len(a.nalus) > frameAssemblerMaxNALUs {
+			a.drop()
+		}
+		return
+	}
+	nalus, kinds := a.nalus, a.kinds
+	a.drop()
+	if keyframe {
+		a.synced = true
+	} else if !a.synced {
+		return
+	}
+	if keyframe && !parameterSets && a.parameterSets != nil {
+		at := 0
+		if kinds[0] == nalDelimiter {
+			at = 1
+		}
+		inserted := append([][]byte{}, nalus[:at]...)
+		for _, nalu := range a.parameterSets() {
+			if len(nalu) > 0 {
+				inserted = append(inserted, nalu)
+			}
+		}
+		nalus = append(inserted, nalus[at:]...)
+	}
+
+	before := a.timer.discontinuities
+	pts := a.timer.written
+	samples := a.timer.duration(uint32(a.ts))
+	if a.timer.discontinuities != before && a.onDiscontinuity != nil {
+		a.onDiscontinuity(uint32(a.ts))
+	}
+	frame := &Frame{
+		Codec:     a.codec,
+		NALUs:     nalus,
+		Keyframe:  keyframe,
+		PTS:       ticksDuration(pts, videoClockRate),
+		Timestamp: uint32(a.ts),
+		data:      annexB(nalus...),
+	}
+	frame.Duration = ticksDuration(pts+int64(samples), videoClockRate) - frame.PTS
+	if a.wallClock != nil {
+		if wall, ok := a.wallClock(frame.Timestamp); ok {
+			frame.WallClock = wall
+		}
+	}
+	a.sink.WriteFrame(frame)
+}
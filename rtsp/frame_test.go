@@ -0,0 +1,149 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtcp"
+)
+
+// frameRecorder 记录收到的帧
+type frameRecorder struct {
+	frames []*Frame
+}
+
+func (r *frameRecorder) WriteFrame(frame *Frame) {
+	r.frames = append(r.frames, frame)
+}
+
+func TestFrameAssembler(t *testing.T) {
+	var (
+		aud   = []byte{0x09, 0xF0}
+		sei   = []byte{0x06, 0x05}
+		sps   = []byte{0x67, 0x42}
+		pps   = []byte{0x68, 0xCE}
+		idr   = []byte{0x65, 0x88}
+		slice = []byte{0x41, 0x9A}
+	)
+	sink := &frameRecorder{}
+	assembler := newFrameAssembler(av.H264, 90000, nil, sink)
+	assembler.parameterSets = func() [][]byte { return [][]byte{sps, pps} }
+	push := func(ts int64, nalus ...[]byte) {
+		for _, nalu := range nalus {
+			assembler.push(ts, nalu, h264NALKind(nalu))
+		}
+	}
+
+	push(0, slice)
+	assembler.mark(0) // 第一个关键帧之前的帧丢弃
+	push(3600, aud, sei, idr)
+	assembler.mark(3600)
+	push(7200, aud, slice)
+	// 没有 marker 位, 时间戳变化时输出
+	push(9000, aud, sps, pps)
+	assembler.mark(9000) // 只有参数集, 并入下一帧
+	push(10800, idr)
+	assembler.mark(10800)
+	push(14400, slice)
+	assembler.drop() // 丢弃未完成的帧
+	push(18000, slice)
+	assembler.mark(18000)
+
+	want := []struct {
+		nalus    [][]byte
+		keyframe bool
+		pts      time.Duration
+		duration time.Duration
+	}{
+		{[][]byte{aud, sps, pps, sei, idr}, true, 0, 40 * time.Millisecond},
+		{[][]byte{aud, slice}, false, 40 * time.Millisecond, 40 * time.Millisecond},
+		{[][]byte{aud, sps, pps, idr}, true, 80 * time.Millisecond, 40 * time.Millisecond},
+		// 丢掉的帧计入间隔
+		{[][]byte{slice}, false, 120 * time.Millisecond, 120 * time.Millisecond},
+	}
+	if len(sink.frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(sink.frames), len(want))
+	}
+	for i, frame := range sink.frames {
+		if frame.Keyframe != want[i].keyframe || frame.PTS != want[i].pts || frame.Duration != want[i].duration {
+			t.Errorf("frame %d keyframe = %v, pts = %s, duration = %s", i, frame.Keyframe, frame.PTS, frame.Duration)
+		}
+		if !bytes.Equal(frame.AnnexB(), annexB(want[i].nalus...)) {
+			t.Errorf("frame %d = %x, want %x", i, frame.AnnexB(), annexB(want[i].nalus...))
+		}
+		if samples := frame.samples(videoClockRate); samples != uint32(durationTicks(want[i].duration, videoClockRate)) {
+			t.Errorf("frame %d samples = %d", i, samples)
+		}
+	}
+}
+
+func TestFrameAssemblerLoss(t *testing.T) {
+	var (
+		idr   = []byte{0x65, 0x88}
+		slice = []byte{0x41, 0x9A}
+	)
+	sink := &frameRecorder{}
+	assembler := newFrameAssembler(av.H264, 90000, nil, sink)
+	push := func(ts int64, nalu []byte) {
+		assembler.push(ts, nalu, h264NALKind(nalu))
+		assembler.mark(ts)
+	}
+
+	push(0, idr)
+	push(3600, slice)
+	assembler.lose() // 3600 之后的帧丢包
+	push(10800, slice)
+	push(14400, slice)
+	if len(sink.frames) != 2 {
+		t.Fatalf("got %d frames after loss, want 2", len(sink.frames))
+	}
+	push(18000, idr)
+	push(21600, slice)
+	if len(sink.frames) != 4 || !sink.frames[2].Keyframe || sink.frames[3].Keyframe {
+		t.Fatalf("got %d frames after idr", len(sink.frames))
+	}
+}
+
+func TestFrameWallClock(t *testing.T) {
+	client := ClientNew()
+	client.infos = []sdp.Info{{AVType: "video", TimeScale: 90000}}
+	start := time.Unix(1500000000, 0)
+	client.observe(0, rtpPacket(1, 0, 0x1234), start)
+
+	hub := HubNew("wall-clock", "rtsp://127.0.0.1/", &StunConfig{})
+	defer hub.Stop()
+	assembler := newFrameAssembler(av.H264, 90000, nil, hub)
+	assembler.wallClock = func(ts uint32) (time.Time, bool) {
+		return client.WallClock(0, ts)
+	}
+	idr := []byte{0x65, 0x88}
+	slice := []byte{0x41, 0x9A}
+	assembler.push(0, idr, nalKeyVCL)
+	assembler.mark(0)
+	if frame := hub.gop.frames[0]; !frame.WallClock.IsZero() || !hub.Status().FrameTime.IsZero() {
+		t.Errorf("wall clock before sr = %v", frame.WallClock)
+	}
+
+	// SR: RTP 时间戳 9000 对应 start+1s
+	sr, err := rtcp.Marshal([]rtcp.Packet{&rtcp.SenderReport{SSRC: 0x1234, NTPTime: uint64(1500000001+ntpEpochOffset) << 32, RTPTime: 9000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.observe(1, sr, start.Add(time.Second))
+	for _, ts := range []int64{3600, 7200} {
+		assembler.push(ts, slice, nalVCL)
+		assembler.mark(ts)
+	}
+	want := []time.Time{start.Add(940 * time.Millisecond), start.Add(980 * time.Millisecond)}
+	for i, frame := range hub.gop.frames[1:] {
+		if !frame.WallClock.Equal(want[i]) {
+			t.Errorf("frame %d wall clock = %v, want %v", i+1, frame.WallClock, want[i])
+		}
+	}
+	if status := hub.Status(); !status.FrameTime.Equal(want[1]) {
+		t.Errorf("frame time = %v", status.FrameTime)
+	}
+}
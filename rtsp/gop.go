@@ -0,0 +1,56 @@
+package rtsp
+
+import (
+	"time"
+)
+
+// GOP 缓存参数
+const (
+	DefaultGOPCacheSize = 4 << 20 // 默认每路流最多缓存 4MB
+	gopCacheMaxFrames   = viewerQueueSize / 2
+	gopReplayStep       = time.Millisecond // 回放帧间隔, 新观看者快速追上直播
+)
+
+// gopCache 最近一个 GOP (关键帧及其后的帧), 新观看者加入时先回放, 不必等下一个关键帧
+type gopCache struct {
+	limit  int // 字节上限, 小于 0 时不缓存
+	frames []*Frame
+	size   int
+}
+
+// add 记录一帧, 关键帧开始新的 GOP; 超过上限时丢弃整个 GOP, 直到下一个关键帧
+func (cache *gopCache) add(frame *Frame) {
+	if cache.limit < 0 {
+		return
+	}
+	if frame.Keyframe {
+		cache.reset()
+	} else if len(cache.frames) == 0 {
+		return
+	}
+	limit := cache.limit
+	if limit == 0 {
+		limit = DefaultGOPCacheSize
+	}
+	if cache.size+frame.Size() > limit || len(cache.frames) >= gopCacheMaxFrames {
+		cache.reset()
+		return
+	}
+	cache.frames = append(cache.frames, frame)
+	cache.size += frame.Size()
+}
+
+// reset 清空缓存, 断线重连后旧 GOP 不再可解码
+func (cache *gopCache) reset() {
+	cache.frames = nil
+	cache.size = 0
+}
+
+// replay 将缓存的 GOP 送入观看者, 时长压缩为 gopReplayStep
+func (cache *gopCache) replay(viewer *Viewer) {
+	for _, frame := range cache.frames {
+		replayed := *frame
+		replayed.Duration = gopReplayStep
+		viewer.WriteFrame(&replayed)
+	}
+}
@@ -0,0 +1,118 @@
+package rtsp
+
+// H.264 NAL 类型
+const (
+	h264NALSEI    = 6
+	h264NALIDR    = 5
+	h264NALSPS    = 7
+	h264NALPPS    = 8
+	h264NALAUD    = 9
+	h264NALSTAPA  = 24
+	h264NALSTAPB  = 25
+	h264NALMTAP16 = 26
+	h264NALMTAP24 = 27
+	h264NALFUA    = 28
+	h264NALFUB    = 29
+	h264NALMask   = 0x1F
+)
+
+// h264NALKind NAL 在访问单元中的类别
+func h264NALKind(nalu []byte) nalKind {
+	switch nalType := nalu[0] & h264NALMask; {
+	case nalType == h264NALIDR:
+		return nalKeyVCL
+	case nalType >= 1 && nalType < h264NALIDR:
+		return nalVCL
+	case nalType == h264NALSPS, nalType == h264NALPPS:
+		return nalParameterSet
+	case nalType == h264NALAUD:
+		return nalDelimiter
+	default:
+		return nalOther
+	}
+}
+
+// h264Depacketizer RFC 6184 解包
+type h264Depacketizer struct {
+	fuBuffer []byte
+}
+
+// Reset 实现 nalDepacketizer
+func (d *h264Depacketizer) Reset() {
+	d.fuBuffer = nil
+}
+
+// Unpack 解析一个 RTP 负载, 聚合包中的 NAL 按出现顺序返回
+func (d *h264Depacketizer) Unpack(payload []byte) [][]byte {
+	if len(payload) < 1 {
+		return nil
+	}
+	nalType := payload[0] & h264NALMask
+	switch {
+	case nalType >= 1 && nalType <= 23:
+		return [][]byte{payload}
+	case nalType == h264NALSTAPA:
+		return unpackAggregation(payload[1:], 0)
+	case nalType == h264NALSTAPB:
+		// DON(16) 后与 STAP-A 相同
+		if len(payload) < 3 {
+			return nil
+		}
+		return unpackAggregation(payload[3:], 0)
+	case nalType == h264NALMTAP16, nalType == h264NALMTAP24:
+		// DONB(16), 每个单元: 大小(16) DOND(8) TS offset(16/24) NAL
+		if len(payload) < 3 {
+			return nil
+		}
+		skip := 3
+		if nalType == h264NALMTAP24 {
+			skip = 4
+		}
+		return unpackAggregation(payload[3:], skip)
+	case nalType == h264NALFUA, nalType == h264NALFUB:
+		return d.unpackFU(payload, nalType == h264NALFUB)
+	}
+	return nil
+}
+
+// unpackFU FU-A/FU-B 分片, FU-B 只用于起始分片, 在 FU 头后携带 DON(16)
+func (d *h264Depacketizer) unpackFU(payload []byte, fub bool) [][]byte {
+	if len(payload) < 2 {
+		return nil
+	}
+	isStart := payload[1]&0x80 != 0
+	isEnd := payload[1]&0x40 != 0
+	data := payload[2:]
+	if fub {
+		if !isStart || len(data) < 2 {
+			return nil
+		}
+		data = data[2:]
+	}
+	if isStart {
+		d.fuBuffer = []byte{payload[0]&0xE0 | payload[1]&h264NALMask}
+	} else if d.fuBuffer == nil {
+		return nil
+	}
+	d.fuBuffer = append(d.fuBuffer, data...)
+	if isEnd {
+		nalu := d.fuBuffer
+		d.fuBuffer = nil
+		return [][]byte{nalu}
+	}
+	return nil
+}
+
+// unpackAggregation 拆分聚合单元, 每个单元以 16 位大小开头, skip 为大小之后 NAL 之前的字节数 (MTAP 的 DOND 与 TS offset, 计入大小)
+func unpackAggregation(data []byte, skip int) (nalus [][]byte) {
+	for len(data) > 2 {
+		size := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if size <= skip || size > len(data) {
+			return
+		}
+		nalus = append(nalus, data[skip:size])
+		data = data[size:]
+	}
+	return
+}
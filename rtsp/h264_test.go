@@ -0,0 +1,118 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// 参数集取自摄像机 SDP 的 sprop-parameter-sets (见 sdp/parser_test.go)
+var (
+	testSPS = []byte{0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64}
+	testPPS = []byte{0x68, 0xee, 0x3c, 0x80}
+	testSEI = []byte{0x06, 0x05, 0x02, 0xaa, 0xbb, 0x80}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00, 0x33, 0xff, 0xfe, 0xf6, 0xf0, 0xfe, 0x05, 0x36, 0x56}
+)
+
+func TestH264Depacketizer(t *testing.T) {
+	tests := []struct {
+		name    string
+		packets [][]byte
+		want    [][]byte
+	}{
+		{
+			name:    "single nal",
+			packets: [][]byte{testSPS},
+			want:    [][]byte{testSPS},
+		},
+		{
+			name: "stap-a sps pps",
+			packets: [][]byte{
+				{0x78, 0x00, 0x09, 0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64, 0x00, 0x04, 0x68, 0xee, 0x3c, 0x80},
+			},
+			want: [][]byte{testSPS, testPPS},
+		},
+		{
+			name: "stap-a sps pps sei",
+			packets: [][]byte{
+				{0x78, 0x00, 0x09, 0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64, 0x00, 0x04, 0x68, 0xee, 0x3c, 0x80,
+					0x00, 0x06, 0x06, 0x05, 0x02, 0xaa, 0xbb, 0x80},
+			},
+			want: [][]byte{testSPS, testPPS, testSEI},
+		},
+		{
+			name: "stap-a truncated tail",
+			packets: [][]byte{
+				{0x78, 0x00, 0x04, 0x68, 0xee, 0x3c, 0x80, 0x00, 0x20, 0x65},
+			},
+			want: [][]byte{testPPS},
+		},
+		{
+			name: "stap-b with don",
+			packets: [][]byte{
+				{0x79, 0x12, 0x34, 0x00, 0x09, 0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64, 0x00, 0x04, 0x68, 0xee, 0x3c, 0x80},
+			},
+			want: [][]byte{testSPS, testPPS},
+		},
+		{
+			name: "mtap16",
+			packets: [][]byte{
+				{0x7a, 0x00, 0x01,
+					0x00, 0x0c, 0x00, 0x00, 0x00, 0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64,
+					0x00, 0x07, 0x01, 0x0e, 0x10, 0x68, 0xee, 0x3c, 0x80},
+			},
+			want: [][]byte{testSPS, testPPS},
+		},
+		{
+			name: "mtap24",
+			packets: [][]byte{
+				{0x7b, 0x00, 0x01,
+					0x00, 0x0d, 0x00, 0x00, 0x00, 0x00, 0x67, 0x4d, 0x00, 0x1e, 0x95, 0xa8, 0x28, 0x0f, 0x64,
+					0x00, 0x08, 0x01, 0x00, 0x0e, 0x10, 0x68, 0xee, 0x3c, 0x80},
+			},
+			want: [][]byte{testSPS, testPPS},
+		},
+		{
+			name: "fu-a idr",
+			packets: [][]byte{
+				{0x7c, 0x85, 0x88, 0x84, 0x00, 0x33},
+				{0x7c, 0x05, 0xff, 0xfe, 0xf6, 0xf0},
+				{0x7c, 0x45, 0xfe, 0x05, 0x36, 0x56},
+			},
+			want: [][]byte{testIDR},
+		},
+		{
+			name: "fu-b start then fu-a",
+			packets: [][]byte{
+				{0x7d, 0x85, 0x00, 0x07, 0x88, 0x84, 0x00, 0x33},
+				{0x7c, 0x05, 0xff, 0xfe, 0xf6, 0xf0},
+				{0x7c, 0x45, 0xfe, 0x05, 0x36, 0x56},
+			},
+			want: [][]byte{testIDR},
+		},
+		{
+			name: "fu-a without start is dropped",
+			packets: [][]byte{
+				{0x7c, 0x05, 0xff, 0xfe, 0xf6, 0xf0},
+				{0x7c, 0x45, 0xfe, 0x05, 0x36, 0x56},
+			},
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			depacketizer := &h264Depacketizer{}
+			var got [][]byte
+			for _, packet := range test.packets {
+				got = append(got, depacketizer.Unpack(packet)...)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d nalus %x, want %d", len(got), got, len(test.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], test.want[i]) {
+					t.Errorf("nalu %d = %x, want %x", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package rtsp
+
+// H.265 NAL 类型
+const (
+	h265NALIRAPMin = 16 // BLA_W_LP
+	h265NALIRAPMax = 21 // CRA_NUT
+	h265NALVPS     = 32
+	h265NALSPS     = 33
+	h265NALPPS     = 34
+	h265NALAUD     = 35
+	h265NALSEIPre  = 39
+	h265NALSEISuf  = 40
+	h265NALAP      = 48
+	h265NALFU      = 49
+	h265NALPACI    = 50
+)
+
+// h265NALType 取 NAL 头中的类型
+func h265NALType(nalu []byte) byte {
+	return nalu[0] >> 1 & 0x3F
+}
+
+// h265NALKind NAL 在访问单元中的类别, 类型 0-31 为编码数据
+func h265NALKind(nalu []byte) nalKind {
+	switch nalType := h265NALType(nalu); {
+	case nalType >= h265NALIRAPMin && nalType <= h265NALIRAPMax:
+		return nalKeyVCL
+	case nalType < h265NALVPS:
+		return nalVCL
+	case nalType <= h265NALPPS:
+		return nalParameterSet
+	case nalType == h265NALAUD:
+		return nalDelimiter
+	default:
+		return nalOther
+	}
+}
+
+// h265Depacketizer RFC 7798 解包, donl 为 sprop-max-don-diff > 0 时的 DONL 字段
+type h265Depacketizer struct {
+	donl     bool
+	fuBuffer []byte
+}
+
+// Reset 实现 nalDepacketizer
+func (d *h265Depacketizer) Reset() {
+	d.fuBuffer = nil
+}
+
+// Unpack 解析一个 RTP 负载
+func (d *h265Depacketizer) Unpack(payload []byte) [][]byte {
+	if len(payload) < 3 {
+		return nil
+	}
+	switch h265NALType(payload) {
+	case h265NALAP:
+		return d.unpackAP(payload)
+	case h265NALFU:
+		return d.unpackFU(payload)
+	case h265NALPACI:
+		return nil
+	default:
+		if d.donl {
+			if len(payload) < 5 {
+				return nil
+			}
+			nalu := append([]byte{payload[0], payload[1]}, payload[4:]...)
+			return [][]byte{nalu}
+		}
+		return [][]byte{payload}
+	}
+}
+
+// unpackAP 聚合包: 负载头 [DONL] (NALU 大小, [DOND], NALU)...
+func (d *h265Depacketizer) unpackAP(payload []byte) (nalus [][]byte) {
+	data := payload[2:]
+	first := true
+	for len(data) > 0 {
+		if d.donl {
+			skip := 1
+			if first {
+				skip = 2
+			}
+			if len(data) < skip {
+				return
+			}
+			data = data[skip:]
+		}
+		first = false
+		if len(data) < 2 {
+			return
+		}
+		size := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if size == 0 || size > len(data) {
+			return
+		}
+		nalus = append(nalus, data[:size])
+		data = data[size:]
+	}
+	return
+}
+
+// unpackFU 分片包: 负载头, FU 头 (S|E|类型), [DONL], 分片数据
+func (d *h265Depacketizer) unpackFU(payload []byte) [][]byte {
+	fuHeader := payload[2]
+	isStart := fuHeader&0x80 != 0
+	isEnd := fuHeader&0x40 != 0
+	data := payload[3:]
+	if isStart {
+		if d.donl {
+			if len(data) < 2 {
+				return nil
+			}
+			data = data[2:]
+		}
+		d.fuBuffer = []byte{payload[0]&0x81 | (fuHeader&0x3F)<<1, payload[1]}
+	} else if d.fuBuffer == nil {
+		return nil
+	}
+	d.fuBuffer = append(d.fuBuffer, data...)
+	if isEnd {
+		nalu := d.fuBuffer
+		d.fuBuffer = nil
+		return [][]byte{nalu}
+	}
+	return nil
+}
+
+// h265Payloader WebRTC 发送端打包, 大于 mtu 的 NAL 拆成 FU
+type h265Payloader struct{}
+
+// Payload 实现 rtp.Payloader
+func (p *h265Payloader) Payload(mtu int, payload []byte) [][]byte {
+	var payloads [][]byte
+	for _, nalu := range splitAnnexB(payload) {
+		if len(nalu) < 2 || h265NALType(nalu) == h265NALAUD {
+			continue
+		}
+		if len(nalu) <= mtu {
+			out := make([]byte, len(nalu))
+			copy(out, nalu)
+			payloads = append(payloads, out)
+			continue
+		}
+		maxFragment := mtu - 3
+		if maxFragment <= 0 {
+			continue
+		}
+		nalType := h265NALType(nalu)
+		data := nalu[2:]
+		for i := 0; i < len(data); i += maxFragment {
+			end := i + maxFragment
+			if end > len(data) {
+				end = len(data)
+			}
+			out := make([]byte, 3+end-i)
+			out[0] = nalu[0]&0x81 | h265NALFU<<1
+			out[1] = nalu[1]
+			out[2] = nalType
+			if i == 0 {
+				out[2] |= 0x80
+			}
+			if end == len(data) {
+				out[2] |= 0x40
+			}
+			copy(out[3:], data[i:end])
+			payloads = append(payloads, out)
+		}
+	}
+	return payloads
+}
@@ -0,0 +1,134 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"bytes"
+	"testing"
+
+	"github.com/deepch/av"
+	psdp "github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+)
+
+func TestH265DepacketizerAP(t *testing.T) {
+	vps := []byte{0x40, 0x01, 0x0c, 0x01}
+	sps := []byte{0x42, 0x01, 0x01, 0x01, 0x60}
+	pps := []byte{0x44, 0x01, 0xc1}
+	payload := []byte{h265NALAP << 1, 0x01}
+	for _, nalu := range [][]byte{vps, sps, pps} {
+		payload = append(payload, byte(len(nalu)>>8), byte(len(nalu)))
+		payload = append(payload, nalu...)
+	}
+	nalus := (&h265Depacketizer{}).Unpack(payload)
+	if len(nalus) != 3 || !bytes.Equal(nalus[0], vps) || !bytes.Equal(nalus[1], sps) || !bytes.Equal(nalus[2], pps) {
+		t.Fatalf("unexpected nalus %x", nalus)
+	}
+}
+
+func TestH265PayloaderFURoundTrip(t *testing.T) {
+	idr := make([]byte, 3000)
+	idr[0] = 19 << 1 // IDR_W_RADL
+	idr[1] = 0x01
+	for i := 2; i < len(idr); i++ {
+		idr[i] = byte(i)
+	}
+	payloads := (&h265Payloader{}).Payload(1188, annexB(idr))
+	if len(payloads) < 3 {
+		t.Fatalf("expected fragmentation, got %d payloads", len(payloads))
+	}
+	depacketizer := &h265Depacketizer{}
+	var out [][]byte
+	for _, payload := range payloads {
+		if h265NALType(payload) != h265NALFU {
+			t.Fatalf("expected FU, got type %d", h265NALType(payload))
+		}
+		out = append(out, depacketizer.Unpack(payload)...)
+	}
+	if len(out) != 1 || !bytes.Equal(out[0], idr) {
+		t.Fatalf("round trip mismatch")
+	}
+}
+
+func TestH265DepacketizerSingleNAL(t *testing.T) {
+	idr := []byte{19 << 1, 0x01, 0xaf, 0x10, 0x20}
+	nalus := (&h265Depacketizer{}).Unpack(idr)
+	if len(nalus) != 1 || !bytes.Equal(nalus[0], idr) {
+		t.Fatalf("unexpected nalus %x", nalus)
+	}
+	// 不足 NAL 头加一个字节的负载丢弃
+	if nalus := (&h265Depacketizer{}).Unpack(idr[:2]); nalus != nil {
+		t.Fatalf("short payload unpacked to %x", nalus)
+	}
+}
+
+func TestH265DepacketizerDONL(t *testing.T) {
+	depacketizer := &h265Depacketizer{donl: true}
+	idr := []byte{19 << 1, 0x01, 0xaf, 0x10, 0x20}
+
+	// 单 NAL: 负载头之后的两字节 DONL 去掉
+	single := []byte{idr[0], idr[1], 0x00, 0x07}
+	single = append(single, idr[2:]...)
+	if nalus := depacketizer.Unpack(single); len(nalus) != 1 || !bytes.Equal(nalus[0], idr) {
+		t.Fatalf("single nal: unexpected nalus %x", nalus)
+	}
+
+	// 聚合包: 第一个 NAL 前为 DONL, 之后每个 NAL 前为一字节 DOND
+	vps := []byte{0x40, 0x01, 0x0c}
+	sps := []byte{0x42, 0x01, 0x01, 0x60}
+	ap := []byte{h265NALAP << 1, 0x01, 0x00, 0x07, 0x00, byte(len(vps))}
+	ap = append(ap, vps...)
+	ap = append(ap, 0x00, 0x00, byte(len(sps)))
+	ap = append(ap, sps...)
+	if nalus := depacketizer.Unpack(ap); len(nalus) != 2 || !bytes.Equal(nalus[0], vps) || !bytes.Equal(nalus[1], sps) {
+		t.Fatalf("aggregation: unexpected nalus %x", nalus)
+	}
+
+	// 分片: 只有起始分片带 DONL
+	start := []byte{h265NALFU << 1, 0x01, 0x80 | 19, 0x00, 0x07, 0xaf}
+	end := []byte{h265NALFU << 1, 0x01, 0x40 | 19, 0x10, 0x20}
+	if nalus := depacketizer.Unpack(start); nalus != nil {
+		t.Fatalf("fragment start returned %x", nalus)
+	}
+	if nalus := depacketizer.Unpack(end); len(nalus) != 1 || !bytes.Equal(nalus[0], idr) {
+		t.Fatalf("fragmentation: unexpected nalus %x", nalus)
+	}
+}
+
+func TestVideoCodecFromOffer(t *testing.T) {
+	offer := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 97 98 99\r\n" +
+		"a=rtpmap:96 H264/90000\r\na=fmtp:96 level-asymmetry-allowed=1;packetization-mode=0;profile-level-id=42e01f\r\n" +
+		"a=rtpmap:97 H264/90000\r\na=fmtp:97 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=640032\r\n" +
+		"a=rtpmap:98 H264/90000\r\na=fmtp:98 level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f\r\n" +
+		"a=rtpmap:99 H265/90000\r\n"
+	parsed := psdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(offer)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		codec       int
+		name        string
+		payloadType uint8
+	}{
+		{av.H264, webrtc.H264, 98}, // packetization-mode=1 中优先 constrained baseline
+		{sdp.H265, codecH265, 99},
+	}
+	for _, test := range tests {
+		selected, err := videoCodecFromOffer(&parsed, test.codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selected.Name != test.name || selected.PayloadType != test.payloadType {
+			t.Errorf("stream codec %d: selected %s/%d, want %s/%d", test.codec, selected.Name, selected.PayloadType, test.name, test.payloadType)
+		}
+	}
+
+	// 浏览器不支持 H.265 时拒绝, 不回退到 H.264
+	h264Only := psdp.SessionDescription{}
+	if err := h264Only.Unmarshal([]byte(offer[:len(offer)-len("a=rtpmap:99 H265/90000\r\n")])); err != nil {
+		t.Fatal(err)
+	}
+	if selected, err := videoCodecFromOffer(&h264Only, sdp.H265); err == nil {
+		t.Errorf("h265 stream selected %s for an h264 only offer", selected.Name)
+	}
+}
@@ -0,0 +1,634 @@
+package rtsp
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	log "github.com/sirupsen/logrus"
+)
+
+// 已注册的流, 按名称索引
+var (
+	hubsMutex sync.RWMutex
+	hubs      = make(map[string]*Hub)
+)
+
+// RegisterHub 注册流, 同名的旧流会被替换
+func RegisterHub(hub *Hub) {
+	hubsMutex.Lock()
+	defer hubsMutex.Unlock()
+	hubs[hub.Name] = hub
+}
+
+// UnregisterHub 注销流, 已被同名新流替换时不处理
+func UnregisterHub(hub *Hub) {
+	hubsMutex.Lock()
+	defer hubsMutex.Unlock()
+	if hubs[hub.Name] == hub {
+		delete(hubs, hub.Name)
+	}
+}
+
+// GetHub 按名称查找流
+func GetHub(name string) *Hub {
+	hubsMutex.RLock()
+	defer hubsMutex.RUnlock()
+	return hubs[name]
+}
+
+// 流状态
+const (
+	StateConnecting = "connecting"
+	StatePlaying    = "playing"
+	StateStopped    = "stopped"
+	StateIdle       = "idle" // 按需模式, 等待观看者
+)
+
+// 按需拉流参数
+const (
+	DefaultIdleTimeout = 30 * time.Second // 无观看者后关闭拉流的等待时间
+	onDemandStartWait  = 15 * time.Second // 观看者等待 DESCRIBE 完成的最长时间
+)
+
+// 流暂时不可用, 观看者无法加入
+var (
+	ErrStreamStopped  = errors.New("stream stopped")
+	ErrStreamNotReady = errors.New("stream not ready")
+)
+
+// viewerStartDelay 观看者连通后开始发送前的等待, 保证 pion 已启动 RTPSender, 回放的关键帧不被丢弃
+const viewerStartDelay = 50 * time.Millisecond
+
+// HubStatus 流状态与重连统计
+type HubStatus struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	State       string    `json:"state"`
+	Transport   string    `json:"transport"`
+	Codec       string    `json:"codec"`
+	AudioCodec  string    `json:"audio_codec,omitempty"`
+	Viewers     int       `json:"viewers"`
+	Reconnects  int       `json:"reconnects"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+	PlayingAt   time.Time `json:"playing_at,omitempty"`
+	// 浏览器 PLI/FIR 反馈统计
+	PLI               int `json:"pli"`
+	FIR               int `json:"fir"`
+	KeyframeThrottled int `json:"keyframe_throttled"`
+	KeyframeReplays   int `json:"keyframe_replays"`
+	KeyframeRequests  int `json:"keyframe_requests"`
+	// FrameTime 最近一帧视频按摄像机 SR 换算的墙上时间, 未收到 SR 时为空
+	FrameTime   time.Time   `json:"frame_time,omitempty"`
+	RTP         []RTPStats  `json:"rtp,omitempty"`
+	VideoJitter JitterStats `json:"video_jitter"`
+	AudioJitter JitterStats `json:"audio_jitter"`
+	// TimestampJumps 视频时间戳倒退或跳变的次数, 每次按当前输出位置重新对齐
+	TimestampJumps int `json:"timestamp_jumps"`
+	// Width/Height 按最近的 SPS 解析的分辨率, 未收到 SPS 时为 0
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
+// Resolution 视频分辨率
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// Hub 单路 RTSP 流的分发中心, 持有 Client 读循环, 任意数量的观看者可随时加入或离开
+type Hub struct {
+	Name string
+	URL  string
+	Stun *StunConfig
+	// MulticastInterface 组播传输时加入组播的网卡名
+	MulticastInterface string
+	// AudioTranscode AAC 音频转码输出 (opus/pcmu/pcma), 为空时不转码
+	AudioTranscode string
+	// Codecs 启用的编码名 (见 Codecs), 为空时全部启用
+	Codecs []string
+	// OnDemand 第一个观看者加入时才拉流, 无观看者 IdleTimeout 后断开
+	OnDemand    bool
+	IdleTimeout time.Duration
+	// GOPCacheSize GOP 缓存字节上限, 0 为默认值, 小于 0 时关闭
+	GOPCacheSize int
+	// ReorderWindow UDP/组播传输时重排等待的包数, 0 为默认值, 小于 0 时不等待
+	ReorderWindow int
+	// KeyframeParameter 非空时收到 PLI/FIR 后通过 SET_PARAMETER 向摄像机请求关键帧
+	KeyframeParameter string
+	// TLSConfig rtsps 地址的 TLS 设置 (CA, 客户端证书), 为空时按系统 CA 校验
+	TLSConfig         *tls.Config
+	mutex             sync.RWMutex
+	viewers           map[string]*Viewer
+	done              chan struct{}
+	stop              sync.Once
+	status            HubStatus
+	codecs            streamCodecs
+	quit              chan struct{} // 当前读循环的退出信号, nil 表示未运行
+	ready             chan struct{} // 当前读循环 DESCRIBE 完成后关闭
+	idleTimer         *time.Timer
+	gop               gopCache
+	client            *Client // 当前会话, 用于请求关键帧
+	sinks             []*sinkQueue
+	resolutionWatches map[chan Resolution]struct{}
+	// lastKeyframeRequest 上次向摄像机请求关键帧的时间
+	lastKeyframeRequest time.Time
+	// timer 视频帧计时器, 跨会话保留, 重连后 PTS 继续递增; 只在读循环中使用
+	timer *frameTimer
+}
+
+// HubNew 新建分发中心
+func HubNew(name, rtspURL string, stun *StunConfig) *Hub {
+	return &Hub{
+		Name:    name,
+		URL:     rtspURL,
+		Stun:    stun,
+		viewers: make(map[string]*Viewer),
+		done:    make(chan struct{}),
+		status:  HubStatus{Name: name, URL: rtspURL, State: StateStopped, Transport: TransportTCP, Codec: webrtc.H264},
+		codecs:  streamCodecs{video: av.H264},
+	}
+}
+
+// codecEnabled 编码是否在流配置中启用
+func (hub *Hub) codecEnabled(name string) bool {
+	if len(hub.Codecs) == 0 {
+		return true
+	}
+	for _, codec := range hub.Codecs {
+		if strings.EqualFold(codec, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTransport 设置拉流传输方式, 下次连接时生效
+func (hub *Hub) SetTransport(transport string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.status.Transport = transport
+}
+
+// setClient 记录当前会话的客户端, nil 表示会话结束
+func (hub *Hub) setClient(client *Client) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.client = client
+}
+
+// reorderWindow UDP 重排窗口
+func (hub *Hub) reorderWindow() int {
+	if hub.ReorderWindow == 0 {
+		return DefaultReorderWindow
+	}
+	return hub.ReorderWindow
+}
+
+// setJitterStats 记录当前会话的重排与丢包统计
+func (hub *Hub) setJitterStats(stats JitterStats, video bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if video {
+		hub.status.VideoJitter = stats
+	} else {
+		hub.status.AudioJitter = stats
+	}
+}
+
+// addTimestampJump 记录一次视频时间戳不连续
+func (hub *Hub) addTimestampJump() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.status.TimestampJumps++
+}
+
+// setResolution 记录 SPS 解析出的分辨率, 变化时通知订阅者
+func (hub *Hub) setResolution(width, height int) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.status.Width == width && hub.status.Height == height {
+		return
+	}
+	if hub.status.Width != 0 {
+		log.Infof("[%s] resolution changed %dx%d -> %dx%d", hub.Name, hub.status.Width, hub.status.Height, width, height)
+	}
+	hub.status.Width = width
+	hub.status.Height = height
+	resolution := Resolution{Width: width, Height: height}
+	for watch := range hub.resolutionWatches {
+		// 只保留最新的分辨率
+		select {
+		case <-watch:
+		default:
+		}
+		watch <- resolution
+	}
+}
+
+// WatchResolution 订阅分辨率变化, 已知分辨率时立即收到当前值; 不再需要时调用 cancel
+func (hub *Hub) WatchResolution() (watch <-chan Resolution, cancel func()) {
+	ch := make(chan Resolution, 1)
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.resolutionWatches == nil {
+		hub.resolutionWatches = make(map[chan Resolution]struct{})
+	}
+	hub.resolutionWatches[ch] = struct{}{}
+	if hub.status.Width != 0 {
+		ch <- Resolution{Width: hub.status.Width, Height: hub.status.Height}
+	}
+	return ch, func() {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		delete(hub.resolutionWatches, ch)
+	}
+}
+
+// setCodecs 记录 DESCRIBE 得到的音视频编码, 关闭按旧编码协商的观看者
+func (hub *Hub) setCodecs(codecs streamCodecs) {
+	hub.mutex.Lock()
+	hub.codecs = codecs
+	hub.status.Codec = videoCodecName(codecs.video)
+	hub.status.AudioCodec = audioCodecName(codecs.audio)
+	var stale []*Viewer
+	for id, viewer := range hub.viewers {
+		if viewer.codecs != codecs {
+			stale = append(stale, viewer)
+			delete(hub.viewers, id)
+		}
+	}
+	hub.mutex.Unlock()
+	for _, viewer := range stale {
+		viewer.Close()
+		log.Infof("[%s] viewer %s closed, stream codecs changed", hub.Name, viewer.ID)
+	}
+	if len(stale) > 0 {
+		hub.checkIdle()
+	}
+}
+
+// streamCodecs 当前音视频编码
+func (hub *Hub) streamCodecs() streamCodecs {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.codecs
+}
+
+// transport 当前传输方式
+func (hub *Hub) transport() string {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.status.Transport
+}
+
+// Start 后台运行 RTSP 读循环, 断线自动重连; 按需模式下等待第一个观看者
+func (hub *Hub) Start() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.gop.limit = hub.GOPCacheSize
+	if hub.OnDemand {
+		hub.status.State = StateIdle
+		return
+	}
+	hub.startLocked()
+}
+
+// startLocked 启动读循环, 已在运行时不处理
+func (hub *Hub) startLocked() {
+	if hub.quit != nil {
+		return
+	}
+	hub.quit = make(chan struct{})
+	hub.ready = make(chan struct{})
+	go hub.run(hub.quit)
+}
+
+// runExited 读循环退出, 按需模式回到空闲状态
+func (hub *Hub) runExited(quit chan struct{}) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.quit == quit {
+		hub.quit = nil
+		hub.ready = nil
+	}
+	hub.gop.reset()
+	if hub.OnDemand && !hub.stopped() {
+		hub.status.State = StateIdle
+	} else {
+		hub.status.State = StateStopped
+	}
+}
+
+// markReady DESCRIBE 完成, 放行等待中的观看者
+func (hub *Hub) markReady(quit chan struct{}) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.quit == quit && !isClosed(hub.ready) {
+		close(hub.ready)
+	}
+}
+
+// demand 等待 DESCRIBE 得到编码信息, 按需模式下先启动拉流并取消空闲关闭; 读循环未运行时返回 ErrStreamNotReady
+func (hub *Hub) demand() error {
+	hub.mutex.Lock()
+	if hub.OnDemand {
+		if hub.idleTimer != nil {
+			hub.idleTimer.Stop()
+			hub.idleTimer = nil
+		}
+		hub.startLocked()
+	}
+	ready := hub.ready
+	hub.mutex.Unlock()
+	if ready == nil {
+		return ErrStreamNotReady
+	}
+
+	select {
+	case <-ready:
+		return nil
+	case <-hub.done:
+		return ErrStreamStopped
+	case <-time.After(onDemandStartWait):
+		hub.checkIdle()
+		return ErrStreamNotReady
+	}
+}
+
+// markUnready 会话结束, 重连的 DESCRIBE 完成之前新的观看者等待
+func (hub *Hub) markUnready(quit chan struct{}) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.quit == quit && isClosed(hub.ready) {
+		hub.ready = make(chan struct{})
+	}
+}
+
+// checkIdle 按需模式下没有观看者时, IdleTimeout 后关闭读循环
+func (hub *Hub) checkIdle() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if !hub.OnDemand || len(hub.viewers) > 0 || hub.quit == nil || hub.idleTimer != nil {
+		return
+	}
+	timeout := hub.IdleTimeout
+	if timeout <= 0 {
+		timeout = DefaultIdleTimeout
+	}
+	quit := hub.quit
+	hub.idleTimer = time.AfterFunc(timeout, func() {
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		hub.idleTimer = nil
+		if len(hub.viewers) == 0 && hub.quit == quit {
+			close(quit)
+			hub.quit = nil
+			hub.ready = nil
+		}
+	})
+}
+
+// Stop 停止拉流并关闭所有观看者
+func (hub *Hub) Stop() {
+	hub.stop.Do(func() {
+		close(hub.done)
+	})
+	hub.mutex.Lock()
+	if hub.idleTimer != nil {
+		hub.idleTimer.Stop()
+		hub.idleTimer = nil
+	}
+	hub.status.State = StateStopped
+	viewers := hub.viewers
+	hub.viewers = make(map[string]*Viewer)
+	for _, q := range hub.sinks {
+		q.close()
+	}
+	hub.sinks = nil
+	hub.mutex.Unlock()
+	for _, viewer := range viewers {
+		viewer.Close()
+	}
+}
+
+// stopped 是否已调用 Stop
+func (hub *Hub) stopped() bool {
+	select {
+	case <-hub.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Status 返回流状态快照
+func (hub *Hub) Status() HubStatus {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	status := hub.status
+	status.Viewers = len(hub.viewers)
+	if hub.client != nil {
+		status.RTP = hub.client.Stats()
+	}
+	return status
+}
+
+// setState 更新流状态
+func (hub *Hub) setState(state string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.status.State = state
+	if state == StatePlaying {
+		hub.status.PlayingAt = time.Now()
+	}
+}
+
+// recordFailure 记录失败原因, 返回本次重连序号
+func (hub *Hub) recordFailure(err error) int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.status.Reconnects++
+	if err != nil {
+		hub.status.LastError = err.Error()
+	}
+	hub.status.LastErrorAt = time.Now()
+	return hub.status.Reconnects
+}
+
+// AddViewer 根据 offer 新建观看者并挂载到当前流
+func (hub *Hub) AddViewer(offerSdp string) (viewer *Viewer, answerSdp string, err error) {
+	if hub.stopped() {
+		return nil, "", ErrStreamStopped
+	}
+	// 等待 DESCRIBE 得到编码后再协商, 之后与其他观看者一样从第一个关键帧开始播放
+	if err := hub.demand(); err != nil {
+		return nil, "", err
+	}
+	viewer, answerSdp, err = newViewer(offerSdp, hub.Stun, hub.streamCodecs())
+	if err != nil {
+		hub.checkIdle()
+		return nil, "", err
+	}
+	id := viewer.ID
+	viewer.peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		log.Infof("[%s] viewer %s connection state has changed %s", hub.Name, id, connectionState.String())
+		switch connectionState {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			hub.RemoveViewer(id)
+		}
+	})
+	// ICE 连通时 DTLS 尚未握手, track 仍会丢弃写入的数据, 等 PeerConnection 整体连通后再开始发送;
+	// pion 在触发该回调之后才启动 RTPSender, 稍等片刻再回放
+	viewer.peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateConnected {
+			time.AfterFunc(viewerStartDelay, func() { hub.viewerConnected(viewer) })
+		}
+	})
+
+	// 与 Stop 在同一把锁下检查并加入, Stop 之后不会再挂上新的观看者
+	hub.mutex.Lock()
+	if hub.stopped() {
+		hub.mutex.Unlock()
+		viewer.Close()
+		return nil, "", ErrStreamStopped
+	}
+	// 协商期间重连改变了编码
+	if viewer.codecs != hub.codecs {
+		hub.mutex.Unlock()
+		viewer.Close()
+		hub.checkIdle()
+		return nil, "", ErrStreamNotReady
+	}
+	hub.viewers[id] = viewer
+	hub.mutex.Unlock()
+	go viewer.writeLoop()
+	go viewer.readRTCP(hub.keyframeRequested)
+
+	log.Infof("[%s] viewer %s attached", hub.Name, id)
+	return viewer, answerSdp, nil
+}
+
+// viewerConnected 观看者连通, 回放缓存的 GOP 并开始接收直播帧; 没有缓存时从下一个关键帧开始
+func (hub *Hub) viewerConnected(viewer *Viewer) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.viewers[viewer.ID] != viewer || viewer.live {
+		return
+	}
+	viewer.live = true
+	viewer.synced = false
+	// 持锁回放, 保证缓存帧在后续直播帧之前入队
+	hub.gop.replay(viewer)
+	close(viewer.connected)
+	log.Infof("[%s] viewer %s connected, replayed %d frames", hub.Name, viewer.ID, len(hub.gop.frames))
+}
+
+// RemoveViewer 移除并关闭观看者
+func (hub *Hub) RemoveViewer(id string) {
+	hub.mutex.Lock()
+	viewer, ok := hub.viewers[id]
+	delete(hub.viewers, id)
+	hub.mutex.Unlock()
+	if ok {
+		viewer.Close()
+		log.Infof("[%s] viewer %s detached", hub.Name, id)
+		hub.checkIdle()
+	}
+}
+
+// Viewer 按 ID 查找观看者
+func (hub *Hub) Viewer(id string) *Viewer {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return hub.viewers[id]
+}
+
+// ViewerCount 当前观看者数量
+func (hub *Hub) ViewerCount() int {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	return len(hub.viewers)
+}
+
+// sessionTimer 返回跨会话的视频帧计时器, 新会话开始时重新对齐
+func (hub *Hub) sessionTimer(clockRate int) *frameTimer {
+	if hub.timer == nil {
+		hub.timer = newFrameTimer(clockRate, videoClockRate)
+	} else {
+		hub.timer.rebase(clockRate)
+	}
+	return hub.timer
+}
+
+// resync 让所有观看者等待下一个关键帧
+func (hub *Hub) resync() {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.gop.reset()
+	for _, viewer := range hub.viewers {
+		viewer.synced = false
+	}
+}
+
+// WriteFrame 实现 FrameSink, 分发给所有观看者与附加的输出
+func (hub *Hub) WriteFrame(frame *Frame) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if !frame.WallClock.IsZero() {
+		hub.status.FrameTime = frame.WallClock
+	}
+	hub.gop.add(frame)
+	for _, viewer := range hub.viewers {
+		viewer.WriteFrame(frame)
+	}
+	for _, sink := range hub.sinks {
+		sink.WriteFrame(frame)
+	}
+}
+
+// AddSink 附加视频帧输出 (录像, HLS 等), 从下一帧开始接收, 需自行等待关键帧;
+// sink 在独立 goroutine 中调用, 跟不上时丢帧直到下一个关键帧, 不影响观看者
+func (hub *Hub) AddSink(sink FrameSink) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.sinks = append(hub.sinks, newSinkQueue(sink))
+}
+
+// RemoveSink 移除 AddSink 附加的输出, 之后不再投递新帧
+func (hub *Hub) RemoveSink(sink FrameSink) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for i, q := range hub.sinks {
+		if q.sink == sink {
+			q.close()
+			hub.sinks = append(hub.sinks[:i], hub.sinks[i+1:]...)
+			return
+		}
+	}
+}
+
+// broadcastAudio 透传音频 RTP 给所有观看者
+func (hub *Hub) broadcastAudio(packet *rtp.Packet) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for _, viewer := range hub.viewers {
+		viewer.sendAudio(packet)
+	}
+}
+
+// broadcastAudioSample 分发转码后的音频帧
+func (hub *Hub) broadcastAudioSample(sample media.Sample) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+	for _, viewer := range hub.viewers {
+		viewer.sendAudioSample(sample)
+	}
+}
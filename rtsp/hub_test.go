@@ -0,0 +1,376 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deepch/av"
+)
+
+func TestHubOnDemand(t *testing.T) {
+	var describes int32
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			atomic.AddInt32(&describes, 1)
+			return 200, "", testutil.SDP
+		case "SETUP":
+			return 200, "Session: 12345678;timeout=60\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	hub := HubNew("on-demand", server.URL("/live"), &StunConfig{})
+	hub.OnDemand = true
+	hub.IdleTimeout = 50 * time.Millisecond
+	hub.Start()
+	defer hub.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	if state := hub.Status().State; state != StateIdle {
+		t.Fatalf("state before viewers = %s", state)
+	}
+	if n := atomic.LoadInt32(&describes); n != 0 {
+		t.Fatalf("camera opened %d times without viewers", n)
+	}
+
+	for round := int32(1); round <= 2; round++ {
+		if err := hub.demand(); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&describes); n != round {
+			t.Fatalf("round %d: %d describes", round, n)
+		}
+		if state := hub.Status().State; state != StatePlaying {
+			t.Fatalf("round %d: state = %s", round, state)
+		}
+		// 没有观看者, 空闲超时后关闭
+		hub.checkIdle()
+		deadline := time.Now().Add(2 * time.Second)
+		for hub.Status().State != StateIdle {
+			if time.Now().After(deadline) {
+				t.Fatalf("round %d: not idle after timeout, state = %s", round, hub.Status().State)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestGOPCache(t *testing.T) {
+	cache := &gopCache{limit: 20}
+	cache.add(&Frame{NALUs: [][]byte{{1}}})
+	if len(cache.frames) != 0 {
+		t.Fatal("cached frames before the first keyframe")
+	}
+	cache.add(&Frame{NALUs: [][]byte{{1, 2, 3}}, Keyframe: true, Duration: 40 * time.Millisecond})
+	cache.add(&Frame{NALUs: [][]byte{{4, 5}}})
+	cache.add(&Frame{NALUs: [][]byte{{6}}})
+	if len(cache.frames) != 3 || cache.size != 15 {
+		t.Fatalf("cache = %d frames, %d bytes", len(cache.frames), cache.size)
+	}
+
+	viewer := &Viewer{queue: make(chan *Frame, viewerQueueSize), live: true}
+	cache.replay(viewer)
+	if len(viewer.queue) != 3 || !viewer.synced {
+		t.Fatalf("replayed %d frames, synced %v", len(viewer.queue), viewer.synced)
+	}
+	for len(viewer.queue) > 0 {
+		if frame := <-viewer.queue; frame.Duration != gopReplayStep || frame.samples(videoClockRate) != 90 {
+			t.Errorf("replayed duration %s", frame.Duration)
+		}
+	}
+
+	// 超过上限丢弃整个 GOP, 等待下一个关键帧
+	cache.add(&Frame{NALUs: [][]byte{make([]byte, 5)}})
+	if len(cache.frames) != 0 || cache.size != 0 {
+		t.Fatalf("cache over limit = %d frames", len(cache.frames))
+	}
+	cache.add(&Frame{NALUs: [][]byte{{7}}})
+	if len(cache.frames) != 0 {
+		t.Fatal("cached frames after overflow without keyframe")
+	}
+
+	disabled := &gopCache{limit: -1}
+	disabled.add(&Frame{NALUs: [][]byte{{1}}, Keyframe: true})
+	if len(disabled.frames) != 0 {
+		t.Fatal("disabled cache stored a frame")
+	}
+}
+
+func TestWatchResolution(t *testing.T) {
+	hub := HubNew("resolution", "rtsp://127.0.0.1/", &StunConfig{})
+	watch, cancel := hub.WatchResolution()
+	defer cancel()
+	hub.setResolution(1920, 1080)
+	hub.setResolution(1920, 1080)
+	hub.setResolution(1280, 720) // 未读取的旧值被替换
+	if got := <-watch; got != (Resolution{Width: 1280, Height: 720}) {
+		t.Errorf("resolution = %+v", got)
+	}
+	if len(watch) != 0 {
+		t.Error("unchanged resolution notified")
+	}
+	if status := hub.Status(); status.Width != 1280 || status.Height != 720 {
+		t.Errorf("status = %dx%d", status.Width, status.Height)
+	}
+	// 分辨率按流记录, 不影响其他流
+	if other := HubNew("resolution-other", "rtsp://127.0.0.1/", &StunConfig{}).Status(); other.Width != 0 || other.Height != 0 {
+		t.Errorf("other stream = %dx%d", other.Width, other.Height)
+	}
+
+	late, cancelLate := hub.WatchResolution()
+	defer cancelLate()
+	if got := <-late; got.Width != 1280 {
+		t.Errorf("current resolution not delivered on subscribe: %+v", got)
+	}
+}
+
+func TestHubFanOut(t *testing.T) {
+	hub := HubNew("fan-out", "rtsp://127.0.0.1/", &StunConfig{})
+	a := &Viewer{ID: "a", queue: make(chan *Frame, viewerQueueSize), live: true}
+	b := &Viewer{ID: "b", queue: make(chan *Frame, viewerQueueSize), live: true}
+	hub.viewers[a.ID] = a
+	hub.viewers[b.ID] = b
+	sink := make(chanSink, 16)
+	hub.AddSink(sink)
+
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}}) // 第一个关键帧之前的帧不发给观看者
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}})
+	for _, viewer := range []*Viewer{a, b} {
+		if len(viewer.queue) != 2 || !(<-viewer.queue).Keyframe {
+			t.Errorf("viewer %s queued %d frames", viewer.ID, len(viewer.queue))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-sink:
+		case <-time.After(time.Second):
+			t.Fatalf("sink got %d frames", i)
+		}
+	}
+
+	hub.RemoveSink(sink)
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}})
+	select {
+	case <-sink:
+		t.Error("removed sink still receives frames")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// chanSink 把帧送入通道的 FrameSink, 通道满时阻塞
+type chanSink chan *Frame
+
+func (sink chanSink) WriteFrame(frame *Frame) {
+	sink <- frame
+}
+
+func TestHubBlockedSink(t *testing.T) {
+	hub := HubNew("blocked-sink", "rtsp://127.0.0.1/", &StunConfig{})
+	viewer := &Viewer{ID: "v", queue: make(chan *Frame, viewerQueueSize), live: true}
+	hub.viewers[viewer.ID] = viewer
+	blocked := make(chanSink) // 从不读取
+	hub.AddSink(blocked)
+	defer hub.RemoveSink(blocked)
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		hub.WriteFrame(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+		for i := 0; i < 2*sinkQueueSize; i++ {
+			hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}})
+			<-viewer.queue
+		}
+	}()
+	select {
+	case <-written:
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked sink stalled the hub")
+	}
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if q := hub.sinks[0]; !q.skipping || q.dropped != 1 {
+		t.Errorf("sink queue skipping %v, dropped %d", q.skipping, q.dropped)
+	}
+}
+
+func TestHubSlowViewer(t *testing.T) {
+	hub := HubNew("slow", "rtsp://127.0.0.1/", &StunConfig{})
+	slow := &Viewer{ID: "slow", queue: make(chan *Frame, viewerQueueSize), live: true} // 从不读取
+	fast := &Viewer{ID: "fast", queue: make(chan *Frame, viewerQueueSize), live: true}
+	hub.viewers[slow.ID] = slow
+	hub.viewers[fast.ID] = fast
+	delivered := 0
+	write := func(frame *Frame) {
+		hub.WriteFrame(frame)
+		for len(fast.queue) > 0 {
+			<-fast.queue
+			delivered++
+		}
+	}
+
+	write(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+	for i := 0; i < 2*viewerQueueSize; i++ {
+		write(&Frame{NALUs: [][]byte{{0x41}}})
+	}
+	if delivered != 2*viewerQueueSize+1 {
+		t.Errorf("fast viewer got %d frames", delivered)
+	}
+	if len(slow.queue) != viewerQueueSize || slow.synced || slow.dropped != 1 {
+		t.Fatalf("slow viewer: queued %d, synced %v, dropped %d", len(slow.queue), slow.synced, slow.dropped)
+	}
+
+	// 队列腾空后仍丢弃非关键帧, 直到下一个关键帧
+	for len(slow.queue) > 0 {
+		<-slow.queue
+	}
+	write(&Frame{NALUs: [][]byte{{0x41}}})
+	if len(slow.queue) != 0 {
+		t.Fatal("slow viewer resumed without a keyframe")
+	}
+	write(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+	if len(slow.queue) != 1 || !slow.synced {
+		t.Errorf("slow viewer not resynced: queued %d", len(slow.queue))
+	}
+}
+
+func TestAddViewerAfterStop(t *testing.T) {
+	hub := HubNew("stopped", "rtsp://127.0.0.1/", &StunConfig{})
+	hub.Stop()
+	if _, _, err := hub.AddViewer(""); err == nil {
+		t.Error("viewer added to stopped hub")
+	}
+	if hub.ViewerCount() != 0 {
+		t.Errorf("viewers = %d", hub.ViewerCount())
+	}
+}
+
+// ptsRecorder 记录读循环写出的帧的 PTS
+type ptsRecorder struct {
+	mutex sync.Mutex
+	pts   []time.Duration
+}
+
+func (recorder *ptsRecorder) WriteFrame(frame *Frame) {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	recorder.pts = append(recorder.pts, frame.PTS)
+}
+
+func (recorder *ptsRecorder) frames() []time.Duration {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+	return append([]time.Duration(nil), recorder.pts...)
+}
+
+func TestHubReconnectPTS(t *testing.T) {
+	var sessions int32
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			return 200, "Session: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			// 第二个会话的时间戳与第一个无关
+			base := uint32(3000000000)
+			if atomic.AddInt32(&sessions, 1) > 1 {
+				base = 1000
+			}
+			go func() {
+				time.Sleep(50 * time.Millisecond)
+				for i := uint32(0); i < 5; i++ {
+					packet := append(rtpPacket(uint16(i+1), base+i*3600, 0x1234), 0x65, 0xaa)
+					packet[1] |= 0x80
+					conn.Write(append([]byte{'$', 0, 0, byte(len(packet))}, packet...))
+				}
+				time.Sleep(50 * time.Millisecond)
+				conn.Close()
+			}()
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	hub := HubNew("reconnect", server.URL("/live"), &StunConfig{})
+	recorder := &ptsRecorder{}
+	hub.AddSink(recorder)
+	hub.Start()
+	defer hub.Stop()
+
+	// 每个会话 5 帧, 都带 marker 位, 立即写出
+	deadline := time.Now().Add(5 * time.Second)
+	for len(recorder.frames()) < 10 {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d frames, status %+v", len(recorder.frames()), hub.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pts := recorder.frames()
+	for i := 1; i < len(pts); i++ {
+		if pts[i] <= pts[i-1] {
+			t.Errorf("pts[%d] = %s after %s", i, pts[i], pts[i-1])
+		}
+	}
+	if jumps := hub.Status().TimestampJumps; jumps != 1 {
+		t.Errorf("timestamp jumps = %d", jumps)
+	}
+}
+
+func TestAddViewerWaitsForDescribe(t *testing.T) {
+	camera := testutil.Camera(testutil.SDP + "m=audio 0 RTP/AVP 8\r\na=control:trackID=1\r\n")
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		if req.Method == "DESCRIBE" {
+			time.Sleep(100 * time.Millisecond)
+		}
+		return camera(req, conn)
+	})
+	defer server.Close()
+
+	hub := HubNew("describe", server.URL("/live"), &StunConfig{})
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+	if _, _, err := hub.AddViewer(offer); err != ErrStreamNotReady {
+		t.Errorf("before start: err = %v", err)
+	}
+	// 常驻模式同样等待 DESCRIBE, 按摄像机的编码协商
+	hub.Start()
+	defer hub.Stop()
+	viewer, _, err := hub.AddViewer(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viewer.codecs != (streamCodecs{video: av.H264, audio: av.PCM_ALAW}) {
+		t.Errorf("viewer codecs = %+v", viewer.codecs)
+	}
+}
+
+func TestSetCodecsClosesStaleViewers(t *testing.T) {
+	hub := HubNew("codecs", "rtsp://127.0.0.1/", &StunConfig{})
+	defer hub.Stop()
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+	h264 := streamCodecs{video: av.H264}
+	withAudio := streamCodecs{video: av.H264, audio: av.PCM_MULAW}
+	var viewers []*Viewer
+	for _, codecs := range []streamCodecs{h264, withAudio} {
+		viewer, _, err := newViewer(offer, hub.Stun, codecs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hub.viewers[viewer.ID] = viewer
+		viewers = append(viewers, viewer)
+	}
+
+	// 重连后摄像机增加了音频, 按旧编码协商的观看者没有音频 track
+	hub.setCodecs(withAudio)
+	if !isClosed(viewers[0].done) || isClosed(viewers[1].done) || hub.ViewerCount() != 1 {
+		t.Errorf("closed %v/%v, %d viewers left", isClosed(viewers[0].done), isClosed(viewers[1].done), hub.ViewerCount())
+	}
+}
@@ -0,0 +1,113 @@
+package rtsp
+
+// 重排窗口 (包数)
+const (
+	DefaultReorderWindow = 32
+	jitterResyncAfter    = 64 // 连续收到这么多过期包时认为摄像机重置了序号
+)
+
+// JitterStats 单路媒体的重排与丢包统计
+type JitterStats struct {
+	Reordered     int `json:"reordered"`
+	Duplicates    int `json:"duplicates"`
+	Late          int `json:"late"` // 已跳过的序号之后才到达
+	Gaps          int `json:"gaps"`
+	Lost          int `json:"lost"`
+	DroppedFrames int `json:"dropped_frames"`
+}
+
+// jitterPacket 按序输出的包, lost 为其之前被跳过的包数
+type jitterPacket struct {
+	seq  uint16
+	data []byte
+	lost int
+}
+
+// jitterBuffer 按 RTP 序号重排, 缺失的包在缓存超过 window 个包后放弃
+type jitterBuffer struct {
+	window  int
+	pending map[uint16][]byte
+	started bool
+	next    uint16 // 下一个输出的序号
+	highest uint16 // 收到的最大序号
+	lost    int    // 待附加到下一个输出包的丢包数
+	lateRun int
+	stats   JitterStats
+}
+
+func newJitterBuffer(window int) *jitterBuffer {
+	if window < 0 {
+		window = 0
+	}
+	return &jitterBuffer{window: window, pending: make(map[uint16][]byte)}
+}
+
+// push 放入一个包, 返回可以按序输出的包
+func (b *jitterBuffer) push(seq uint16, data []byte) (out []jitterPacket) {
+	if !b.started || b.lateRun >= jitterResyncAfter {
+		b.reset(seq)
+	}
+	if diff := int16(seq - b.next); diff < 0 {
+		b.stats.Late++
+		b.lateRun++
+		return nil
+	}
+	b.lateRun = 0
+	if _, ok := b.pending[seq]; ok {
+		b.stats.Duplicates++
+		return nil
+	}
+	if int16(seq-b.highest) < 0 {
+		b.stats.Reordered++
+	} else {
+		b.highest = seq
+	}
+	b.pending[seq] = data
+	out = b.drain(out)
+	for len(b.pending) > b.window {
+		b.skip()
+		out = b.drain(out)
+	}
+	return out
+}
+
+// reset 从 seq 重新开始, 丢弃缓存
+func (b *jitterBuffer) reset(seq uint16) {
+	b.started = true
+	b.next = seq
+	b.highest = seq
+	b.lost = 0
+	b.lateRun = 0
+	b.pending = make(map[uint16][]byte)
+}
+
+// drain 输出从 next 开始连续的包
+func (b *jitterBuffer) drain(out []jitterPacket) []jitterPacket {
+	for {
+		data, ok := b.pending[b.next]
+		if !ok {
+			return out
+		}
+		delete(b.pending, b.next)
+		out = append(out, jitterPacket{seq: b.next, data: data, lost: b.lost})
+		b.lost = 0
+		b.next++
+	}
+}
+
+// skip 放弃等待缺失的包, 跳到缓存中最小的序号
+func (b *jitterBuffer) skip() {
+	gap := -1
+	for seq := range b.pending {
+		if d := int(uint16(seq - b.next)); gap < 0 || d < gap {
+			gap = d
+		}
+	}
+	if gap <= 0 {
+		return
+	}
+	b.stats.Gaps++
+	b.stats.Lost += gap
+	b.lost += gap
+	b.next += uint16(gap)
+}
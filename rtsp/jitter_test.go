@@ -0,0 +1,98 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestJitterBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		window int
+		seqs   []uint16
+		want   []uint16
+		lost   map[uint16]int // 输出包之前的丢包数
+		stats  JitterStats
+	}{
+		{
+			name:   "in order",
+			window: 4,
+			seqs:   []uint16{1, 2, 3},
+			want:   []uint16{1, 2, 3},
+		},
+		{
+			name:   "reordered",
+			window: 4,
+			seqs:   []uint16{1, 3, 2, 4},
+			want:   []uint16{1, 2, 3, 4},
+			stats:  JitterStats{Reordered: 1},
+		},
+		{
+			name:   "wraparound",
+			window: 4,
+			seqs:   []uint16{0xFFFE, 0, 0xFFFF, 1},
+			want:   []uint16{0xFFFE, 0xFFFF, 0, 1},
+			stats:  JitterStats{Reordered: 1},
+		},
+		{
+			name:   "duplicate and late",
+			window: 1,
+			seqs:   []uint16{1, 3, 3, 4, 2},
+			want:   []uint16{1, 3, 4},
+			lost:   map[uint16]int{3: 1},
+			stats:  JitterStats{Duplicates: 1, Late: 1, Gaps: 1, Lost: 1},
+		},
+		{
+			name:   "gap beyond window",
+			window: 2,
+			seqs:   []uint16{1, 4, 5, 6, 7},
+			want:   []uint16{1, 4, 5, 6, 7},
+			lost:   map[uint16]int{4: 2},
+			stats:  JitterStats{Gaps: 1, Lost: 2},
+		},
+		{
+			name:   "tcp detects gaps without waiting",
+			window: 0,
+			seqs:   []uint16{10, 11, 13},
+			want:   []uint16{10, 11, 13},
+			lost:   map[uint16]int{13: 1},
+			stats:  JitterStats{Gaps: 1, Lost: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buffer := newJitterBuffer(test.window)
+			var got []jitterPacket
+			for _, seq := range test.seqs {
+				got = append(got, buffer.push(seq, []byte{byte(seq)})...)
+			}
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d packets %+v, want %v", len(got), got, test.want)
+			}
+			for i, packet := range got {
+				if packet.seq != test.want[i] || packet.data[0] != byte(test.want[i]) {
+					t.Errorf("packet %d = #%d, want #%d", i, packet.seq, test.want[i])
+				}
+				if packet.lost != test.lost[packet.seq] {
+					t.Errorf("packet #%d lost = %d, want %d", packet.seq, packet.lost, test.lost[packet.seq])
+				}
+			}
+			if buffer.stats != test.stats {
+				t.Errorf("stats = %+v, want %+v", buffer.stats, test.stats)
+			}
+		})
+	}
+}
+
+func TestJitterBufferResync(t *testing.T) {
+	buffer := newJitterBuffer(0)
+	buffer.push(5000, nil)
+	// 摄像机重启后序号从头开始
+	for seq := uint16(0); seq < jitterResyncAfter; seq++ {
+		if out := buffer.push(seq, nil); len(out) != 0 {
+			t.Fatalf("late packet #%d delivered", seq)
+		}
+	}
+	if out := buffer.push(jitterResyncAfter, nil); len(out) != 1 || out[0].lost != 0 {
+		t.Fatalf("after resync = %+v", out)
+	}
+}
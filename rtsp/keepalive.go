@@ -0,0 +1,81 @@
+package rtsp
+
+import (
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPendingReplies 未收到应答的请求记录上限, 服务器从不回复时防止无限增长
+const maxPendingReplies = 64
+
+// keepaliveInterval 按 Session 头的 timeout (秒) 计算保活间隔, 取一半留出余量
+func keepaliveInterval(timeout int) int {
+	if timeout < 2 {
+		return 1
+	}
+	return timeout / 2
+}
+
+// sessionTimeout 解析 Session 头中的 ;timeout=, 没有时返回 0
+func sessionTimeout(session string) int {
+	for _, param := range strings.Split(session, ";")[1:] {
+		keyval := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(keyval) == 2 && strings.EqualFold(strings.TrimSpace(keyval[0]), "timeout") {
+			if timeout, err := strconv.Atoi(strings.TrimSpace(keyval[1])); err == nil && timeout > 0 {
+				return timeout
+			}
+		}
+	}
+	return 0
+}
+
+// keepaliveMethod 服务器在 Public 中声明支持时使用 GET_PARAMETER, 否则使用 OPTIONS
+func (client *Client) keepaliveMethod() string {
+	if client.getParameter {
+		return "GET_PARAMETER"
+	}
+	return "OPTIONS"
+}
+
+// sendKeepalive 发送保活请求, 不等待应答, 应答由读循环交给 controlReply
+func (client *Client) sendKeepalive() error {
+	return client.Write(client.keepaliveMethod(), "", "", false, true)
+}
+
+// expectReply 记录不等待应答的请求, 应答到达时按 CSeq 找到对应的方法
+func (client *Client) expectReply(method string) {
+	if len(client.pending) >= maxPendingReplies {
+		client.pending = make(map[int]string)
+	}
+	client.pending[client.cseq] = method
+}
+
+// controlReply 处理保活, SET_PARAMETER 等不等待应答的请求的回复, 返回 true 时应立即重发保活
+func (client *Client) controlReply(resp *Response) bool {
+	method := client.pending[resp.CSeq()]
+	delete(client.pending, resp.CSeq())
+	if client.Debug {
+		log.Println("control reply", method, resp.StatusCode, resp.Status)
+	}
+	keepalive := method == "OPTIONS" || method == "GET_PARAMETER"
+	switch {
+	case resp.StatusCode == 200:
+		return false
+	case resp.StatusCode == 401:
+		// nonce 过期时换新 nonce 重发, 之后的请求也使用新 nonce
+		stale, err := client.challenged(resp)
+		if err != nil {
+			log.Warnf("[%s] %s authorization: %v", client.Name, method, err)
+			return false
+		}
+		return stale && keepalive
+	case method == "GET_PARAMETER" && (resp.StatusCode == 405 || resp.StatusCode == 501):
+		log.Infof("[%s] GET_PARAMETER keepalive rejected, fallback to OPTIONS", client.Name)
+		client.getParameter = false
+		return true
+	}
+	log.Warnf("[%s] %s returned status %d", client.Name, method, resp.StatusCode)
+	return false
+}
@@ -0,0 +1,141 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionTimeout(t *testing.T) {
+	tests := []struct {
+		session   string
+		keepalive int
+	}{
+		{"12345678;timeout=60", 30},
+		{"12345678; Timeout = 10 ", 5},
+		{"12345678;timeout=1", 1},
+		{"12345678", 20},
+		{"12345678;timeout=abc", 20},
+	}
+	for _, test := range tests {
+		client := ClientNew()
+		client.ParseSetup(&Response{Header: textproto.MIMEHeader{"Session": {test.session}}})
+		if client.keepalivetime != test.keepalive || client.session != "Session: 12345678\r\n" {
+			t.Errorf("%q: keepalive %d, session %q", test.session, client.keepalivetime, client.session)
+		}
+	}
+}
+
+func TestControlReply(t *testing.T) {
+	client := ClientNew()
+	client.ParseOptions(&Response{Header: textproto.MIMEHeader{"Public": {"OPTIONS, DESCRIBE", "SETUP, PLAY, get_parameter"}}})
+	if client.keepaliveMethod() != "GET_PARAMETER" {
+		t.Fatalf("keepalive method = %s", client.keepaliveMethod())
+	}
+	reply := func(cseq string, status int) *Response {
+		return &Response{StatusCode: status, Header: textproto.MIMEHeader{"Cseq": {cseq}}}
+	}
+
+	client.cseq = 5
+	client.expectReply("SET_PARAMETER")
+	if client.controlReply(reply("5", 501)) || client.keepaliveMethod() != "GET_PARAMETER" {
+		t.Error("unsupported SET_PARAMETER disabled GET_PARAMETER keepalive")
+	}
+	client.cseq = 6
+	client.expectReply("GET_PARAMETER")
+	if !client.controlReply(reply("6", 405)) || client.keepaliveMethod() != "OPTIONS" {
+		t.Error("rejected GET_PARAMETER did not fall back to OPTIONS")
+	}
+	if len(client.pending) != 0 {
+		t.Errorf("pending = %v", client.pending)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	rtp := "$\x00\x00\x0e" + string(rtpPacket(1, 3600, 0x1234)) + "\x65\xaa"
+	var mutex sync.Mutex
+	var keepalives []string
+	var writes sync.WaitGroup
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		writes.Wait()
+	}()
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "OPTIONS":
+			return 200, "Public: OPTIONS, DESCRIBE, SETUP, PLAY, GET_PARAMETER, TEARDOWN\r\n", ""
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			return 200, "Session: 1;timeout=2\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			writes.Add(1)
+			go func() {
+				defer writes.Done()
+				for {
+					select {
+					case <-done:
+						return
+					case <-time.After(50 * time.Millisecond):
+						conn.Write([]byte(rtp))
+					}
+				}
+			}()
+		case "GET_PARAMETER":
+			mutex.Lock()
+			defer mutex.Unlock()
+			keepalives = append(keepalives, req.Header.Get("Authorization"))
+			if len(keepalives) == 1 {
+				return 401, "WWW-Authenticate: Digest realm=\"cam\", nonce=\"n2\", stale=true\r\n", ""
+			}
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = strings.Replace(server.URL("/live"), "rtsp://", "rtsp://admin:secret@", 1)
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.keepalivetime != 1 {
+		t.Fatalf("keepalive interval = %d", client.keepalivetime)
+	}
+
+	// 重发的 GET_PARAMETER 之后继续收 300ms, 确认应答没有打乱 interleaved 数据
+	deadline := time.After(5 * time.Second)
+	var settled <-chan time.Time
+	for finished := false; !finished; {
+		select {
+		case <-settled:
+			finished = true
+		case data := <-client.Outgoing:
+			if string(data) != rtp {
+				t.Fatalf("outgoing = %q", data)
+			}
+		case <-client.Signals:
+			t.Fatalf("read loop exited: %v", client.Err())
+		case <-deadline:
+			mutex.Lock()
+			defer mutex.Unlock()
+			t.Fatalf("keepalives = %q", keepalives)
+		}
+		mutex.Lock()
+		if settled == nil && len(keepalives) >= 2 {
+			settled = time.After(300 * time.Millisecond)
+		}
+		mutex.Unlock()
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	// 第一次无凭据 -> 401 stale, 立即带新 nonce 重发
+	if keepalives[0] != "" || !strings.Contains(keepalives[1], `nonce="n2"`) {
+		t.Errorf("keepalives = %q", keepalives)
+	}
+}
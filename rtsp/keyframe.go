@@ -0,0 +1,118 @@
+package rtsp
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pion/rtcp"
+	log "github.com/sirupsen/logrus"
+)
+
+// rtcpFormatFIR RFC 5104 FIR 的 FMT, 当前 rtcp 版本未解析, 以 RawPacket 返回
+const rtcpFormatFIR = 4
+
+// keyframeRequestInterval 同一观看者回放与向摄像机请求关键帧的最小间隔
+const keyframeRequestInterval = time.Second
+
+// RequestKeyframe 请求摄像机发送关键帧, 由读循环发出, 未配置或已有请求排队时返回 false
+func (client *Client) RequestKeyframe() bool {
+	if client.KeyframeParameter == "" {
+		return false
+	}
+	select {
+	case client.keyframeRequests <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendKeyframeRequest 发送 SET_PARAMETER, 不等待应答, 应答由读循环交给 controlReply
+func (client *Client) sendKeyframeRequest() error {
+	client.cseq++
+	if err := client.socket.SetWriteDeadline(time.Now().Add(client.rtspTimeOut * time.Second)); err != nil {
+		return err
+	}
+	body := client.KeyframeParameter + "\r\n"
+	message := "SET_PARAMETER " + client.uri + " RTSP/1.0\r\nCSeq: " + strconv.Itoa(client.cseq) + "\r\n" + client.session + client.authorization("SET_PARAMETER", "") +
+		"Content-Type: text/parameters\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\nUser-Agent: Lavf57.8.102\r\n\r\n" + body
+	if _, err := client.socket.Write([]byte(message)); err != nil {
+		return err
+	}
+	client.expectReply("SET_PARAMETER")
+	return nil
+}
+
+// readRTCP 读取浏览器对视频 track 的 RTCP 反馈, 连接关闭后退出
+func (viewer *Viewer) readRTCP(onKeyframe func(viewer *Viewer, fir bool)) {
+	for {
+		packets, err := viewer.videoSender.ReadRTCP()
+		if err != nil {
+			return
+		}
+		for _, packet := range packets {
+			switch p := packet.(type) {
+			case *rtcp.PictureLossIndication:
+				onKeyframe(viewer, false)
+			case *rtcp.RawPacket:
+				if header := p.Header(); header.Type == rtcp.TypePayloadSpecificFeedback && header.Count == rtcpFormatFIR {
+					onKeyframe(viewer, true)
+				}
+			}
+		}
+	}
+}
+
+// keyframeRequested 处理观看者的 PLI/FIR: 限频后重发缓存的关键帧, 并在支持时向摄像机请求关键帧
+func (hub *Hub) keyframeRequested(viewer *Viewer, fir bool) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if fir {
+		hub.status.FIR++
+	} else {
+		hub.status.PLI++
+	}
+	now := time.Now()
+	if now.Sub(viewer.lastKeyframe) < keyframeRequestInterval {
+		hub.status.KeyframeThrottled++
+		return
+	}
+	viewer.lastKeyframe = now
+	if _, ok := hub.viewers[viewer.ID]; !ok {
+		return
+	}
+	// 只重发缓存的关键帧; 之后的直播帧引用浏览器缺失的帧, 丢弃到下一个关键帧
+	if len(hub.gop.frames) > 0 {
+		hub.status.KeyframeReplays++
+		keyframe := *hub.gop.frames[0]
+		keyframe.Duration = gopReplayStep
+		viewer.WriteFrame(&keyframe)
+	}
+	viewer.synced = false
+	if hub.requestKeyframe(now) {
+		log.Debugf("[%s] keyframe requested from camera for viewer %s", hub.Name, viewer.ID)
+	}
+}
+
+// videoLost 视频丢包后让观看者等待下一个关键帧, 并在支持时向摄像机请求关键帧
+func (hub *Hub) videoLost() {
+	hub.resync()
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if hub.requestKeyframe(time.Now()) {
+		log.Debugf("[%s] keyframe requested from camera after packet loss", hub.Name)
+	}
+}
+
+// requestKeyframe 限频后向摄像机请求关键帧, 调用时持有 hub.mutex
+func (hub *Hub) requestKeyframe(now time.Time) bool {
+	if hub.client == nil || now.Sub(hub.lastKeyframeRequest) < keyframeRequestInterval {
+		return false
+	}
+	hub.lastKeyframeRequest = now
+	if !hub.client.RequestKeyframe() {
+		return false
+	}
+	hub.status.KeyframeRequests++
+	return true
+}
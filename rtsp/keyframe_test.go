@@ -0,0 +1,97 @@
+package rtsp
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+func TestKeyframeRequested(t *testing.T) {
+	hub := HubNew("keyframe", "rtsp://127.0.0.1/", &StunConfig{})
+	viewer := &Viewer{ID: "v1", queue: make(chan *Frame, viewerQueueSize), live: true}
+	hub.viewers[viewer.ID] = viewer
+	client := ClientNew()
+	client.KeyframeParameter = "keyframe"
+	hub.client = client
+
+	hub.gop.add(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+	hub.gop.add(&Frame{NALUs: [][]byte{{0x41}}})
+
+	hub.keyframeRequested(viewer, false)
+	hub.keyframeRequested(viewer, true) // 限频
+	status := hub.Status()
+	if status.PLI != 1 || status.FIR != 1 || status.KeyframeThrottled != 1 {
+		t.Errorf("feedback counters = %+v", status)
+	}
+	if status.KeyframeReplays != 1 || len(viewer.queue) != 1 || !(<-viewer.queue).Keyframe {
+		t.Errorf("replays = %d, queued %d", status.KeyframeReplays, len(viewer.queue))
+	}
+	// 重发关键帧之后丢弃非关键帧, 直到下一个关键帧
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}})
+	if len(viewer.queue) != 0 {
+		t.Error("delta frame sent after keyframe resend")
+	}
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+	if len(viewer.queue) != 1 {
+		t.Error("next keyframe not sent")
+	}
+	if status.KeyframeRequests != 1 || len(client.keyframeRequests) != 1 {
+		t.Errorf("camera requests = %d, pending %d", status.KeyframeRequests, len(client.keyframeRequests))
+	}
+}
+
+func TestSendKeyframeRequest(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	client := ClientNew()
+	client.socket = local
+	client.uri = "rtsp://127.0.0.1/live"
+	client.session = "Session: 1234\r\n"
+	client.KeyframeParameter = "keyframe"
+
+	go client.sendKeyframeRequest()
+	reader := textproto.NewReader(bufio.NewReader(remote))
+	line, err := reader.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "SET_PARAMETER rtsp://127.0.0.1/live RTSP/1.0" {
+		t.Errorf("request line %q", line)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Session") != "1234" || header.Get("Content-Length") != "10" {
+		t.Errorf("header = %v", header)
+	}
+	body, err := reader.ReadLine()
+	if err != nil || body != "keyframe" {
+		t.Errorf("body = %q, %v", body, err)
+	}
+}
+
+func TestVideoLost(t *testing.T) {
+	hub := HubNew("lost", "rtsp://127.0.0.1/", &StunConfig{})
+	viewer := &Viewer{ID: "v1", queue: make(chan *Frame, viewerQueueSize), live: true, synced: true}
+	hub.viewers[viewer.ID] = viewer
+	client := ClientNew()
+	client.KeyframeParameter = "keyframe"
+	hub.client = client
+	hub.gop.add(&Frame{NALUs: [][]byte{{0x65}}, Keyframe: true})
+
+	hub.videoLost()
+	hub.videoLost() // 限频
+	if viewer.synced || len(hub.gop.frames) != 0 {
+		t.Errorf("viewer synced = %v, gop %d frames", viewer.synced, len(hub.gop.frames))
+	}
+	if status := hub.Status(); status.KeyframeRequests != 1 || len(client.keyframeRequests) != 1 {
+		t.Errorf("camera requests = %d, pending %d", status.KeyframeRequests, len(client.keyframeRequests))
+	}
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41}}})
+	if len(viewer.queue) != 0 {
+		t.Error("delta frame sent after loss")
+	}
+}
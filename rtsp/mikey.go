@@ -0,0 +1,360 @@
+package rtsp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MIKEY (RFC 3830) 载荷类型, 只处理 SRTP 密钥需要的部分
+const (
+	mikeyKEMAC = 1
+	mikeyT     = 5
+	mikeyV     = 9
+	mikeySP    = 10
+	mikeyRAND  = 11
+	// mikeyKeyData KEMAC 中的密钥数据子载荷
+	mikeyKeyData = 20
+)
+
+// MIKEY 密钥数据类型
+const (
+	mikeyTGK     = 0
+	mikeyTGKSalt = 1
+	mikeyTEK     = 2
+	mikeyTEKSalt = 3
+)
+
+// SRTP 安全策略参数 (RFC 3830 6.10.1)
+const (
+	mikeySRTPEncrAlg    = 0
+	mikeySRTPEncrKeyLen = 1
+	mikeySRTPAuthAlg    = 2
+	mikeySRTPSaltKeyLen = 4
+	mikeySRTPEncryption = 7
+	mikeySRTPAuthTagLen = 11
+)
+
+// pion/srtp 只支持 AES_CM_128_HMAC_SHA1_80
+const (
+	srtpKeyLen     = 16
+	srtpSaltLen    = 14
+	srtpAuthTagLen = 10
+)
+
+// 由 TGK 派生密钥的常量 (RFC 3830 4.1.3)
+const (
+	mikeyConstTEK  = 0x2AD01C64
+	mikeyConstSalt = 0x39A2C14B
+)
+
+var errMIKEYTruncated = errors.New("mikey: message truncated")
+
+// mikeyCS 一个 crypto session, 即 SRTP-ID 映射中的一项
+type mikeyCS struct {
+	policy byte
+	ssrc   uint32
+	roc    uint32
+}
+
+// mikeyKey KEMAC 中的一个密钥
+type mikeyKey struct {
+	kind byte
+	key  []byte
+	salt []byte
+}
+
+// mikeyMessage 解析后的 MIKEY 消息
+type mikeyMessage struct {
+	csbID    uint32
+	sessions []mikeyCS
+	rand     []byte
+	policies map[byte]map[byte]int
+	keys     []mikeyKey
+}
+
+// parseMIKEY 解析 MIKEY 消息, 只支持明文 (NULL 加密) 的 KEMAC
+func parseMIKEY(data []byte) (*mikeyMessage, error) {
+	if len(data) < 10 {
+		return nil, errMIKEYTruncated
+	}
+	if data[0] != 1 {
+		return nil, fmt.Errorf("mikey: unsupported version %d", data[0])
+	}
+	msg := &mikeyMessage{csbID: binary.BigEndian.Uint32(data[4:]), policies: make(map[byte]map[byte]int)}
+	next := data[2]
+	count := int(data[8])
+	if count > 0 && data[9] != 0 {
+		return nil, fmt.Errorf("mikey: unsupported cs id map type %d", data[9])
+	}
+	pos := 10
+	if len(data) < pos+9*count {
+		return nil, errMIKEYTruncated
+	}
+	for i := 0; i < count; i++ {
+		msg.sessions = append(msg.sessions, mikeyCS{
+			policy: data[pos],
+			ssrc:   binary.BigEndian.Uint32(data[pos+1:]),
+			roc:    binary.BigEndian.Uint32(data[pos+5:]),
+		})
+		pos += 9
+	}
+	for next != 0 {
+		if len(data) < pos+2 {
+			return nil, errMIKEYTruncated
+		}
+		payload := next
+		next = data[pos]
+		var size int
+		var err error
+		switch payload {
+		case mikeyT:
+			size = 10
+			if data[pos+1] == 2 {
+				size = 6 // COUNTER
+			}
+		case mikeyRAND:
+			size = 2 + int(data[pos+1])
+			if len(data) >= pos+size {
+				msg.rand = data[pos+2 : pos+size]
+			}
+		case mikeySP:
+			size, err = msg.parsePolicy(data[pos:])
+		case mikeyKEMAC:
+			size, err = msg.parseKEMAC(data[pos:])
+		case mikeyV:
+			size = 2
+			if data[pos+1] == 1 {
+				size += 20 // HMAC-SHA-1-160
+			}
+		default:
+			return nil, fmt.Errorf("mikey: unsupported payload %d", payload)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < pos+size {
+			return nil, errMIKEYTruncated
+		}
+		pos += size
+	}
+	return msg, nil
+}
+
+// parsePolicy 解析 SP 载荷, 返回载荷长度
+func (msg *mikeyMessage) parsePolicy(data []byte) (int, error) {
+	if len(data) < 5 {
+		return 0, errMIKEYTruncated
+	}
+	size := 5 + int(binary.BigEndian.Uint16(data[3:]))
+	if len(data) < size {
+		return 0, errMIKEYTruncated
+	}
+	if data[2] != 0 {
+		// 不是 SRTP 策略
+		return size, nil
+	}
+	params := make(map[byte]int)
+	for pos := 5; pos < size; {
+		if size < pos+2 || size < pos+2+int(data[pos+1]) {
+			return 0, errMIKEYTruncated
+		}
+		value := 0
+		for _, b := range data[pos+2 : pos+2+int(data[pos+1])] {
+			value = value<<8 | int(b)
+		}
+		params[data[pos]] = value
+		pos += 2 + int(data[pos+1])
+	}
+	msg.policies[data[1]] = params
+	return size, nil
+}
+
+// parseKEMAC 解析 KEMAC 载荷及其中的密钥数据, 返回载荷长度
+func (msg *mikeyMessage) parseKEMAC(data []byte) (int, error) {
+	if len(data) < 4 {
+		return 0, errMIKEYTruncated
+	}
+	if data[1] != 0 {
+		return 0, errors.New("mikey: encrypted kemac is not supported")
+	}
+	end := 4 + int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < end+1 {
+		return 0, errMIKEYTruncated
+	}
+	size := end + 1
+	if data[end] == 1 {
+		size += 20 // HMAC-SHA-1-160
+	}
+	next := byte(mikeyKeyData)
+	for pos := 4; next != 0; {
+		if end < pos+4 {
+			return 0, errMIKEYTruncated
+		}
+		next = data[pos]
+		key := mikeyKey{kind: data[pos+1] >> 4}
+		kv := data[pos+1] & 0x0f
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		pos += 4
+		if end < pos+length {
+			return 0, errMIKEYTruncated
+		}
+		key.key = data[pos : pos+length]
+		pos += length
+		if key.kind == mikeyTGKSalt || key.kind == mikeyTEKSalt {
+			if end < pos+2 {
+				return 0, errMIKEYTruncated
+			}
+			length = int(binary.BigEndian.Uint16(data[pos:]))
+			pos += 2
+			if end < pos+length {
+				return 0, errMIKEYTruncated
+			}
+			key.salt = data[pos : pos+length]
+			pos += length
+		}
+		// KV 数据: 1 为 SPI/MKI, 一个字段; 2 为有效期区间, 两个字段
+		fields := 0
+		if kv == 1 || kv == 2 {
+			fields = int(kv)
+		}
+		for i := 0; i < fields; i++ {
+			if end < pos+1 || end < pos+1+int(data[pos]) {
+				return 0, errMIKEYTruncated
+			}
+			pos += 1 + int(data[pos])
+		}
+		msg.keys = append(msg.keys, key)
+	}
+	return size, nil
+}
+
+// srtpKey 第 cs 个 crypto session 的 SRTP 主密钥与 salt, 校验安全策略是 AES_CM_128_HMAC_SHA1_80
+func (msg *mikeyMessage) srtpKey(cs int) (key, salt []byte, err error) {
+	if len(msg.keys) == 0 {
+		return nil, nil, errors.New("mikey: no key data")
+	}
+	policy := byte(0)
+	if cs < len(msg.sessions) {
+		policy = msg.sessions[cs].policy
+	}
+	for param, want := range map[byte]int{
+		mikeySRTPEncrAlg:    1, // AES-CM
+		mikeySRTPEncrKeyLen: srtpKeyLen,
+		mikeySRTPAuthAlg:    1, // HMAC-SHA-1
+		mikeySRTPSaltKeyLen: srtpSaltLen,
+		mikeySRTPEncryption: 1,
+		mikeySRTPAuthTagLen: srtpAuthTagLen,
+	} {
+		if value, ok := msg.policies[policy][param]; ok && value != want {
+			return nil, nil, fmt.Errorf("mikey: unsupported srtp policy parameter %d = %d", param, value)
+		}
+	}
+	k := msg.keys[0]
+	switch k.kind {
+	case mikeyTEK, mikeyTEKSalt:
+		key, salt = k.key, k.salt
+		if salt == nil && len(key) == srtpKeyLen+srtpSaltLen {
+			key, salt = key[:srtpKeyLen], key[srtpKeyLen:]
+		}
+	case mikeyTGK, mikeyTGKSalt:
+		key = mikeyPRF(k.key, msg.label(mikeyConstTEK, cs), srtpKeyLen)
+		salt = k.salt
+		if salt == nil {
+			salt = mikeyPRF(k.key, msg.label(mikeyConstSalt, cs), srtpSaltLen)
+		}
+	default:
+		return nil, nil, fmt.Errorf("mikey: unsupported key type %d", k.kind)
+	}
+	if len(key) != srtpKeyLen || len(salt) != srtpSaltLen {
+		return nil, nil, fmt.Errorf("mikey: unsupported key length %d/%d", len(key), len(salt))
+	}
+	return key, salt, nil
+}
+
+// label 派生密钥的 label: constant || cs_id || csb_id || RAND, cs_id 从 1 开始
+func (msg *mikeyMessage) label(constant uint32, cs int) []byte {
+	label := make([]byte, 9, 9+len(msg.rand))
+	binary.BigEndian.PutUint32(label, constant)
+	label[4] = byte(cs + 1)
+	binary.BigEndian.PutUint32(label[5:], msg.csbID)
+	return append(label, msg.rand...)
+}
+
+// mikeyPRF RFC 3830 4.1.2, inkey 按 256 位分块, 各块的 P-SHA1 结果异或
+func mikeyPRF(inkey, label []byte, length int) []byte {
+	out := make([]byte, length)
+	for start := 0; start < len(inkey); start += 32 {
+		end := start + 32
+		if end > len(inkey) {
+			end = len(inkey)
+		}
+		for i, b := range pSHA1(inkey[start:end], label, length) {
+			out[i] ^= b
+		}
+	}
+	return out
+}
+
+// pSHA1 P(s, label, m) = HMAC(s, A_1 || label) || HMAC(s, A_2 || label) || ..., A_i = HMAC(s, A_i-1), A_0 = label
+func pSHA1(secret, label []byte, length int) []byte {
+	var out []byte
+	a := label
+	for len(out) < length {
+		mac := hmac.New(sha1.New, secret)
+		mac.Write(a)
+		a = mac.Sum(nil)
+		mac = hmac.New(sha1.New, secret)
+		mac.Write(a)
+		mac.Write(label)
+		out = mac.Sum(out)
+	}
+	return out[:length]
+}
+
+// newMIKEY 生成本端的 MIKEY 消息: 预共享密钥模式, 明文 KEMAC 中带 TEK 与 salt
+func newMIKEY(ssrc uint32, key, salt []byte) []byte {
+	msg := []byte{1, 0, mikeyT, 0, 0, 0, 0, 0, 1, 0}
+	rand.Read(msg[4:8])
+	msg = append(msg, 0)
+	msg = appendUint32(msg, ssrc)
+	msg = appendUint32(msg, 0)
+
+	// T: NTP-UTC
+	msg = append(msg, mikeyRAND, 0)
+	now := time.Now()
+	msg = appendUint32(msg, uint32(now.Unix()+2208988800))
+	msg = appendUint32(msg, uint32(uint64(now.Nanosecond())<<32/1e9))
+
+	msg = append(msg, mikeySP, 16)
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	msg = append(msg, nonce...)
+
+	params := []byte{
+		mikeySRTPEncrAlg, 1, 1,
+		mikeySRTPEncrKeyLen, 1, srtpKeyLen,
+		mikeySRTPAuthAlg, 1, 1,
+		mikeySRTPSaltKeyLen, 1, srtpSaltLen,
+		mikeySRTPEncryption, 1, 1,
+		mikeySRTPAuthTagLen, 1, srtpAuthTagLen,
+	}
+	msg = append(msg, mikeyKEMAC, 0, 0, byte(len(params)>>8), byte(len(params)))
+	msg = append(msg, params...)
+
+	keyData := []byte{0, mikeyTEKSalt << 4, 0, byte(len(key))}
+	keyData = append(keyData, key...)
+	keyData = append(keyData, 0, byte(len(salt)))
+	keyData = append(keyData, salt...)
+	msg = append(msg, 0, 0, byte(len(keyData)>>8), byte(len(keyData)))
+	msg = append(msg, keyData...)
+	return append(msg, 0) // MAC: NULL
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	return append(data, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
@@ -0,0 +1,76 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMIKEYRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x11}, srtpKeyLen)
+	salt := bytes.Repeat([]byte{0x22}, srtpSaltLen)
+	msg, err := parseMIKEY(newMIKEY(0xCAFEBABE, key, salt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.sessions) != 1 || msg.sessions[0].ssrc != 0xCAFEBABE || len(msg.rand) != 16 {
+		t.Errorf("message = %+v", msg)
+	}
+	gotKey, gotSalt, err := msg.srtpKey(0)
+	if err != nil || !bytes.Equal(gotKey, key) || !bytes.Equal(gotSalt, salt) {
+		t.Errorf("srtp key = %x %x, %v", gotKey, gotSalt, err)
+	}
+
+	// 80 位以外的认证标签不支持
+	msg.policies[0][mikeySRTPAuthTagLen] = 4
+	if _, _, err := msg.srtpKey(0); err == nil {
+		t.Error("32 bit auth tag accepted")
+	}
+}
+
+func TestMIKEYTGK(t *testing.T) {
+	// HDR, RAND, 明文 KEMAC 中一个 20 字节 TGK, 两个 crypto session
+	data := []byte{1, 0, mikeyRAND, 0, 0, 0, 0, 7, 2, 0,
+		0, 0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 0, 0, 2, 0, 0, 0, 0,
+		mikeyKEMAC, 4, 1, 2, 3, 4,
+		0, 0, 0, 24, 0, mikeyTGK << 4, 0, 20}
+	data = append(data, bytes.Repeat([]byte{0x5A}, 20)...)
+	data = append(data, 0)
+	msg, err := parseMIKEY(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key0, salt0, err := msg.srtpKey(0)
+	if err != nil || len(key0) != srtpKeyLen || len(salt0) != srtpSaltLen {
+		t.Fatalf("derived %x %x, %v", key0, salt0, err)
+	}
+	key1, _, _ := msg.srtpKey(1)
+	if bytes.Equal(key0, key1) || bytes.Equal(key0, msg.keys[0].key[:srtpKeyLen]) {
+		t.Error("tek not derived per crypto session")
+	}
+	if want := mikeyPRF(msg.keys[0].key, msg.label(mikeyConstTEK, 0), srtpKeyLen); !bytes.Equal(key0, want) {
+		t.Errorf("key = %x, want %x", key0, want)
+	}
+
+	for i := 1; i < len(data); i++ {
+		if _, err := parseMIKEY(data[:i]); err == nil {
+			t.Errorf("truncated to %d bytes parsed", i)
+		}
+	}
+}
+
+func TestMIKEYPRF(t *testing.T) {
+	label := []byte("label")
+	short := bytes.Repeat([]byte{1}, 32)
+	if out := mikeyPRF(short, label, 30); !bytes.Equal(out, pSHA1(short, label, 30)) {
+		t.Error("single block inkey should equal P-SHA1")
+	}
+	long := append(bytes.Repeat([]byte{1}, 32), 2, 3)
+	want := pSHA1(short, label, 30)
+	for i, b := range pSHA1([]byte{2, 3}, label, 30) {
+		want[i] ^= b
+	}
+	if out := mikeyPRF(long, label, 30); !bytes.Equal(out, want) {
+		t.Errorf("prf = %x, want %x", out, want)
+	}
+}
@@ -0,0 +1,66 @@
+package rtsp
+
+import (
+	"errors"
+	"net"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TransportMulticast RTP/AVP multicast
+const TransportMulticast = "multicast"
+
+// setupMulticast 发送 multicast SETUP, 按回复的 destination/port 加入组播
+func (client *Client) setupMulticast(track, profile, keyMgmt string) error {
+	if err := client.Write("SETUP", "/"+track, "Transport: "+profile+";multicast\r\n"+keyMgmt, false, false); err != nil {
+		return err
+	}
+	th := client.transport
+	if !th.multicast {
+		return errors.New("server refused multicast transport for " + track)
+	}
+	group := net.ParseIP(th.destination)
+	if group == nil || !group.IsMulticast() {
+		return errors.New("invalid multicast destination " + strconv.Quote(th.destination))
+	}
+	if th.ports[0] == 0 {
+		return errors.New("multicast port missing for " + track)
+	}
+	pair, err := joinMulticastPair(group, th.ports, client.MulticastInterface)
+	if err != nil {
+		return err
+	}
+	// 组播发送者不一定是 RTSP 服务器, 只在 Transport 带 source= 时过滤
+	pair.source = net.ParseIP(th.source)
+	if client.Debug {
+		log.Println("multicast", client.uri, group, th.ports, "ttl", th.ttl)
+	}
+	client.udp = append(client.udp, pair)
+	return nil
+}
+
+// joinMulticastPair 在指定网卡上加入组播组的 RTP/RTCP 端口, ifname 为空时由系统选择
+func joinMulticastPair(group net.IP, ports [2]int, ifname string) (*udpPair, error) {
+	var ifi *net.Interface
+	if ifname != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(ifname); err != nil {
+			return nil, err
+		}
+	}
+	network := "udp4"
+	if group.To4() == nil {
+		network = "udp6"
+	}
+	rtp, err := net.ListenMulticastUDP(network, ifi, &net.UDPAddr{IP: group, Port: ports[0]})
+	if err != nil {
+		return nil, err
+	}
+	rtcp, err := net.ListenMulticastUDP(network, ifi, &net.UDPAddr{IP: group, Port: ports[1]})
+	if err != nil {
+		rtp.Close()
+		return nil, err
+	}
+	return &udpPair{rtp: rtp, rtcp: rtcp}, nil
+}
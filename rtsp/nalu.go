@@ -0,0 +1,55 @@
+package rtsp
+
+// nalDepacketizer 将 RTP 负载还原为完整的 NAL 单元, 分片未结束时返回空
+type nalDepacketizer interface {
+	Unpack(payload []byte) [][]byte
+	// Reset 丢弃未完成的分片, 在丢包后调用
+	Reset()
+}
+
+// annexBStartCode NAL 起始码
+var annexBStartCode = []byte{0, 0, 1}
+
+// annexB 用起始码拼接 NAL 单元
+func annexB(nalus ...[]byte) []byte {
+	size := 0
+	for _, nalu := range nalus {
+		size += len(annexBStartCode) + len(nalu)
+	}
+	out := make([]byte, 0, size)
+	for _, nalu := range nalus {
+		out = append(out, annexBStartCode...)
+		out = append(out, nalu...)
+	}
+	return out
+}
+
+// splitAnnexB 按 00 00 01 / 00 00 00 01 起始码拆分, 没有起始码时整体作为一个 NAL
+func splitAnnexB(data []byte) (nalus [][]byte) {
+	start := -1
+	zeros := 0
+	for i, b := range data {
+		if b == 0 {
+			zeros++
+			continue
+		}
+		if b == 1 && zeros >= 2 {
+			if start >= 0 {
+				end := i - zeros
+				if end > start {
+					nalus = append(nalus, data[start:end])
+				}
+			}
+			start = i + 1
+		}
+		zeros = 0
+	}
+	if start < 0 {
+		if len(data) > 0 {
+			nalus = append(nalus, data)
+		}
+	} else if start < len(data) {
+		nalus = append(nalus, data[start:])
+	}
+	return
+}
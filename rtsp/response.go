@@ -0,0 +1,101 @@
+package rtsp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// maxResponseBody 应答 body 上限, 防止错误的 Content-Length 耗尽内存
+const maxResponseBody = 1 << 20
+
+// Response RTSP 应答
+type Response struct {
+	Proto      string // RTSP/1.0
+	StatusCode int
+	Status     string // 状态描述, 如 OK
+	// Header 键已规范化, 用 Get 按名称不区分大小写读取; 同名头 (如多个 WWW-Authenticate) 保留全部值
+	Header textproto.MIMEHeader
+	Body   []byte
+}
+
+// CSeq 应答的序号, 没有时返回 -1
+func (resp *Response) CSeq() int {
+	cseq, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("CSeq")))
+	if err != nil {
+		return -1
+	}
+	return cseq
+}
+
+// readResponse 读取一条 RTSP 应答; 之前到达的 interleaved 帧 ($ 通道 长度 数据) 交给 onFrame, 为 nil 时丢弃
+func readResponse(reader *bufio.Reader, onFrame func(frame []byte)) (*Response, error) {
+	for {
+		first, err := reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if first[0] == '$' {
+			frame, err := readInterleaved(reader)
+			if err != nil {
+				return nil, err
+			}
+			if onFrame != nil {
+				onFrame(frame)
+			}
+			continue
+		}
+		break
+	}
+	text := textproto.NewReader(reader)
+	line, err := text.ReadLine()
+	for err == nil && line == "" {
+		line, err = text.ReadLine()
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "RTSP/") {
+		return nil, errors.New("malformed rtsp status line: " + line)
+	}
+	resp := &Response{Proto: fields[0]}
+	if resp.StatusCode, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, errors.New("malformed rtsp status code: " + line)
+	}
+	if len(fields) == 3 {
+		resp.Status = fields[2]
+	}
+	if resp.Header, err = text.ReadMIMEHeader(); err != nil {
+		return nil, err
+	}
+	if value := resp.Header.Get("Content-Length"); value != "" {
+		length, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || length < 0 || length > maxResponseBody {
+			return nil, errors.New("invalid rtsp content-length: " + value)
+		}
+		resp.Body = make([]byte, length)
+		if _, err := io.ReadFull(reader, resp.Body); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+// readInterleaved 读取一个 interleaved 帧, 返回包括 4 字节头的数据
+func readInterleaved(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int(header[2])<<8 | int(header[3])
+	frame := make([]byte, 4+length)
+	copy(frame, header)
+	if _, err := io.ReadFull(reader, frame[4:]); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
@@ -0,0 +1,71 @@
+package rtsp
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadResponse(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	body := strings.Repeat("a=x-padding:0123456789\r\n", 400)
+	message := "$\x00\x00\x0312\x03" + // 应答之前的 interleaved 帧
+		"\r\nRTSP/1.0 401 Unauthorized\r\ncseq: 3\r\n" +
+		"WWW-Authenticate: Digest realm=\"cam\", nonce=\"n1\"\r\nwww-authenticate: Basic realm=\"cam\"\r\n" +
+		"content-length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body +
+		"RTSP/1.0 200 OK\r\nCSeq: 4\r\n\r\n"
+	go func() {
+		// 分成小段写入, 模拟跨 TCP 分段到达
+		for i := 0; i < len(message); i += 1000 {
+			end := i + 1000
+			if end > len(message) {
+				end = len(message)
+			}
+			remote.Write([]byte(message[i:end]))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	reader := bufio.NewReader(local)
+	var frames [][]byte
+	resp, err := readResponse(reader, func(frame []byte) { frames = append(frames, frame) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || string(frames[0]) != "$\x00\x00\x0312\x03" {
+		t.Errorf("interleaved frames = %q", frames)
+	}
+	if resp.StatusCode != 401 || resp.Status != "Unauthorized" || resp.CSeq() != 3 {
+		t.Errorf("status = %d %q, cseq %d", resp.StatusCode, resp.Status, resp.CSeq())
+	}
+	if challenges := resp.Header["Www-Authenticate"]; len(challenges) != 2 {
+		t.Errorf("challenges = %q", challenges)
+	}
+	if string(resp.Body) != body {
+		t.Errorf("body length = %d, want %d", len(resp.Body), len(body))
+	}
+
+	resp, err = readResponse(reader, nil)
+	if err != nil || resp.StatusCode != 200 || resp.CSeq() != 4 || len(resp.Body) != 0 {
+		t.Errorf("second response = %+v, %v", resp, err)
+	}
+}
+
+func TestReadResponseMalformed(t *testing.T) {
+	for _, message := range []string{
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"RTSP/1.0 abc OK\r\n\r\n",
+		"RTSP/1.0 200 OK\r\nContent-Length: -1\r\n\r\n",
+		"RTSP/1.0 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+	} {
+		if _, err := readResponse(bufio.NewReader(strings.NewReader(message)), nil); err == nil {
+			t.Errorf("%q parsed without error", message)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package rtsp
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	log "github.com/sirupsen/logrus"
+)
+
+// rtcpInterval 接收报告发送间隔 (RFC 3550 6.2 建议的最小值)
+const rtcpInterval = 5 * time.Second
+
+// ntpEpochOffset NTP 纪元 (1900) 与 Unix 纪元之间的秒数
+const ntpEpochOffset = 2208988800
+
+// RTPStats 一路 RTP 接收统计, 由 Client.Stats 返回
+type RTPStats struct {
+	Channel    int       `json:"channel"`
+	SSRC       uint32    `json:"ssrc"`
+	CNAME      string    `json:"cname,omitempty"`
+	Received   uint32    `json:"received"`
+	Lost       int64     `json:"lost"`
+	Jitter     float64   `json:"jitter"` // 单位为 RTP 时钟
+	LastSR     time.Time `json:"last_sr,omitempty"`
+	Bye        bool      `json:"bye,omitempty"`
+	ReportsOut int       `json:"reports_out"`
+}
+
+// rtpReceiver 单路 RTP 的接收状态 (RFC 3550 A.1, A.3, A.8)
+type rtpReceiver struct {
+	clockRate     int
+	ssrc          uint32
+	cname         string
+	initialized   bool
+	baseSeq       uint16
+	maxSeq        uint16
+	cycles        uint32
+	received      uint32
+	expectedPrior uint32
+	receivedPrior uint32
+	start         time.Time // 第一个包的到达时间, 到达时间从此换算为 RTP 时钟
+	transit       float64
+	jitter        float64
+	// 最近的 SR: NTP 时间与对应的 RTP 时间戳
+	srNTP      uint64
+	srRTP      uint32
+	srAt       time.Time
+	bye        bool
+	reportsOut int
+}
+
+// update 收到一个 RTP 包
+func (r *rtpReceiver) update(ssrc uint32, seq uint16, ts uint32, arrival time.Time) {
+	if !r.initialized || ssrc != r.ssrc {
+		*r = rtpReceiver{clockRate: r.clockRate, cname: r.cname, srNTP: r.srNTP, srRTP: r.srRTP, srAt: r.srAt, reportsOut: r.reportsOut}
+		r.initialized = true
+		r.ssrc = ssrc
+		r.baseSeq = seq
+		r.maxSeq = seq
+		r.start = arrival
+	} else if delta := seq - r.maxSeq; delta < 0x8000 {
+		if seq < r.maxSeq {
+			r.cycles += 1 << 16
+		}
+		r.maxSeq = seq
+	}
+	r.received++
+
+	if r.clockRate > 0 {
+		transit := arrival.Sub(r.start).Seconds()*float64(r.clockRate) - float64(ts)
+		if r.received > 1 {
+			d := math.Abs(transit - r.transit)
+			// RTP 时间戳回绕时跳过
+			if d < float64(1<<31) {
+				r.jitter += (d - r.jitter) / 16
+			}
+		}
+		r.transit = transit
+	}
+}
+
+// expected 应收到的包数
+func (r *rtpReceiver) expected() uint32 {
+	return r.cycles + uint32(r.maxSeq) - uint32(r.baseSeq) + 1
+}
+
+// lost 累计丢包数, 重复包可能使其为负
+func (r *rtpReceiver) lost() int64 {
+	return int64(r.expected()) - int64(r.received)
+}
+
+// senderReport 收到 SR
+func (r *rtpReceiver) senderReport(sr *rtcp.SenderReport, arrival time.Time) {
+	r.srNTP = sr.NTPTime
+	r.srRTP = sr.RTPTime
+	r.srAt = arrival
+}
+
+// report 生成接收报告块, 并开始新的统计区间
+func (r *rtpReceiver) report(now time.Time) rtcp.ReceptionReport {
+	expected := r.expected()
+	expectedInterval := expected - r.expectedPrior
+	receivedInterval := r.received - r.receivedPrior
+	r.expectedPrior = expected
+	r.receivedPrior = r.received
+	fraction := uint8(0)
+	if lostInterval := int64(expectedInterval) - int64(receivedInterval); expectedInterval > 0 && lostInterval > 0 {
+		fraction = uint8(lostInterval << 8 / int64(expectedInterval))
+	}
+	lost := r.lost()
+	if lost < 0 {
+		lost = 0
+	} else if lost > 0x7FFFFF {
+		lost = 0x7FFFFF
+	}
+	report := rtcp.ReceptionReport{
+		SSRC:               r.ssrc,
+		FractionLost:       fraction,
+		TotalLost:          uint32(lost),
+		LastSequenceNumber: r.cycles + uint32(r.maxSeq),
+		Jitter:             uint32(r.jitter),
+	}
+	if !r.srAt.IsZero() {
+		report.LastSenderReport = uint32(r.srNTP >> 16)
+		report.Delay = uint32(now.Sub(r.srAt).Seconds() * 65536)
+	}
+	r.reportsOut++
+	return report
+}
+
+// wallClock 按最近的 SR 将 RTP 时间戳换算为发送端的墙上时间
+func (r *rtpReceiver) wallClock(ts uint32) (time.Time, bool) {
+	if r.srAt.IsZero() || r.clockRate <= 0 {
+		return time.Time{}, false
+	}
+	secs := int64(r.srNTP>>32) - ntpEpochOffset
+	frac := int64(r.srNTP & 0xFFFFFFFF)
+	base := time.Unix(secs, frac*int64(time.Second)>>32)
+	// RTP 时间戳回绕, 按有符号差值计算
+	delta := int64(int32(ts - r.srRTP))
+	return base.Add(time.Duration(delta * int64(time.Second) / int64(r.clockRate))), true
+}
+
+// rtcpState 客户端所有媒体的 RTCP 状态, 按 RTP interleaved 通道索引
+type rtcpState struct {
+	mutex     sync.Mutex
+	ssrc      uint32 // 本端 SSRC
+	cname     string
+	receivers map[byte]*rtpReceiver
+}
+
+func newRTCPState() *rtcpState {
+	return &rtcpState{
+		ssrc:      rand.Uint32(),
+		cname:     "RTSPtoWebRTC",
+		receivers: make(map[byte]*rtpReceiver),
+	}
+}
+
+// receiver 返回通道的接收状态, 不存在时按 SDP 的时钟频率创建
+func (client *Client) receiver(channel byte) *rtpReceiver {
+	r := client.rtcpState.receivers[channel]
+	if r == nil {
+		r = &rtpReceiver{}
+		if index := int(channel / 2); index < len(client.infos) {
+			r.clockRate = client.infos[index].TimeScale
+		}
+		client.rtcpState.receivers[channel] = r
+	}
+	return r
+}
+
+// observe 统计收到的 RTP 包, 解析 RTCP 中的 SR/SDES/BYE; 偶数通道为 RTP, 奇数为 RTCP
+func (client *Client) observe(channel byte, packet []byte, arrival time.Time) {
+	client.rtcpState.mutex.Lock()
+	defer client.rtcpState.mutex.Unlock()
+	if channel%2 == 0 {
+		if len(packet) < 12 {
+			return
+		}
+		seq := uint16(packet[2])<<8 | uint16(packet[3])
+		ts := uint32(packet[4])<<24 | uint32(packet[5])<<16 | uint32(packet[6])<<8 | uint32(packet[7])
+		ssrc := uint32(packet[8])<<24 | uint32(packet[9])<<16 | uint32(packet[10])<<8 | uint32(packet[11])
+		client.receiver(channel).update(ssrc, seq, ts, arrival)
+		return
+	}
+	packets, err := rtcp.Unmarshal(packet)
+	if err != nil {
+		if client.Debug {
+			log.Println("rtcp unmarshal", err)
+		}
+		return
+	}
+	r := client.receiver(channel - 1)
+	for _, p := range packets {
+		switch p := p.(type) {
+		case *rtcp.SenderReport:
+			r.senderReport(p, arrival)
+		case *rtcp.SourceDescription:
+			for _, chunk := range p.Chunks {
+				for _, item := range chunk.Items {
+					if item.Type == rtcp.SDESCNAME {
+						r.cname = item.Text
+					}
+				}
+			}
+		case *rtcp.Goodbye:
+			r.bye = true
+			log.Infof("[%s] rtcp bye on channel %d: %s", client.Name, channel, p.Reason)
+		}
+	}
+}
+
+// receiverReports 为每路已收到数据的媒体生成 RR+SDES 复合包, 按 RTCP 通道索引
+func (client *Client) receiverReports(now time.Time) map[byte][]byte {
+	client.rtcpState.mutex.Lock()
+	defer client.rtcpState.mutex.Unlock()
+	reports := make(map[byte][]byte)
+	for channel, r := range client.rtcpState.receivers {
+		if !r.initialized {
+			continue
+		}
+		data, err := rtcp.Marshal([]rtcp.Packet{
+			&rtcp.ReceiverReport{SSRC: client.rtcpState.ssrc, Reports: []rtcp.ReceptionReport{r.report(now)}},
+			&rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{
+				Source: client.rtcpState.ssrc,
+				Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: client.rtcpState.cname}},
+			}}},
+		})
+		if err == nil {
+			data, err = client.protectRTCP(channel+1, data)
+		}
+		if err != nil {
+			log.Debugf("[%s] rtcp marshal: %v", client.Name, err)
+			continue
+		}
+		reports[channel+1] = data
+	}
+	return reports
+}
+
+// sendReceiverReportsTCP 通过 interleaved 通道发送接收报告, 只在读循环中调用
+func (client *Client) sendReceiverReportsTCP(now time.Time) error {
+	for channel, data := range client.receiverReports(now) {
+		frame := append([]byte{36, channel, byte(len(data) >> 8), byte(len(data))}, data...)
+		if err := client.socket.SetWriteDeadline(now.Add(client.rtspTimeOut * time.Second)); err != nil {
+			return err
+		}
+		if _, err := client.socket.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendReceiverReportsUDP 通过 RTCP 端口发送接收报告, 组播没有服务端地址时不发送
+func (client *Client) sendReceiverReportsUDP(now time.Time) {
+	for channel, data := range client.receiverReports(now) {
+		index := int(channel / 2)
+		if index >= len(client.udp) || client.udp[index].serverRTCP == nil {
+			continue
+		}
+		pair := client.udp[index]
+		if _, err := pair.rtcp.WriteToUDP(data, pair.serverRTCP); err != nil {
+			log.Debugf("[%s] send rtcp rr: %v", client.Name, err)
+		}
+	}
+}
+
+// WallClock 按 RTP 通道最近的 SR 换算帧的墙上时间, 尚未收到 SR 时返回 false
+func (client *Client) WallClock(channel int, ts uint32) (time.Time, bool) {
+	client.rtcpState.mutex.Lock()
+	defer client.rtcpState.mutex.Unlock()
+	r := client.rtcpState.receivers[byte(channel)]
+	if r == nil {
+		return time.Time{}, false
+	}
+	return r.wallClock(ts)
+}
+
+// Stats 各路 RTP 的接收统计
+func (client *Client) Stats() (stats []RTPStats) {
+	client.rtcpState.mutex.Lock()
+	defer client.rtcpState.mutex.Unlock()
+	for channel, r := range client.rtcpState.receivers {
+		if !r.initialized {
+			continue
+		}
+		stats = append(stats, RTPStats{
+			Channel:    int(channel),
+			SSRC:       r.ssrc,
+			CNAME:      r.cname,
+			Received:   r.received,
+			Lost:       r.lost(),
+			Jitter:     r.jitter,
+			LastSR:     r.srAt,
+			Bye:        r.bye,
+			ReportsOut: r.reportsOut,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Channel < stats[j].Channel })
+	return
+}
@@ -0,0 +1,85 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// rtpPacket 构造只有 RTP 头的包
+func rtpPacket(seq uint16, ts uint32, ssrc uint32) []byte {
+	return []byte{0x80, 96, byte(seq >> 8), byte(seq), byte(ts >> 24), byte(ts >> 16), byte(ts >> 8), byte(ts),
+		byte(ssrc >> 24), byte(ssrc >> 16), byte(ssrc >> 8), byte(ssrc)}
+}
+
+func TestRTCPReceiverReport(t *testing.T) {
+	client := ClientNew()
+	client.infos = []sdp.Info{{AVType: "video", TimeScale: 90000}}
+	start := time.Unix(1500000000, 0)
+
+	// 序号回绕, 丢掉 0xFFFF 与 2
+	for i, seq := range []uint16{0xFFFD, 0xFFFE, 0, 1, 3} {
+		arrival := start.Add(time.Duration(i) * 40 * time.Millisecond)
+		client.observe(0, rtpPacket(seq, uint32(i)*3600, 0x1234), arrival)
+	}
+	stats := client.Stats()
+	if len(stats) != 1 || stats[0].SSRC != 0x1234 || stats[0].Received != 5 || stats[0].Lost != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if stats[0].Jitter != 0 {
+		t.Errorf("jitter with perfect pacing = %f", stats[0].Jitter)
+	}
+
+	// SR: NTP 时间对应 RTP 时间戳 0
+	ntpSecs := uint64(1500000000 + ntpEpochOffset)
+	sr, err := rtcp.Marshal([]rtcp.Packet{
+		&rtcp.SenderReport{SSRC: 0x1234, NTPTime: ntpSecs << 32, RTPTime: 0},
+		&rtcp.SourceDescription{Chunks: []rtcp.SourceDescriptionChunk{{
+			Source: 0x1234,
+			Items:  []rtcp.SourceDescriptionItem{{Type: rtcp.SDESCNAME, Text: "camera"}},
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.observe(1, sr, start.Add(time.Second))
+	wall, ok := client.WallClock(0, 90000)
+	if !ok || !wall.Equal(start.Add(time.Second)) {
+		t.Errorf("wall clock = %v, %v", wall, ok)
+	}
+	if stats := client.Stats(); stats[0].CNAME != "camera" {
+		t.Errorf("cname = %q", stats[0].CNAME)
+	}
+
+	reports := client.receiverReports(start.Add(2 * time.Second))
+	data, ok := reports[1]
+	if !ok {
+		t.Fatalf("no report on rtcp channel: %v", reports)
+	}
+	packets, err := rtcp.Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr, ok := packets[0].(*rtcp.ReceiverReport)
+	if !ok || len(rr.Reports) != 1 {
+		t.Fatalf("packets = %+v", packets)
+	}
+	report := rr.Reports[0]
+	if report.SSRC != 0x1234 || report.TotalLost != 2 || report.FractionLost != 2*256/7 || report.LastSequenceNumber != 1<<16|3 {
+		t.Errorf("report = %+v", report)
+	}
+	if report.LastSenderReport != uint32(ntpSecs<<16) || report.Delay != 65536 {
+		t.Errorf("lsr = %#x, dlsr = %d", report.LastSenderReport, report.Delay)
+	}
+	if _, ok := packets[1].(*rtcp.SourceDescription); !ok {
+		t.Errorf("rr not followed by sdes: %+v", packets[1])
+	}
+
+	bye, _ := rtcp.Marshal([]rtcp.Packet{&rtcp.Goodbye{Sources: []uint32{0x1234}}})
+	client.observe(1, bye, start.Add(3*time.Second))
+	if stats := client.Stats(); !stats[0].Bye {
+		t.Error("bye not recorded")
+	}
+}
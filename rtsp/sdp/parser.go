@@ -11,6 +11,12 @@ import (
 	"github.com/deepch/av"
 )
 
+// av 中没有的编码类型
+const (
+	H265 = iota + 0x1265
+	OPUS
+)
+
 type Info struct {
 	AVType             string
 	Type               int
@@ -19,13 +25,21 @@ type Info struct {
 	Rtpmap             int
 	Config             []byte
 	SpropParameterSets [][]byte
+	SpropVPS           []byte
+	SpropSPS           []byte
+	SpropPPS           []byte
+	SpropMaxDonDiff    int
 	PayloadType        int
 	SizeLength         int
 	IndexLength        int
+	IndexDeltaLength   int
+	Profile            string // m 行中的传输协议, 如 RTP/AVP, RTP/SAVP
+	KeyMgmt            string // a=key-mgmt:mikey 的 base64 数据, 媒体没有时继承会话级
 }
 
 func Decode(content string) (infos []Info) {
 	var info *Info
+	var sessionKeyMgmt string
 
 	for _, line := range strings.Split(content, "\n") {
 		line = strings.TrimSpace(line)
@@ -42,12 +56,23 @@ func Decode(content string) (infos []Info) {
 						info = &infos[len(infos)-1]
 						mfields := strings.Split(fields[1], " ")
 						if len(mfields) >= 3 {
+							info.Profile = mfields[1]
 							info.PayloadType, _ = strconv.Atoi(mfields[2])
 						}
 					}
 				}
 
 			case "a":
+				// RFC 4567, base64 中可能有 /, 不能按其他属性拆分
+				if strings.HasPrefix(typeval[1], "key-mgmt:mikey ") {
+					data := strings.TrimSpace(strings.TrimPrefix(typeval[1], "key-mgmt:mikey "))
+					if info != nil {
+						info.KeyMgmt = data
+					} else {
+						sessionKeyMgmt = data
+					}
+					continue
+				}
 				if info != nil {
 					for _, field := range fields {
 						keyval := strings.SplitN(field, ":", 2)
@@ -69,6 +94,14 @@ func Decode(content string) (infos []Info) {
 								info.Type = av.AAC
 							case "H264":
 								info.Type = av.H264
+							case "H265", "HEVC":
+								info.Type = H265
+							case "PCMU":
+								info.Type = av.PCM_MULAW
+							case "PCMA":
+								info.Type = av.PCM_ALAW
+							case "opus", "OPUS":
+								info.Type = OPUS
 							}
 							if i, err := strconv.Atoi(keyval[1]); err == nil {
 								info.TimeScale = i
@@ -91,6 +124,16 @@ func Decode(content string) (infos []Info) {
 										info.SizeLength, _ = strconv.Atoi(val)
 									case "indexlength":
 										info.IndexLength, _ = strconv.Atoi(val)
+									case "indexdeltalength":
+										info.IndexDeltaLength, _ = strconv.Atoi(val)
+									case "sprop-vps":
+										info.SpropVPS, _ = base64.StdEncoding.DecodeString(val)
+									case "sprop-sps":
+										info.SpropSPS, _ = base64.StdEncoding.DecodeString(val)
+									case "sprop-pps":
+										info.SpropPPS, _ = base64.StdEncoding.DecodeString(val)
+									case "sprop-max-don-diff":
+										info.SpropMaxDonDiff, _ = strconv.Atoi(val)
 									case "sprop-parameter-sets":
 										fields := strings.Split(val, ",")
 										for _, field := range fields {
@@ -107,5 +150,21 @@ func Decode(content string) (infos []Info) {
 			}
 		}
 	}
+	// 静态 payload type 可以没有 rtpmap
+	for i := range infos {
+		if infos[i].KeyMgmt == "" {
+			infos[i].KeyMgmt = sessionKeyMgmt
+		}
+		if infos[i].AVType == "audio" && infos[i].Type == 0 {
+			switch infos[i].PayloadType {
+			case 0:
+				infos[i].Type = av.PCM_MULAW
+				infos[i].TimeScale = 8000
+			case 8:
+				infos[i].Type = av.PCM_ALAW
+				infos[i].TimeScale = 8000
+			}
+		}
+	}
 	return
 }
@@ -2,6 +2,8 @@ package sdp
 
 import (
 	"testing"
+
+	"github.com/deepch/av"
 )
 
 func TestParse(t *testing.T) {
@@ -42,3 +44,85 @@ a=appversion:1.0
 `)
 	t.Logf("%v", infos)
 }
+
+func TestParseH265(t *testing.T) {
+	infos := Decode(`
+v=0
+o=- 0 0 IN IP4 127.0.0.1
+s=hevc
+t=0 0
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H265/90000
+a=fmtp:96 profile-id=1; sprop-vps=QAEMAf//AWAAAAMAkAAAAwAAAwBdlZgJ; sprop-sps=QgEBAWAAAAMAkAAAAwAAAwBdoAKAgC0WWVmkkyvAQEAAAAMAQAAABkI=; sprop-pps=RAHBcrRiQA==
+a=control:trackID=1
+`)
+	if len(infos) != 1 {
+		t.Fatalf("expected 1 media, got %d", len(infos))
+	}
+	info := infos[0]
+	if info.Type != H265 || info.TimeScale != 90000 {
+		t.Fatalf("unexpected codec %x/%d", info.Type, info.TimeScale)
+	}
+	if len(info.SpropVPS) == 0 || info.SpropVPS[0]>>1&0x3f != 32 {
+		t.Fatalf("bad vps %x", info.SpropVPS)
+	}
+	if len(info.SpropSPS) == 0 || info.SpropSPS[0]>>1&0x3f != 33 {
+		t.Fatalf("bad sps %x", info.SpropSPS)
+	}
+	if len(info.SpropPPS) == 0 || info.SpropPPS[0]>>1&0x3f != 34 {
+		t.Fatalf("bad pps %x", info.SpropPPS)
+	}
+}
+
+func TestParseAudio(t *testing.T) {
+	infos := Decode(`
+v=0
+s=audio
+m=video 0 RTP/AVP 96
+a=rtpmap:96 H264/90000
+a=control:trackID=0
+m=audio 0 RTP/AVP 0
+a=control:trackID=1
+m=audio 0 RTP/AVP 97
+a=rtpmap:97 PCMA/8000
+a=control:trackID=2
+m=audio 0 RTP/AVP 98
+a=rtpmap:98 opus/48000/2
+a=control:trackID=3
+`)
+	want := []int{av.H264, av.PCM_MULAW, av.PCM_ALAW, OPUS}
+	if len(infos) != len(want) {
+		t.Fatalf("expected %d medias, got %d", len(want), len(infos))
+	}
+	for i, info := range infos {
+		if info.Type != want[i] {
+			t.Errorf("media %d type %x, want %x", i, info.Type, want[i])
+		}
+	}
+	if infos[1].TimeScale != 8000 || infos[3].TimeScale != 48000 {
+		t.Errorf("unexpected clock rates %d %d", infos[1].TimeScale, infos[3].TimeScale)
+	}
+}
+
+func TestParseKeyMgmt(t *testing.T) {
+	infos := Decode(`
+v=0
+s=srtp
+a=key-mgmt:mikey AQAFgM0XE/8BAAAA+w==
+m=video 0 RTP/SAVP 96
+a=rtpmap:96 H264/90000
+a=key-mgmt:mikey AQAFgAAAAAEBAA/0/w==
+a=control:trackID=0
+m=audio 0 RTP/SAVP 0
+a=control:trackID=1
+`)
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 medias, got %d", len(infos))
+	}
+	if infos[0].Profile != "RTP/SAVP" || infos[0].KeyMgmt != "AQAFgAAAAAEBAA/0/w==" || infos[0].TimeScale != 90000 {
+		t.Errorf("media 0 = %+v", infos[0])
+	}
+	if infos[1].KeyMgmt != "AQAFgM0XE/8BAAAA+w==" || infos[1].Control != "trackID=1" {
+		t.Errorf("media 1 did not inherit session key-mgmt: %+v", infos[1])
+	}
+}
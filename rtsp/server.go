@@ -1,20 +1,23 @@
 package rtsp
 
 import (
-	"encoding/base64"
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"bytes"
+	"errors"
 	"math/rand"
-	"os"
+	"strings"
+	"time"
 
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v2"
-	"github.com/pion/webrtc/v2/pkg/media"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/ini.v1"
 )
 
-// 视频宽高
-var (
-	VideoWidth  int
-	VideoHeight int
+// ICE 候选策略
+const (
+	ICEPolicyRelay = "relay"
+	ICEPolicyAll   = "all"
 )
 
 // StunConfig stun 信息
@@ -22,181 +25,361 @@ type StunConfig struct {
 	URL      string
 	UserName string
 	PassWord string
+	// Policy ICE 候选策略 relay/all, 为空时 turn 地址只用中继, stun 地址不限制
+	Policy string
 }
 
-var videoTrackWebRTC *webrtc.Track
+// IsTURN 地址是否为 turn/turns 中继服务器
+func IsTURN(url string) bool {
+	return strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:")
+}
 
-//StartRTSPServer 开启 RTSP 服务
-func StartRTSPServer(rtspURL string, sdpOutFile string, remoteSdp string, stun *StunConfig) {
+// webrtcConfiguration 转换为 PeerConnection 配置, 未配置服务器时不限制候选类型
+func (stun *StunConfig) webrtcConfiguration() webrtc.Configuration {
+	config := webrtc.Configuration{}
+	if stun == nil || stun.URL == "" {
+		return config
+	}
+	config.ICEServers = []webrtc.ICEServer{
+		{
+			URLs:           []string{stun.URL},
+			Username:       stun.UserName,
+			Credential:     stun.PassWord,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		},
+	}
+	if stun.Policy == ICEPolicyRelay || stun.Policy == "" && IsTURN(stun.URL) {
+		config.ICETransportPolicy = webrtc.ICETransportPolicyRelay
+	}
+	return config
+}
 
-	localSdp := getSdp(remoteSdp, stun)
-	setSdp(sdpOutFile, localSdp)
+// 重连退避参数
+const (
+	reconnectMinDelay   = time.Second
+	reconnectMaxDelay   = 30 * time.Second
+	reconnectResetAfter = time.Minute // 会话持续超过该时间后重置退避
+)
 
-	log.Infof("rtspURL %s:\n", rtspURL)
-	work(rtspURL)
+// reconnectBackoff 重连退避, 每次失败翻倍直到 max, 会话持续超过 resetAfter 后回到 min
+type reconnectBackoff struct {
+	min, max, resetAfter time.Duration
+	current              time.Duration
 }
 
-func work(rtspURL string) {
-	sps := []byte{}
-	pps := []byte{}
-	fuBuffer := []byte{}
+// next 按本次会话持续时间返回重连前的等待 (未加抖动)
+func (backoff *reconnectBackoff) next(lasted time.Duration) time.Duration {
+	if backoff.current == 0 || lasted > backoff.resetAfter {
+		backoff.current = backoff.min
+	}
+	delay := backoff.current
+	backoff.current *= 2
+	if backoff.current > backoff.max {
+		backoff.current = backoff.max
+	}
+	return delay
+}
+
+//StartRTSPServer 开启 RTSP 服务, 注册并后台运行名为 name 的流
+func StartRTSPServer(name, rtspURL, transport string, stun *StunConfig) *Hub {
+	hub := HubNew(name, rtspURL, stun)
+	hub.SetTransport(transport)
+	RegisterHub(hub)
+
+	log.Infof("[%s] rtspURL %s", name, rtspURL)
+	hub.Start()
+	return hub
+}
+
+// run 监督 RTSP 连接, 断开后按指数退避加抖动重连, 观看者保持挂载, 直到 Stop 或按需模式空闲关闭 quit
+func (hub *Hub) run(quit chan struct{}) {
+	defer hub.runExited(quit)
+	backoff := reconnectBackoff{min: reconnectMinDelay, max: reconnectMaxDelay, resetAfter: reconnectResetAfter}
+	for {
+		hub.setState(StateConnecting)
+		started := time.Now()
+		err := hub.session(quit)
+		hub.markUnready(quit)
+		if hub.stopped() || isClosed(quit) {
+			return
+		}
+		delay := jitter(backoff.next(time.Since(started)))
+		attempt := hub.recordFailure(err)
+		log.Warnf("[%s] rtsp session ended: %v, reconnect #%d in %s", hub.Name, err, attempt, delay)
+		select {
+		case <-hub.done:
+			return
+		case <-quit:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// isClosed 通道是否已关闭
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// jitter 在 [d/2, d) 之间随机, 避免多路流同时重连
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// session 建立一次 RTSP 会话并分发数据, 会话结束时返回原因
+func (hub *Hub) session(quit chan struct{}) error {
 	count := 0
 
 	client := ClientNew()
-	client.URL = rtspURL
+	client.URL = hub.URL
 	client.Debug = false
-	client.Name = rtspURL
-
-	syncCount := 0
-	preTS := 0
-	writeNALU := func(sync bool, ts int, payload []byte) {
-		// if DataChanelTest != nil && preTS != 0 {
-		// 	DataChanelTest <- webrtc.RTCSample{Data: payload, Samples: uint32(ts - preTS)}
-		// }
-		if videoTrackWebRTC != nil && preTS != 0 {
-			// log.Debug("videoTrackWebRTC")
-			videoTrackWebRTC.WriteSample(media.Sample{Data: payload, Samples: uint32(ts - preTS)})
-		}
-		preTS = ts
-	}
-	handleNALU := func(nalType byte, payload []byte, ts int64) {
-		if nalType == 7 {
-			if len(sps) == 0 {
-				sps = payload
-			}
-			//	writeNALU(true, int(ts), payload)
-		} else if nalType == 8 {
-			if len(pps) == 0 {
-				pps = payload
-			}
-			//	writeNALU(true, int(ts), payload)
-		} else if nalType == 5 {
-			syncCount++
-			lastkeys := append([]byte("\000\000\001"+string(sps)+"\000\000\001"+string(pps)+"\000\000\001"), payload...)
+	client.Name = hub.Name
+	client.Transport = hub.transport()
+	client.MulticastInterface = hub.MulticastInterface
+	client.KeyframeParameter = hub.KeyframeParameter
+	client.TLSConfig = hub.TLSConfig
+	defer client.Close()
 
-			writeNALU(true, int(ts), lastkeys)
-		} else {
-			if syncCount > 0 {
-				writeNALU(false, int(ts), payload)
-			}
+	// 按 RTP 时间戳与 marker 位组帧, 输出给 hub
+	var assembler *frameAssembler
+
+	// 参数集缓存, 始终保存最新的一组, 关键帧内没有时由 assembler 补齐
+	sps := []byte{}
+	pps := []byte{}
+	vps := []byte{} // 仅 H.265
+	updateParameterSet := func(name string, current *[]byte, nalu []byte) bool {
+		if bytes.Equal(*current, nalu) {
+			return false
 		}
+		if len(*current) > 0 {
+			log.Infof("[%s] %s changed, new set sent with the next keyframe", hub.Name, name)
+		}
+		*current = nalu
+		return true
 	}
-
-	if err := client.Open(); err != nil {
-		log.Error("[RTSP] Error", err)
-	} else {
-		for {
-			select {
-			case <-client.Signals:
-				log.Error("Exit signals by rtsp")
-				return
-			case data := <-client.Outgoing:
-				count += len(data)
-
-				// log.Error("recive  rtp packet size", len(data), "recive all packet size", count)
-				if data[0] == 36 && data[1] == 0 {
-					cc := data[4] & 0xF
-					rtphdr := 12 + cc*4
-					ts := (int64(data[8]) << 24) + (int64(data[9]) << 16) + (int64(data[10]) << 8) + (int64(data[11]))
-					packno := (int64(data[6]) << 8) + int64(data[7])
-					if false {
-						log.Println("packet num", packno)
-					}
-					nalType := data[4+rtphdr] & 0x1F
-					if nalType >= 1 && nalType <= 23 {
-						if nalType == 6 {
-							continue
-						}
-						handleNALU(nalType, data[4+rtphdr:], ts)
-					} else if nalType == 28 {
-						isStart := data[4+rtphdr+1]&0x80 != 0
-						isEnd := data[4+rtphdr+1]&0x40 != 0
-						nalType := data[4+rtphdr+1] & 0x1F
-						nal := data[4+rtphdr]&0xE0 | data[4+rtphdr+1]&0x1F
-						if isStart {
-							fuBuffer = []byte{0}
-						}
-						fuBuffer = append(fuBuffer, data[4+rtphdr+2:]...)
-						if isEnd {
-							fuBuffer[0] = nal
-							handleNALU(nalType, fuBuffer, ts)
-						}
-					}
-				} else if data[0] == 36 && data[1] == 2 {
-					//cc := data[4] & 0xF
-					//rtphdr := 12 + cc*4
-					//payload := data[4+rtphdr+4:]
-				}
-			}
+	updateSPS := func(nalu []byte, resolution func([]byte) (int, int, error)) {
+		if !updateParameterSet("sps", &sps, nalu) {
+			return
+		}
+		width, height, err := resolution(nalu)
+		if err != nil {
+			log.Warnf("[%s] parse sps: %v", hub.Name, err)
+			return
 		}
+		hub.setResolution(width, height)
 	}
-	client.Close()
-}
-
-func getSdp(remoteSdp string, stun *StunConfig) (local string) {
-	sdp, err := base64.StdEncoding.DecodeString(remoteSdp)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	// webrtc.RegisterDefaultCodecs()
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs:           []string{stun.URL},
-				Username:       stun.UserName,
-				Credential:     stun.PassWord,
-				CredentialType: webrtc.ICECredentialTypePassword,
-			},
-		},
-		ICETransportPolicy: webrtc.ICETransportPolicyRelay,
-	})
-	if err != nil {
-		panic(err)
+	handleNALU := func(nalu []byte, ts int64) {
+		switch nalu[0] & h264NALMask {
+		case h264NALSPS:
+			updateSPS(nalu, h264SPSResolution)
+		case h264NALPPS:
+			updateParameterSet("pps", &pps, nalu)
+		}
+		assembler.push(ts, nalu, h264NALKind(nalu))
 	}
-	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
-		log.Infof("Connection State has changed %s \n", connectionState.String())
-	})
-	videoTrackWebRTC, err = peerConnection.NewTrack(webrtc.DefaultPayloadTypeH264, rand.Uint32(), "video", "pion2")
-	if err != nil {
-		log.Error(err)
-		return
+	handleH265 := func(nalu []byte, ts int64) {
+		switch h265NALType(nalu) {
+		case h265NALVPS:
+			updateParameterSet("vps", &vps, nalu)
+		case h265NALSPS:
+			updateSPS(nalu, h265SPSResolution)
+		case h265NALPPS:
+			updateParameterSet("pps", &pps, nalu)
+		}
+		assembler.push(ts, nalu, h265NALKind(nalu))
+	}
+
+	err := client.Open()
+	if client.Transport != hub.transport() {
+		// SETUP 返回 461, Open 已回退到 TCP
+		hub.SetTransport(client.Transport)
 	}
-	_, err = peerConnection.AddTrack(videoTrackWebRTC)
 	if err != nil {
-		log.Error(err)
-		return
+		return err
 	}
-	log.Debugf("offer sdp\n%+v", string(sdp))
 
-	offer := webrtc.SessionDescription{
-		Type: webrtc.SDPTypeOffer,
-		SDP:  string(sdp),
+	videoChannel := -1
+	audioChannel := -1
+	codecs := streamCodecs{}
+	var transcoder *audioTranscoder
+	var depacketizer nalDepacketizer
+	var handle func(nalu []byte, ts int64)
+	for i, info := range client.Infos() {
+		if info.AVType == "audio" && audioChannel < 0 && audioCodecName(info.Type) != "" && hub.codecEnabled(audioCodecName(info.Type)) {
+			audioChannel = 2 * i
+			codecs.audio = info.Type
+		}
+		if info.AVType == "audio" && audioChannel < 0 && info.Type == av.AAC && hub.AudioTranscode != TranscodeOff && hub.codecEnabled(codecAAC) {
+			t, err := newAudioTranscoder(info, hub.AudioTranscode)
+			if err != nil {
+				log.Warnf("[%s] aac transcode disabled: %v", hub.Name, err)
+			} else {
+				transcoder = t
+				audioChannel = 2 * i
+				codecs.audio = t.output
+				go t.run(hub)
+				defer t.close()
+			}
+		}
+		if info.AVType != "video" || videoChannel >= 0 {
+			continue
+		}
+		if !hub.codecEnabled(videoCodecName(info.Type)) {
+			return errors.New(videoCodecName(info.Type) + " is not enabled for this stream")
+		}
+		videoChannel = 2 * i
+		codecs.video = info.Type
+		assembler = newFrameAssembler(info.Type, info.TimeScale, hub.sessionTimer(info.TimeScale), hub)
+		assembler.wallClock = func(ts uint32) (time.Time, bool) {
+			return client.WallClock(videoChannel, ts)
+		}
+		assembler.onDiscontinuity = func(ts uint32) {
+			log.Warnf("[%s] video timestamp discontinuity at %d, rebased", hub.Name, ts)
+			hub.addTimestampJump()
+		}
+		switch info.Type {
+		case sdp.H265:
+			depacketizer = &h265Depacketizer{donl: info.SpropMaxDonDiff > 0}
+			if len(info.SpropVPS) > 0 {
+				vps = info.SpropVPS
+			}
+			if len(info.SpropSPS) > 0 {
+				updateSPS(info.SpropSPS, h265SPSResolution)
+			}
+			if len(info.SpropPPS) > 0 {
+				pps = info.SpropPPS
+			}
+			assembler.parameterSets = func() [][]byte { return [][]byte{vps, sps, pps} }
+			handle = handleH265
+		default:
+			depacketizer = &h264Depacketizer{}
+			for _, set := range info.SpropParameterSets {
+				if len(set) == 0 {
+					continue
+				}
+				switch set[0] & h264NALMask {
+				case h264NALSPS:
+					updateSPS(set, h264SPSResolution)
+				case h264NALPPS:
+					pps = set
+				}
+			}
+			assembler.parameterSets = func() [][]byte { return [][]byte{sps, pps} }
+			handle = handleNALU
+		}
 	}
-	if err := peerConnection.SetRemoteDescription(offer); err != nil {
-		log.Error(err)
-		return
+	if videoChannel < 0 {
+		return errors.New("no video media in sdp")
 	}
-	answer, err := peerConnection.CreateAnswer(nil)
+	hub.setCodecs(codecs)
 
-	log.Debugf("answer sdp\n%+v", answer.SDP)
+	hub.setState(StatePlaying)
+	// 重连后观看者保留 track, 从下一个 IDR 开始恢复
+	hub.resync()
+	hub.setClient(client)
+	defer hub.setClient(nil)
 
-	if err != nil {
-		log.Error(err)
-		return
+	// UDP 可能乱序, 按配置的窗口重排; TCP 不会乱序, 只检测丢包
+	window := 0
+	if client.Transport != TransportTCP {
+		window = hub.reorderWindow()
 	}
-	return base64.StdEncoding.EncodeToString([]byte(answer.SDP))
-}
+	videoJitter := newJitterBuffer(window)
+	audioJitter := newJitterBuffer(window)
+	hub.setJitterStats(JitterStats{}, true)
+	hub.setJitterStats(JitterStats{}, false)
 
-func setSdp(path, content string) {
-	cfg, err := ini.Load(path)
-	if err != nil {
-		log.Errorf("Fail to read file: %v", err)
-		os.Exit(1)
+	dropTS := int64(-1) // 丢包所在的帧, 整帧丢弃
+	handleVideo := func(packet jitterPacket) {
+		data := packet.data
+		cc := data[4] & 0xF
+		rtphdr := 12 + int(cc)*4
+		ts := (int64(data[8]) << 24) + (int64(data[9]) << 16) + (int64(data[10]) << 8) + (int64(data[11]))
+		if packet.lost > 0 {
+			// 未写出的访问单元可能缺片, 与当前帧一起丢弃; 之后的帧引用丢失的数据, 等待下一个关键帧
+			depacketizer.Reset()
+			assembler.lose()
+			if dropTS != ts {
+				videoJitter.stats.DroppedFrames++
+				hub.videoLost()
+			}
+			dropTS = ts
+			log.Debugf("[%s] lost %d video packets before #%d, dropping frame", hub.Name, packet.lost, packet.seq)
+		}
+		if ts == dropTS {
+			return
+		}
+		if len(data) <= 4+rtphdr {
+			return
+		}
+		for _, nalu := range depacketizer.Unpack(data[4+rtphdr:]) {
+			if len(nalu) > 0 {
+				handle(nalu, ts)
+			}
+		}
+		// marker 位标记访问单元的最后一个包
+		if data[5]&0x80 != 0 {
+			assembler.mark(ts)
+		}
 	}
+	handleAudio := func(packet jitterPacket) {
+		rtpPacket := &rtp.Packet{}
+		if err := rtpPacket.Unmarshal(packet.data[4:]); err != nil {
+			return
+		}
+		if transcoder != nil {
+			transcoder.push(rtpPacket)
+		} else {
+			hub.broadcastAudio(rtpPacket)
+		}
+	}
+	hub.markReady(quit)
+	for {
+		select {
+		case <-hub.done:
+			return nil
+		case <-quit:
+			log.Infof("[%s] no viewers, rtsp session closed", hub.Name)
+			return nil
+		case <-client.Signals:
+			if err := client.Err(); err == ErrNoUDPPackets && client.Transport == TransportUDP {
+				log.Warnf("[%s] %v, fallback to tcp", hub.Name, err)
+				hub.SetTransport(TransportTCP)
+				return err
+			} else if err != nil {
+				return err
+			}
+			return errors.New("rtsp read loop exited")
+		case data := <-client.Outgoing:
+			count += len(data)
 
-	// Now, make some changes and save it
-	cfg.Section("general").Key("sdp").SetValue(content)
-	cfg.SaveTo(path)
-	log.Infof("写入 remoteSdp 到文件 %s 成功", path)
+			// log.Error("recive  rtp packet size", len(data), "recive all packet size", count)
+			if len(data) < 16 || data[0] != 36 {
+				continue
+			}
+			seq := uint16(data[6])<<8 | uint16(data[7])
+			if int(data[1]) == videoChannel {
+				before := videoJitter.stats
+				for _, packet := range videoJitter.push(seq, data) {
+					handleVideo(packet)
+				}
+				if videoJitter.stats != before {
+					hub.setJitterStats(videoJitter.stats, true)
+				}
+			} else if int(data[1]) == audioChannel {
+				before := audioJitter.stats
+				for _, packet := range audioJitter.push(seq, data) {
+					handleAudio(packet)
+				}
+				if audioJitter.stats != before {
+					hub.setJitterStats(audioJitter.stats, false)
+				}
+			}
+		}
+	}
 }
@@ -0,0 +1,59 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v2"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	backoff := reconnectBackoff{min: time.Second, max: 5 * time.Second, resetAfter: time.Minute}
+	steps := []struct {
+		lasted time.Duration
+		want   time.Duration
+	}{
+		{0, time.Second},
+		{time.Second, 2 * time.Second},
+		{time.Second, 4 * time.Second},
+		{time.Second, 5 * time.Second}, // 封顶
+		{time.Second, 5 * time.Second},
+		{2 * time.Minute, time.Second}, // 长会话后重置
+		{time.Second, 2 * time.Second},
+	}
+	for i, step := range steps {
+		if delay := backoff.next(step.lasted); delay != step.want {
+			t.Errorf("step %d: delay = %s, want %s", i, delay, step.want)
+		}
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := jitter(time.Second); d < 500*time.Millisecond || d > time.Second {
+			t.Fatalf("jitter(1s) = %s", d)
+		}
+	}
+}
+
+func TestWebRTCConfiguration(t *testing.T) {
+	tests := []struct {
+		stun   *StunConfig
+		relay  bool
+		server bool
+	}{
+		{nil, false, false},
+		{&StunConfig{Policy: ICEPolicyRelay}, false, false},
+		{&StunConfig{URL: "stun:stun.example.com:3478"}, false, true}, // 只有 stun 时默认不限制
+		{&StunConfig{URL: "turn:turn.example.com:3478"}, true, true},
+		{&StunConfig{URL: "turns:turn.example.com:5349"}, true, true},
+		{&StunConfig{URL: "turn:turn.example.com:3478", Policy: ICEPolicyAll}, false, true},
+		{&StunConfig{URL: "stun:stun.example.com:3478", Policy: ICEPolicyRelay}, true, true},
+	}
+	for _, test := range tests {
+		config := test.stun.webrtcConfiguration()
+		if relay := config.ICETransportPolicy == webrtc.ICETransportPolicyRelay; relay != test.relay || (len(config.ICEServers) > 0) != test.server {
+			t.Errorf("%+v: relay = %v, servers = %d", test.stun, relay, len(config.ICEServers))
+		}
+	}
+}
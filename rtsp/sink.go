@@ -0,0 +1,63 @@
+package rtsp
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// sinkQueueSize 附加输出的队列长度
+const sinkQueueSize = viewerQueueSize
+
+// sinkQueue Hub.AddSink 附加的输出, 在独立 goroutine 中按队列调用, 慢输出不阻塞读循环与观看者
+type sinkQueue struct {
+	sink     FrameSink
+	queue    chan *Frame
+	done     chan struct{}
+	skipping bool // 队列满丢帧后等待下一个关键帧 (hub.mutex 保护)
+	dropped  int
+}
+
+func newSinkQueue(sink FrameSink) *sinkQueue {
+	q := &sinkQueue{
+		sink:  sink,
+		queue: make(chan *Frame, sinkQueueSize),
+		done:  make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// WriteFrame 非阻塞投递, 队列满时丢弃并等待下一个关键帧, 与 Viewer 相同
+func (q *sinkQueue) WriteFrame(frame *Frame) {
+	if q.skipping {
+		if !frame.Keyframe {
+			return
+		}
+		q.skipping = false
+	}
+	select {
+	case q.queue <- frame:
+	default:
+		q.skipping = true
+		q.dropped++
+		if q.dropped%100 == 1 {
+			log.Warnf("frame sink %T queue full, dropped %d frames", q.sink, q.dropped)
+		}
+	}
+}
+
+// run 将队列中的帧交给输出, close 后退出
+func (q *sinkQueue) run() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case frame := <-q.queue:
+			q.sink.WriteFrame(frame)
+		}
+	}
+}
+
+// close 停止投递, 正在执行的 WriteFrame 不受影响
+func (q *sinkQueue) close() {
+	close(q.done)
+}
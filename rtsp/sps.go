@@ -0,0 +1,224 @@
+package rtsp
+
+import (
+	"errors"
+)
+
+// errSPSTruncated SPS 数据不完整
+var errSPSTruncated = errors.New("sps truncated")
+
+// spsReader 读取 SPS 中的定长与指数哥伦布字段, 出错后后续读取都返回 0, 最后检查 err
+type spsReader struct {
+	bits bitReader
+	err  error
+}
+
+// newSPSReader 去掉 NAL 头与防竞争字节 (00 00 03)
+func newSPSReader(nalu []byte, headerLen int) *spsReader {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu[headerLen:] {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return &spsReader{bits: bitReader{data: rbsp}}
+}
+
+// u 读取 n 位无符号数
+func (r *spsReader) u(n int) int {
+	if r.err != nil {
+		return 0
+	}
+	value, err := r.bits.readBits(n)
+	if err != nil {
+		r.err = errSPSTruncated
+	}
+	return value
+}
+
+// ue 读取无符号指数哥伦布码
+func (r *spsReader) ue() int {
+	zeros := 0
+	for r.u(1) == 0 && r.err == nil {
+		zeros++
+		if zeros > 31 {
+			r.err = errors.New("sps exp-golomb code too long")
+			return 0
+		}
+	}
+	return 1<<uint(zeros) - 1 + r.u(zeros)
+}
+
+// se 读取有符号指数哥伦布码
+func (r *spsReader) se() int {
+	k := r.ue()
+	if k%2 == 1 {
+		return (k + 1) / 2
+	}
+	return -k / 2
+}
+
+// h264HighProfiles 带 chroma_format_idc 等扩展字段的 profile_idc
+var h264HighProfiles = map[int]bool{100: true, 110: true, 122: true, 244: true, 44: true, 83: true, 86: true, 118: true, 128: true, 138: true, 139: true, 134: true, 135: true}
+
+// h264SPSResolution 解析 H.264 SPS 得到裁剪后的宽高 (ITU-T H.264 7.3.2.1.1)
+func h264SPSResolution(sps []byte) (width, height int, err error) {
+	if len(sps) < 4 {
+		return 0, 0, errSPSTruncated
+	}
+	r := newSPSReader(sps, 1)
+	profile := r.u(8)
+	r.u(16) // constraint_set_flags, level_idc
+	r.ue()  // seq_parameter_set_id
+	chromaFormat := 1
+	separateColourPlane := 0
+	if h264HighProfiles[profile] {
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			separateColourPlane = r.u(1)
+		}
+		r.ue() // bit_depth_luma_minus8
+		r.ue() // bit_depth_chroma_minus8
+		r.u(1) // qpprime_y_zero_transform_bypass_flag
+		if r.u(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists && r.err == nil; i++ {
+				if r.u(1) == 0 {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := 8, 8
+				for j := 0; j < size && r.err == nil; j++ {
+					if next != 0 {
+						next = (last + r.se() + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.u(1) // delta_pic_order_always_zero_flag
+		r.se() // offset_for_non_ref_pic
+		r.se() // offset_for_top_to_bottom_field
+		cycle := r.ue()
+		for i := 0; i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+	r.ue() // max_num_ref_frames
+	r.u(1) // gaps_in_frame_num_value_allowed_flag
+	widthMbs := r.ue() + 1
+	heightMapUnits := r.ue() + 1
+	frameMbsOnly := r.u(1)
+	if frameMbsOnly == 0 {
+		r.u(1) // mb_adaptive_frame_field_flag
+	}
+	r.u(1) // direct_8x8_inference_flag
+	width = widthMbs * 16
+	height = (2 - frameMbsOnly) * heightMapUnits * 16
+	if r.u(1) == 1 {
+		cropX, cropY := 1, 2-frameMbsOnly
+		if separateColourPlane == 0 && chromaFormat != 0 {
+			if chromaFormat != 3 {
+				cropX = 2
+			}
+			if chromaFormat == 1 {
+				cropY *= 2
+			}
+		}
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		width -= (left + right) * cropX
+		height -= (top + bottom) * cropY
+	}
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("sps invalid resolution")
+	}
+	return width, height, nil
+}
+
+// h265SPSResolution 解析 H.265 SPS 得到一致性窗口裁剪后的宽高 (ITU-T H.265 7.3.2.2)
+func h265SPSResolution(sps []byte) (width, height int, err error) {
+	if len(sps) < 3 {
+		return 0, 0, errSPSTruncated
+	}
+	r := newSPSReader(sps, 2)
+	r.u(4) // sps_video_parameter_set_id
+	maxSubLayers := r.u(3)
+	r.u(1) // sps_temporal_id_nesting_flag
+	// profile_tier_level: general_profile_space 到 general_level_idc
+	r.u(32)
+	r.u(32)
+	r.u(32)
+	subLayerProfile := make([]bool, maxSubLayers)
+	subLayerLevel := make([]bool, maxSubLayers)
+	for i := 0; i < maxSubLayers; i++ {
+		subLayerProfile[i] = r.u(1) == 1
+		subLayerLevel[i] = r.u(1) == 1
+	}
+	if maxSubLayers > 0 {
+		r.u(2 * (8 - maxSubLayers)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayers; i++ {
+		if subLayerProfile[i] {
+			r.u(32)
+			r.u(32)
+			r.u(24)
+		}
+		if subLayerLevel[i] {
+			r.u(8)
+		}
+	}
+	r.ue() // sps_seq_parameter_set_id
+	chromaFormat := r.ue()
+	separateColourPlane := 0
+	if chromaFormat == 3 {
+		separateColourPlane = r.u(1)
+	}
+	width = r.ue()
+	height = r.ue()
+	if r.u(1) == 1 {
+		subWidth, subHeight := 1, 1
+		if separateColourPlane == 0 {
+			if chromaFormat == 1 || chromaFormat == 2 {
+				subWidth = 2
+			}
+			if chromaFormat == 1 {
+				subHeight = 2
+			}
+		}
+		left, right, top, bottom := r.ue(), r.ue(), r.ue(), r.ue()
+		width -= (left + right) * subWidth
+		height -= (top + bottom) * subHeight
+	}
+	if r.err != nil {
+		return 0, 0, r.err
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, errors.New("sps invalid resolution")
+	}
+	return width, height, nil
+}
@@ -0,0 +1,106 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// bitWriter 按位写入, 用于构造测试 SPS
+type bitWriter struct {
+	data []byte
+	bits int
+}
+
+func (w *bitWriter) u(value, n int) *bitWriter {
+	for i := n - 1; i >= 0; i-- {
+		if w.bits%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if value>>uint(i)&1 == 1 {
+			w.data[len(w.data)-1] |= 0x80 >> uint(w.bits%8)
+		}
+		w.bits++
+	}
+	return w
+}
+
+func (w *bitWriter) ue(value int) *bitWriter {
+	n := 0
+	for x := value + 1; x > 1; x >>= 1 {
+		n++
+	}
+	return w.u(0, n).u(value+1, n+1)
+}
+
+func (w *bitWriter) se(value int) *bitWriter {
+	if value > 0 {
+		return w.ue(2*value - 1)
+	}
+	return w.ue(-2 * value)
+}
+
+// rbsp 加上 rbsp_stop_one_bit 并补齐字节
+func (w *bitWriter) rbsp(header ...byte) []byte {
+	w.u(1, 1)
+	return append(header, w.data...)
+}
+
+func TestH264SPSResolution(t *testing.T) {
+	tests := []struct {
+		name          string
+		sps           []byte
+		width, height int
+	}{
+		{
+			name: "baseline 1080p with cropping",
+			sps: (&bitWriter{}).u(66, 8).u(0, 16).ue(0).
+				ue(0).ue(0).ue(0). // frame_num, poc type 0, poc lsb
+				ue(1).u(0, 1).ue(119).ue(67).u(1, 1).u(1, 1).
+				u(1, 1).ue(0).ue(0).ue(0).ue(4).u(0, 1).rbsp(0x67),
+			width:  1920,
+			height: 1080,
+		},
+		{
+			name: "high interlaced with scaling lists",
+			sps: (&bitWriter{}).u(100, 8).u(0x0028, 16).ue(0).
+				ue(1).ue(0).ue(0).u(0, 1). // 4:2:0, 8 bit
+				u(1, 1).                   // seq_scaling_matrix_present_flag
+				u(1, 1).se(-8).u(0, 7).
+				ue(0).ue(1).u(0, 1).se(2).se(-1).ue(2).se(1).se(3). // poc type 1
+				ue(4).u(0, 1).ue(44).ue(17).u(0, 1).u(1, 1).u(1, 1).
+				u(0, 1).u(0, 1).rbsp(0x67),
+			width:  720,
+			height: 576,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			width, height, err := h264SPSResolution(test.sps)
+			if err != nil || width != test.width || height != test.height {
+				t.Errorf("resolution = %dx%d, %v, want %dx%d", width, height, err, test.width, test.height)
+			}
+		})
+	}
+	if _, _, err := h264SPSResolution([]byte{0x67, 66, 0, 30}); err == nil {
+		t.Error("truncated sps parsed")
+	}
+}
+
+func TestH265SPSResolution(t *testing.T) {
+	w := (&bitWriter{}).u(0, 4).u(1, 3).u(1, 1)
+	w.u(0x01600000, 32).u(0, 32).u(0x5D, 32)         // general profile_tier_level
+	w.u(1, 1).u(1, 1).u(0, 2*7)                      // sub_layer_profile/level_present_flag, reserved
+	w.u(0x01600000, 32).u(0, 32).u(0, 24).u(0x5A, 8) // sub layer profile_tier_level
+	w.ue(0).ue(1).ue(1920).ue(1088).u(1, 1).ue(0).ue(0).ue(0).ue(4)
+	width, height, err := h265SPSResolution(w.rbsp(0x42, 0x01))
+	if err != nil || width != 1920 || height != 1080 {
+		t.Errorf("resolution = %dx%d, %v", width, height, err)
+	}
+}
+
+func TestSPSEmulationPrevention(t *testing.T) {
+	r := newSPSReader([]byte{0x67, 0, 0, 3, 1, 0, 0, 3, 3}, 1)
+	if want := []byte{0, 0, 1, 0, 0, 3}; !bytes.Equal(r.bits.data, want) {
+		t.Errorf("rbsp = %x, want %x", r.bits.data, want)
+	}
+}
@@ -0,0 +1,154 @@
+package rtsp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/pion/rtp"
+	"github.com/pion/srtp"
+	log "github.com/sirupsen/logrus"
+)
+
+// srtcpTrailerLen SRTCP 包尾: E 标志与 index, 加上认证标签
+const srtcpTrailerLen = 4 + srtpAuthTagLen
+
+var errSRTPTruncated = errors.New("srtp: packet truncated")
+
+// ErrSRTPInsecure RTP/SAVP 媒体只能经 rtsps 拉取, 明文 RTSP 会泄露双方的 MIKEY 密钥
+var ErrSRTPInsecure = errors.New("rtsp: srtp media requires rtsps://, refusing to exchange keys in the clear")
+
+// srtpSession 一路 RTP/SAVP 媒体的 SRTP 状态; pion/srtp 的 context 只能单向使用, 解密与加密各一个
+type srtpSession struct {
+	mutex   sync.Mutex
+	decrypt *srtp.Context // 摄像机的密钥, 解密收到的 RTP/RTCP
+	encrypt *srtp.Context // 本端的密钥, 加密发出的 RTCP
+}
+
+// srtpOffer 媒体为 RTP/SAVP 时生成本端密钥, 返回 SETUP 使用的 profile 与 KeyMgmt 头;
+// 密钥以明文 KEMAC 传递, 控制连接不是 TLS 时返回 ErrSRTPInsecure
+func (client *Client) srtpOffer(index int) (profile, keyMgmt string, err error) {
+	info := client.infos[index]
+	if !strings.HasPrefix(info.Profile, "RTP/SAVP") {
+		return "RTP/AVP", "", nil
+	}
+	if !client.secure {
+		return "", "", ErrSRTPInsecure
+	}
+	key := make([]byte, srtpKeyLen+srtpSaltLen)
+	if _, err := rand.Read(key); err != nil {
+		return "", "", err
+	}
+	encrypt, err := srtp.CreateContext(key[:srtpKeyLen], key[srtpKeyLen:], srtp.ProtectionProfileAes128CmHmacSha1_80)
+	if err != nil {
+		return "", "", err
+	}
+	if client.srtp == nil {
+		client.srtp = make(map[byte]*srtpSession)
+	}
+	client.srtp[byte(index)] = &srtpSession{encrypt: encrypt}
+	data := base64.StdEncoding.EncodeToString(newMIKEY(client.rtcpState.ssrc, key[:srtpKeyLen], key[srtpKeyLen:]))
+	return info.Profile, "KeyMgmt: prot=mikey; uri=\"" + client.uri + "/" + client.track[index] + "\"; data=\"" + data + "\"\r\n", nil
+}
+
+// srtpAnswer SETUP 成功后取摄像机的密钥, SETUP 应答中的 KeyMgmt 优先于 SDP 中的 key-mgmt
+func (client *Client) srtpAnswer(index int) error {
+	session := client.srtp[byte(index)]
+	if session == nil {
+		return nil
+	}
+	data := client.infos[index].KeyMgmt
+	if answer := keyMgmtData(client.keyMgmt); answer != "" {
+		data = answer
+	}
+	if data == "" {
+		return errors.New("rtsp: no mikey key for srtp track " + client.track[index])
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return err
+	}
+	msg, err := parseMIKEY(raw)
+	if err != nil {
+		return err
+	}
+	// 会话级 key-mgmt 为每路媒体各带一个 crypto session
+	cs := 0
+	if len(msg.sessions) > 1 && index < len(msg.sessions) {
+		cs = index
+	}
+	key, salt, err := msg.srtpKey(cs)
+	if err != nil {
+		return err
+	}
+	session.decrypt, err = srtp.CreateContext(key, salt, srtp.ProtectionProfileAes128CmHmacSha1_80)
+	return err
+}
+
+// keyMgmtData 从 KeyMgmt 头 (RFC 4567) 中取 MIKEY 数据
+func keyMgmtData(header string) string {
+	for _, spec := range strings.Split(header, ",") {
+		var prot, data string
+		for _, param := range strings.Split(spec, ";") {
+			keyval := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(keyval) != 2 {
+				continue
+			}
+			switch strings.ToLower(keyval[0]) {
+			case "prot":
+				prot = strings.Trim(keyval[1], `"`)
+			case "data":
+				data = strings.Trim(keyval[1], `"`)
+			}
+		}
+		if strings.EqualFold(prot, "mikey") && data != "" {
+			return data
+		}
+	}
+	return ""
+}
+
+// unprotect 解密通道上的 SRTP/SRTCP 包, 非 SAVP 媒体原样返回; 出错时返回 false, 丢弃该包
+func (client *Client) unprotect(channel byte, packet []byte) ([]byte, bool) {
+	session := client.srtp[channel/2]
+	if session == nil || session.decrypt == nil {
+		return packet, true
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	var out []byte
+	var err error
+	if channel%2 == 0 {
+		header := &rtp.Header{}
+		if err = header.Unmarshal(packet); err == nil && len(packet) < header.PayloadOffset+srtpAuthTagLen {
+			err = errSRTPTruncated
+		}
+		if err == nil {
+			out, err = session.decrypt.DecryptRTP(nil, packet, header)
+		}
+	} else if len(packet) < 8+srtcpTrailerLen {
+		err = errSRTPTruncated
+	} else {
+		out, err = session.decrypt.DecryptRTCP(nil, packet, nil)
+	}
+	if err != nil {
+		if client.Debug {
+			log.Println("srtp channel", channel, err)
+		}
+		return nil, false
+	}
+	return out, true
+}
+
+// protectRTCP 用本端密钥加密发往 RTCP 通道的包, 非 SAVP 媒体原样返回
+func (client *Client) protectRTCP(channel byte, packet []byte) ([]byte, error) {
+	session := client.srtp[channel/2]
+	if session == nil {
+		return packet, nil
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.encrypt.EncryptRTCP(nil, packet, nil)
+}
@@ -0,0 +1,150 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/srtp"
+)
+
+func TestKeyMgmtData(t *testing.T) {
+	header := `prot=foo; data="x", prot=mikey; uri="rtsp://cam/live/trackID=0"; data="AQAF+w=="`
+	if data := keyMgmtData(header); data != "AQAF+w==" {
+		t.Errorf("data = %q", data)
+	}
+	if data := keyMgmtData(""); data != "" {
+		t.Errorf("empty header data = %q", data)
+	}
+}
+
+func TestSRTPSource(t *testing.T) {
+	key := make([]byte, srtpKeyLen+srtpSaltLen)
+	rand.Read(key)
+	encrypt, err := srtp.CreateContext(key[:srtpKeyLen], key[srtpKeyLen:], srtp.ProtectionProfileAes128CmHmacSha1_80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sdp := strings.Replace(testutil.SDP, "RTP/AVP", "RTP/SAVP", 1) +
+		"a=key-mgmt:mikey " + base64.StdEncoding.EncodeToString(newMIKEY(0x1234, key[:srtpKeyLen], key[srtpKeyLen:])) + "\r\n"
+	plain := append(rtpPacket(1, 3600, 0x1234), 0x65, 0xaa)
+	packet, err := encrypt.EncryptRTP(nil, plain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte{}, packet...)
+	tampered[len(tampered)-1] ^= 1
+
+	var mutex sync.Mutex
+	var transport, clientKey string
+	server, pool := testutil.NewRTSPSServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", sdp
+		case "SETUP":
+			mutex.Lock()
+			transport, clientKey = req.Header.Get("Transport"), req.Header.Get("KeyMgmt")
+			mutex.Unlock()
+			return 200, "Session: 1\r\nTransport: RTP/SAVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			for _, data := range [][]byte{tampered, packet} {
+				conn.Write(append([]byte{'$', 0, byte(len(data) >> 8), byte(len(data))}, data...))
+			}
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	client.TLSConfig = &tls.Config{RootCAs: pool}
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case frame := <-client.Outgoing:
+		if !bytes.Equal(frame[4:], plain) || int(frame[2])<<8|int(frame[3]) != len(plain) {
+			t.Errorf("outgoing = %x, want %x", frame, plain)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("decrypted rtp not delivered")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if !strings.HasPrefix(transport, "RTP/SAVP/TCP;") {
+		t.Errorf("transport = %q", transport)
+	}
+	// 接收报告用 SETUP 中本端的密钥加密
+	raw, err := base64.StdEncoding.DecodeString(keyMgmtData(clientKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := parseMIKEY(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.sessions[0].ssrc != client.rtcpState.ssrc {
+		t.Errorf("mikey ssrc = %x, want %x", msg.sessions[0].ssrc, client.rtcpState.ssrc)
+	}
+	reportKey, reportSalt, err := msg.srtpKey(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypt, err := srtp.CreateContext(reportKey, reportSalt, srtp.ProtectionProfileAes128CmHmacSha1_80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := client.receiverReports(time.Now())[1]
+	decrypted, err := decrypt.DecryptRTCP(nil, report, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := rtcp.Unmarshal(decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr, ok := packets[0].(*rtcp.ReceiverReport); !ok || rr.Reports[0].SSRC != 0x1234 {
+		t.Errorf("report = %+v", packets[0])
+	}
+}
+
+func TestSRTPInsecure(t *testing.T) {
+	sdp := strings.Replace(testutil.SDP, "RTP/AVP", "RTP/SAVP", 1)
+	var mutex sync.Mutex
+	var setup bool
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", sdp
+		case "SETUP":
+			mutex.Lock()
+			setup = true
+			mutex.Unlock()
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	err := client.Open()
+	defer client.Close()
+	if err != ErrSRTPInsecure {
+		t.Fatalf("open = %v", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if setup {
+		t.Error("keys sent over plaintext rtsp")
+	}
+}
@@ -0,0 +1,105 @@
+package rtsp
+
+import (
+	"time"
+)
+
+// 视频时钟参数
+const (
+	videoClockRate    = 90000 // WebRTC 视频 RTP 时钟
+	defaultFrameRate  = 25    // 第一帧的时长按此估计
+	maxTimestampJumpS = 10    // 相邻帧时间戳跳变超过该秒数视为不连续
+)
+
+// rtpClock 解开 32 位回绕的 RTP 时间戳
+type rtpClock struct {
+	started   bool
+	last      uint32
+	unwrapped int64
+}
+
+// unwrap 返回扩展到 64 位的时间戳, 相邻时间戳按有符号 32 位差值累加
+func (c *rtpClock) unwrap(ts uint32) int64 {
+	if !c.started {
+		c.started = true
+		c.unwrapped = int64(ts)
+	} else {
+		c.unwrapped += int64(int32(ts - c.last))
+	}
+	c.last = ts
+	return c.unwrapped
+}
+
+// frameTimer 根据访问单元的 RTP 时间戳计算输出样本的时长 (media.Sample.Samples)
+//
+// pion 按 Samples 在写出样本后推进 track 时间戳, 而下一帧的时间戳此时未知;
+// 这里以上一帧间隔估计本帧时长, 并以累计写出量校正, 输出时间戳不会漂移.
+type frameTimer struct {
+	inRate          int
+	outRate         int
+	clock           rtpClock
+	started         bool
+	base            int64 // written 为 0 时对应的输入时间戳
+	last            int64
+	lastDelta       int64
+	written         int64 // 已写出的输出时钟总量, 即下一帧的输出时间戳
+	discontinuities int
+	rebased         bool // 新会话开始, 下一帧的时间戳与之前无关
+}
+
+func newFrameTimer(inRate, outRate int) *frameTimer {
+	if inRate <= 0 {
+		inRate = outRate
+	}
+	return &frameTimer{inRate: inRate, outRate: outRate}
+}
+
+// rebase 重连后的新会话: 时间戳与上一会话无关, 时钟频率也可能不同; 下一帧从当前输出位置继续, 计为一次不连续
+func (t *frameTimer) rebase(inRate int) {
+	if inRate <= 0 {
+		inRate = t.outRate
+	}
+	t.lastDelta = t.lastDelta * int64(inRate) / int64(t.inRate)
+	t.inRate = inRate
+	t.clock = rtpClock{}
+	t.rebased = t.started
+}
+
+// toOut 输入时钟换算为输出时钟
+func (t *frameTimer) toOut(d int64) int64 {
+	return d * int64(t.outRate) / int64(t.inRate)
+}
+
+// duration 返回时间戳为 ts 的访问单元的 Samples, 时间戳倒退或跳变过大时以当前输出位置重新对齐
+func (t *frameTimer) duration(ts uint32) uint32 {
+	now := t.clock.unwrap(ts)
+	if !t.started {
+		t.started = true
+		t.base = now
+		t.lastDelta = int64(t.inRate / defaultFrameRate)
+	} else if delta := now - t.last; t.rebased || delta < 0 || delta > int64(maxTimestampJumpS*t.inRate) {
+		t.rebased = false
+		t.discontinuities++
+		t.base = now - t.written*int64(t.inRate)/int64(t.outRate)
+	} else if delta > 0 {
+		t.lastDelta = delta
+	}
+	t.last = now
+	next := t.toOut(now - t.base + t.lastDelta)
+	samples := next - t.written
+	if samples < 1 {
+		samples = 1
+	}
+	t.written += samples
+	return uint32(samples)
+}
+
+// ticksDuration rate 时钟下的计数换算为时长
+func ticksDuration(ticks int64, rate int) time.Duration {
+	return time.Duration(ticks * int64(time.Second) / int64(rate))
+}
+
+// durationTicks 时长换算为 rate 时钟下的计数, 四舍五入
+func durationTicks(d time.Duration, rate int) int64 {
+	return (int64(d)*int64(rate) + int64(time.Second)/2) / int64(time.Second)
+}
@@ -0,0 +1,101 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestRTPClockUnwrap(t *testing.T) {
+	var clock rtpClock
+	got := []int64{}
+	for _, ts := range []uint32{0xFFFFF000, 0xFFFFFF00, 0x100, 0x80, 0x1000} {
+		got = append(got, clock.unwrap(ts))
+	}
+	want := []int64{0xFFFFF000, 0xFFFFFF00, 1<<32 + 0x100, 1<<32 + 0x80, 1<<32 + 0x1000}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("unwrap #%d = %#x, want %#x", i, got[i], want[i])
+		}
+	}
+}
+
+func TestFrameTimer(t *testing.T) {
+	tests := []struct {
+		name   string
+		inRate int
+		ts     []uint32
+		want   []uint32
+		jumps  int
+	}{
+		{
+			name:   "steady 25fps",
+			inRate: 90000,
+			ts:     []uint32{1000, 4600, 8200, 11800},
+			want:   []uint32{3600, 3600, 3600, 3600},
+		},
+		{
+			name:   "wraparound",
+			inRate: 90000,
+			ts:     []uint32{0xFFFFF000, 0xFFFFFBB8, 0x770, 0x1328}, // 间隔 3000
+			want:   []uint32{3600, 2400, 3000, 3000},
+		},
+		{
+			// 按上一帧间隔估计的时长偏差在之后的帧中补偿, 不累计漂移
+			name:   "variable spacing",
+			inRate: 90000,
+			ts:     []uint32{0, 3000, 9000, 12000, 15000},
+			want:   []uint32{3600, 2400, 9000, 1, 2999},
+		},
+		{
+			name:   "clock rate conversion",
+			inRate: 1000,
+			ts:     []uint32{0, 40, 80},
+			want:   []uint32{3600, 3600, 3600},
+		},
+		{
+			name:   "backward jump",
+			inRate: 90000,
+			ts:     []uint32{900000, 903600, 3600, 7200},
+			want:   []uint32{3600, 3600, 3600, 3600},
+			jumps:  1,
+		},
+		{
+			name:   "forward jump",
+			inRate: 90000,
+			ts:     []uint32{0, 3600, 3600 + 20*90000, 7200 + 20*90000},
+			want:   []uint32{3600, 3600, 3600, 3600},
+			jumps:  1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timer := newFrameTimer(test.inRate, videoClockRate)
+			for i, ts := range test.ts {
+				if got := timer.duration(ts); got != test.want[i] {
+					t.Errorf("frame %d (ts %d) samples = %d, want %d", i, ts, got, test.want[i])
+				}
+			}
+			if timer.discontinuities != test.jumps {
+				t.Errorf("discontinuities = %d, want %d", timer.discontinuities, test.jumps)
+			}
+		})
+	}
+}
+
+func TestFrameTimerRebase(t *testing.T) {
+	timer := newFrameTimer(90000, videoClockRate)
+	for _, ts := range []uint32{900000, 903600, 907200} {
+		timer.duration(ts)
+	}
+	written := timer.written
+	// 新会话时间戳从很小的值开始, 时钟为 45kHz; 输出从当前位置继续
+	timer.rebase(45000)
+	if got := timer.duration(100); got != 3600 {
+		t.Errorf("first frame after rebase samples = %d", got)
+	}
+	if got := timer.duration(1900); got != 3600 {
+		t.Errorf("second frame after rebase samples = %d", got)
+	}
+	if timer.written != written+7200 || timer.discontinuities != 1 {
+		t.Errorf("written %d (was %d), discontinuities %d", timer.written, written, timer.discontinuities)
+	}
+}
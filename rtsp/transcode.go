@@ -0,0 +1,373 @@
+package rtsp
+
+import (
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"errors"
+	"sync"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2/pkg/media"
+	log "github.com/sirupsen/logrus"
+)
+
+// 音频转码输出, 为空时不转码 (只透传 G.711/Opus)
+const (
+	TranscodeOff  = ""
+	TranscodeOpus = "opus"
+	TranscodePCMU = "pcmu"
+	TranscodePCMA = "pcma"
+)
+
+// 转码输出参数
+const (
+	g711SampleRate = 8000
+	g711FrameSize  = 160 // 20ms
+	opusSampleRate = 48000
+	opusChannels   = 2
+	opusFrameSize  = 960 // 20ms
+	transcodeQueue = 256
+)
+
+// 转码器缺失
+var (
+	ErrNoAACDecoder  = errors.New("no aac decoder registered")
+	ErrNoOpusEncoder = errors.New("no opus encoder registered")
+)
+
+// AudioDecoder 将一个压缩音频帧解码为交织的 16 位 PCM
+type AudioDecoder interface {
+	Decode(frame []byte) ([]int16, error)
+}
+
+// AudioEncoder 将一帧交织的 16 位 PCM 编码
+type AudioEncoder interface {
+	Encode(pcm []int16) ([]byte, error)
+}
+
+// AACDecoderFactory 根据 AudioSpecificConfig 创建 AAC 解码器
+type AACDecoderFactory func(config []byte) (AudioDecoder, error)
+
+// OpusEncoderFactory 创建指定采样率与声道数的 Opus 编码器
+type OpusEncoderFactory func(sampleRate, channels int) (AudioEncoder, error)
+
+var (
+	factoryMutex       sync.RWMutex
+	aacDecoderFactory  AACDecoderFactory = newAACDecoder
+	opusEncoderFactory OpusEncoderFactory
+)
+
+// RegisterAACDecoder 注册 AAC 解码器实现, 默认为内置的 AAC-LC 解码器
+func RegisterAACDecoder(factory AACDecoderFactory) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	aacDecoderFactory = factory
+}
+
+// RegisterOpusEncoder 注册 Opus 编码器实现
+func RegisterOpusEncoder(factory OpusEncoderFactory) {
+	factoryMutex.Lock()
+	defer factoryMutex.Unlock()
+	opusEncoderFactory = factory
+}
+
+// TranscodeAvailable 检查转码输出所需的实现是否已注册: 都需要 AAC 解码器, opus 还需要 Opus 编码器
+func TranscodeAvailable(mode string) error {
+	factoryMutex.RLock()
+	defer factoryMutex.RUnlock()
+	switch mode {
+	case TranscodeOff:
+		return nil
+	case TranscodeOpus, TranscodePCMU, TranscodePCMA:
+	default:
+		return errors.New("unknown audio transcode mode " + mode)
+	}
+	if aacDecoderFactory == nil {
+		return ErrNoAACDecoder
+	}
+	if mode == TranscodeOpus && opusEncoderFactory == nil {
+		return ErrNoOpusEncoder
+	}
+	return nil
+}
+
+// TranscodeModes 按已注册的实现列出可用的转码输出
+func TranscodeModes() []string {
+	var modes []string
+	for _, mode := range []string{TranscodeOpus, TranscodePCMU, TranscodePCMA} {
+		if TranscodeAvailable(mode) == nil {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// audioTranscoder AAC -> Opus/G.711, 在独立 goroutine 中运行, 不阻塞 RTSP 读循环
+type audioTranscoder struct {
+	depacketizer *aacDepacketizer
+	decoder      AudioDecoder
+	encoder      AudioEncoder
+	resampler    *resampler
+	output       int // 输出编码, sdp.Info.Type
+	frameSize    int // 每帧每声道采样数
+	channels     int
+	pending      []int16
+	input        chan *rtp.Packet
+}
+
+// newAudioTranscoder 根据 SDP 中的 mpeg4-generic 媒体创建转码器
+func newAudioTranscoder(info sdp.Info, mode string) (*audioTranscoder, error) {
+	if info.Type != av.AAC {
+		return nil, errors.New("audio transcoding only supports mpeg4-generic")
+	}
+	config, err := parseAACConfig(info.Config)
+	if err != nil {
+		return nil, err
+	}
+	if info.SizeLength <= 0 {
+		return nil, errAACSizeLength
+	}
+	factoryMutex.RLock()
+	decoderFactory := aacDecoderFactory
+	encoderFactory := opusEncoderFactory
+	factoryMutex.RUnlock()
+	if decoderFactory == nil {
+		return nil, ErrNoAACDecoder
+	}
+
+	t := &audioTranscoder{
+		depacketizer: &aacDepacketizer{
+			sizeLength:       info.SizeLength,
+			indexLength:      info.IndexLength,
+			indexDeltaLength: info.IndexDeltaLength,
+		},
+		input: make(chan *rtp.Packet, transcodeQueue),
+	}
+	switch mode {
+	case TranscodeOpus:
+		if encoderFactory == nil {
+			return nil, ErrNoOpusEncoder
+		}
+		if t.encoder, err = encoderFactory(opusSampleRate, opusChannels); err != nil {
+			return nil, err
+		}
+		t.output = sdp.OPUS
+		t.frameSize = opusFrameSize
+		t.channels = opusChannels
+		t.resampler = newResampler(config.SampleRate, config.Channels, opusSampleRate, opusChannels)
+	case TranscodePCMU, TranscodePCMA:
+		t.encoder = g711Encoder{alaw: mode == TranscodePCMA}
+		t.output = av.PCM_MULAW
+		if mode == TranscodePCMA {
+			t.output = av.PCM_ALAW
+		}
+		t.frameSize = g711FrameSize
+		t.channels = 1
+		t.resampler = newResampler(config.SampleRate, config.Channels, g711SampleRate, 1)
+	default:
+		return nil, errors.New("unknown audio transcode mode " + mode)
+	}
+	if t.decoder, err = decoderFactory(info.Config); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// push 非阻塞投递 RTP 包, 转码跟不上时丢弃
+func (t *audioTranscoder) push(packet *rtp.Packet) {
+	select {
+	case t.input <- packet:
+	default:
+	}
+}
+
+// run 转码并分发, input 关闭后退出
+func (t *audioTranscoder) run(hub *Hub) {
+	for packet := range t.input {
+		samples, err := t.transcode(packet)
+		if err != nil {
+			log.Debugf("[%s] audio transcode: %v", hub.Name, err)
+		}
+		for _, sample := range samples {
+			hub.broadcastAudioSample(sample)
+		}
+	}
+}
+
+// transcode 解包, 解码, 重采样并按固定帧长编码
+func (t *audioTranscoder) transcode(packet *rtp.Packet) (samples []media.Sample, err error) {
+	aus, err := t.depacketizer.Unpack(packet)
+	for _, au := range aus {
+		pcm, err := t.decoder.Decode(au)
+		if err != nil {
+			return samples, err
+		}
+		t.pending = append(t.pending, t.resampler.Process(pcm)...)
+	}
+	frame := t.frameSize * t.channels
+	for len(t.pending) >= frame {
+		data, err := t.encoder.Encode(t.pending[:frame])
+		t.pending = t.pending[frame:]
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, media.Sample{Data: data, Samples: uint32(t.frameSize)})
+	}
+	return samples, err
+}
+
+// close 停止转码 goroutine
+func (t *audioTranscoder) close() {
+	close(t.input)
+}
+
+// resampler 线性插值重采样, 并做声道混合
+type resampler struct {
+	step        float64
+	inChannels  int
+	outChannels int
+	pos         float64
+	last        []float64
+}
+
+func newResampler(inRate, inChannels, outRate, outChannels int) *resampler {
+	return &resampler{
+		step:        float64(inRate) / float64(outRate),
+		inChannels:  inChannels,
+		outChannels: outChannels,
+		last:        make([]float64, outChannels),
+	}
+}
+
+// Process 处理一段交织 PCM, 保留跨调用的插值状态
+func (r *resampler) Process(in []int16) []int16 {
+	frames := len(in) / r.inChannels
+	src := make([][]float64, frames+1)
+	src[0] = r.last
+	for i := 0; i < frames; i++ {
+		src[i+1] = r.mix(in[i*r.inChannels : (i+1)*r.inChannels])
+	}
+	var out []int16
+	for r.pos+1 < float64(len(src)) {
+		i := int(r.pos)
+		frac := r.pos - float64(i)
+		for c := 0; c < r.outChannels; c++ {
+			v := src[i][c]
+			if i+1 < len(src) {
+				v += (src[i+1][c] - v) * frac
+			}
+			out = append(out, clamp16(v))
+		}
+		r.pos += r.step
+	}
+	r.pos -= float64(frames)
+	r.last = src[frames]
+	return out
+}
+
+// mix 将一帧输入声道转换为输出声道
+func (r *resampler) mix(frame []int16) []float64 {
+	out := make([]float64, r.outChannels)
+	if r.outChannels == 1 {
+		sum := 0.0
+		for _, v := range frame {
+			sum += float64(v)
+		}
+		out[0] = sum / float64(len(frame))
+		return out
+	}
+	for c := range out {
+		if c < len(frame) {
+			out[c] = float64(frame[c])
+		} else {
+			out[c] = float64(frame[len(frame)-1])
+		}
+	}
+	return out
+}
+
+func clamp16(v float64) int16 {
+	if v > 32767 {
+		return 32767
+	}
+	if v < -32768 {
+		return -32768
+	}
+	return int16(v)
+}
+
+// g711Encoder G.711 编码, alaw 为 false 时为 µ-law
+type g711Encoder struct {
+	alaw bool
+}
+
+// Encode 实现 AudioEncoder
+func (e g711Encoder) Encode(pcm []int16) ([]byte, error) {
+	out := make([]byte, len(pcm))
+	for i, v := range pcm {
+		if e.alaw {
+			out[i] = linearToAlaw(v)
+		} else {
+			out[i] = linearToMulaw(v)
+		}
+	}
+	return out, nil
+}
+
+// g711 分段上限
+var (
+	alawSegEnd = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+	ulawSegEnd = [8]int{0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF, 0x1FFF}
+)
+
+func g711Segment(value int, table *[8]int) int {
+	for i, end := range table {
+		if value <= end {
+			return i
+		}
+	}
+	return 8
+}
+
+// linearToAlaw 16 位线性 PCM 转 A-law
+func linearToAlaw(sample int16) byte {
+	value := int(sample) >> 3
+	mask := 0xD5
+	if value < 0 {
+		mask = 0x55
+		value = -value - 1
+	}
+	seg := g711Segment(value, &alawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	aval := seg << 4
+	if seg < 2 {
+		aval |= value >> 1 & 0xF
+	} else {
+		aval |= value >> uint(seg) & 0xF
+	}
+	return byte(aval ^ mask)
+}
+
+// linearToMulaw 16 位线性 PCM 转 µ-law
+func linearToMulaw(sample int16) byte {
+	const bias = 0x84
+	const clip = 8159
+	value := int(sample) >> 2
+	mask := 0xFF
+	if value < 0 {
+		value = -value
+		mask = 0x7F
+	}
+	if value > clip {
+		value = clip
+	}
+	value += bias >> 2
+	seg := g711Segment(value, &ulawSegEnd)
+	if seg >= 8 {
+		return byte(0x7F ^ mask)
+	}
+	uval := seg<<4 | value>>uint(seg+1)&0xF
+	return byte(uval ^ mask)
+}
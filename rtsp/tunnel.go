@@ -0,0 +1,198 @@
+package rtsp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// TunnelScheme RTSP-over-HTTP 隧道地址的 scheme, 如 rtsp+http://cam:80/live
+const TunnelScheme = "rtsp+http"
+
+// tunnelContentType 隧道 GET/POST 的内容类型
+const tunnelContentType = "application/x-rtsp-tunnelled"
+
+// tunnelPostLength POST 声明的 Content-Length, 写满之前以同一 x-sessioncookie 重新打开 POST
+const tunnelPostLength = 32767
+
+// httpTunnel Apple/QuickTime RTSP-over-HTTP 隧道: GET 连接接收应答与 interleaved 数据, POST 连接发送 base64 编码的请求;
+// 对 Client 来说与普通 TCP 连接相同
+type httpTunnel struct {
+	get    net.Conn
+	reader *bufio.Reader // GET 应答头之后的数据
+	// openPost 发起新的 POST, 按 Content-Length 转发的代理在 body 写满后不再转发
+	openPost func() (net.Conn, error)
+	mutex    sync.Mutex
+	post     net.Conn
+	posted   int       // 当前 POST 已写入的 body 字节数
+	deadline time.Time // 写超时, 重新打开的 POST 沿用
+}
+
+func (tunnel *httpTunnel) Read(b []byte) (int, error) {
+	return tunnel.reader.Read(b)
+}
+
+// Write 每次写入独立编码, 服务器可按块解码; 超出当前 POST 的 Content-Length 时先换一个 POST
+func (tunnel *httpTunnel) Write(b []byte) (int, error) {
+	encoded := base64.StdEncoding.EncodeToString(b)
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	if tunnel.posted+len(encoded) > tunnelPostLength {
+		post, err := tunnel.openPost()
+		if err != nil {
+			return 0, err
+		}
+		if err := post.SetWriteDeadline(tunnel.deadline); err != nil {
+			post.Close()
+			return 0, err
+		}
+		tunnel.post.Close()
+		tunnel.post = post
+		tunnel.posted = 0
+	}
+	if _, err := tunnel.post.Write([]byte(encoded)); err != nil {
+		return 0, err
+	}
+	tunnel.posted += len(encoded)
+	return len(b), nil
+}
+
+func (tunnel *httpTunnel) Close() error {
+	tunnel.mutex.Lock()
+	tunnel.post.Close()
+	tunnel.mutex.Unlock()
+	return tunnel.get.Close()
+}
+
+func (tunnel *httpTunnel) LocalAddr() net.Addr {
+	return tunnel.get.LocalAddr()
+}
+
+func (tunnel *httpTunnel) RemoteAddr() net.Addr {
+	return tunnel.get.RemoteAddr()
+}
+
+func (tunnel *httpTunnel) SetDeadline(t time.Time) error {
+	if err := tunnel.get.SetDeadline(t); err != nil {
+		return err
+	}
+	return tunnel.SetWriteDeadline(t)
+}
+
+func (tunnel *httpTunnel) SetReadDeadline(t time.Time) error {
+	return tunnel.get.SetReadDeadline(t)
+}
+
+func (tunnel *httpTunnel) SetWriteDeadline(t time.Time) error {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	tunnel.deadline = t
+	return tunnel.post.SetWriteDeadline(t)
+}
+
+// dialTunnel 建立隧道: 先发 GET 并等待 200 (401 时按质询认证后重试一次), 再发 POST; 按环境变量 HTTP_PROXY 使用代理
+func (client *Client) dialTunnel(dialer *net.Dialer, address string) (net.Conn, error) {
+	buf := make([]byte, 11)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	cookie := hex.EncodeToString(buf)
+	target := client.path
+	dial := address
+	var proxyAuth string
+	proxy, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "http", Host: address}})
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		// 经过代理时请求行使用绝对地址
+		target = "http://" + address + client.path
+		dial = proxy.Host
+		if proxy.Port() == "" {
+			dial = net.JoinHostPort(proxy.Hostname(), "80")
+		}
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			proxyAuth = "Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password)) + "\r\n"
+		}
+	}
+
+	var get net.Conn
+	var reader *bufio.Reader
+	for retried := false; ; retried = true {
+		if get, err = dialer.Dial("tcp", dial); err != nil {
+			return nil, err
+		}
+		get.SetDeadline(time.Now().Add(client.rtspTimeOut * time.Second))
+		if _, err = get.Write([]byte(client.tunnelRequest("GET", target, cookie) + proxyAuth + "\r\n")); err != nil {
+			get.Close()
+			return nil, err
+		}
+		reader = bufio.NewReader(get)
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			get.Close()
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		get.Close()
+		if resp.StatusCode == http.StatusUnauthorized && !retried {
+			if _, err := client.challenged(&Response{Header: textproto.MIMEHeader(resp.Header)}); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, errors.New("http tunnel GET returned " + resp.Status)
+	}
+
+	openPost := func() (net.Conn, error) {
+		post, err := dialer.Dial("tcp", dial)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := post.Write([]byte(client.tunnelRequest("POST", target, cookie) + proxyAuth +
+			"Content-Type: " + tunnelContentType + "\r\nContent-Length: " + strconv.Itoa(tunnelPostLength) + "\r\nExpires: Sun, 9 Jan 1972 00:00:00 GMT\r\n\r\n")); err != nil {
+			post.Close()
+			return nil, err
+		}
+		if client.Debug {
+			log.Println("http tunnel POST opened", address, "cookie", cookie)
+		}
+		return post, nil
+	}
+	post, err := openPost()
+	if err != nil {
+		get.Close()
+		return nil, err
+	}
+	if client.Debug {
+		log.Println("http tunnel established", address, "cookie", cookie)
+	}
+	return &httpTunnel{get: get, reader: reader, openPost: openPost, post: post}, nil
+}
+
+// tunnelRequest GET/POST 共同的请求行与头 (不含结尾空行), 已收到质询时带 Authorization
+func (client *Client) tunnelRequest(method, target, cookie string) string {
+	request := method + " " + target + " HTTP/1.0\r\n" +
+		"User-Agent: Lavf57.8.102\r\n" +
+		"x-sessioncookie: " + cookie + "\r\n" +
+		"Accept: " + tunnelContentType + "\r\n" +
+		"Pragma: no-cache\r\nCache-Control: no-cache\r\n"
+	if client.auth != nil {
+		request += client.auth.header(method, client.path)
+	}
+	return request
+}
@@ -0,0 +1,223 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tunnelConn 替身服务器一侧的隧道: 从 POST 解码出的请求读取, 应答写到 GET 连接
+type tunnelConn struct {
+	net.Conn
+	requests io.Reader
+}
+
+func (conn *tunnelConn) Read(b []byte) (int, error) {
+	return conn.requests.Read(b)
+}
+
+// Close 一个 POST 结束时 GET 保留给后续的 POST
+func (conn *tunnelConn) Close() error {
+	return nil
+}
+
+// standInTunnel HTTP 隧道替身服务器, 按 x-sessioncookie 配对 GET/POST 后交给 RTSP 替身处理
+type standInTunnel struct {
+	listener net.Listener
+	rtsp     *testutil.RTSPServer
+	mutex    sync.Mutex
+	gets     map[string]net.Conn
+	requests []*http.Request
+}
+
+func (tunnel *standInTunnel) serve() {
+	for {
+		conn, err := tunnel.listener.Accept()
+		if err != nil {
+			return
+		}
+		go tunnel.handle(conn)
+	}
+}
+
+func (tunnel *standInTunnel) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	tunnel.mutex.Lock()
+	tunnel.requests = append(tunnel.requests, req)
+	cookie := req.Header.Get("x-sessioncookie")
+	if req.Method == "GET" {
+		defer tunnel.mutex.Unlock()
+		if req.Header.Get("Authorization") != "Basic YWRtaW46c2VjcmV0" {
+			conn.Write([]byte("HTTP/1.0 401 Unauthorized\r\nWWW-Authenticate: Basic realm=\"cam\"\r\n\r\n"))
+			conn.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: " + tunnelContentType + "\r\n\r\n"))
+		tunnel.gets[cookie] = conn
+		return
+	}
+	get := tunnel.gets[cookie]
+	tunnel.mutex.Unlock()
+	if get == nil {
+		conn.Close()
+		return
+	}
+	// 像代理一样只转发 Content-Length 以内的 body; 按 4 字符一组解码, 每次写入各自带填充
+	body := io.LimitReader(reader, req.ContentLength)
+	decoded, writer := io.Pipe()
+	go func() {
+		quantum := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(body, quantum); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			data, err := base64.StdEncoding.DecodeString(string(quantum))
+			if err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+			writer.Write(data)
+		}
+	}()
+	tunnel.rtsp.Handle(&tunnelConn{Conn: get, requests: decoded})
+	conn.Close()
+}
+
+func TestHTTPTunnel(t *testing.T) {
+	rtp := "$\x00\x00\x0e" + string(rtpPacket(1, 3600, 0x1234)) + "\x65\xaa"
+	var mutex sync.Mutex
+	var urls []string
+	server := &testutil.RTSPServer{Handler: func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		mutex.Lock()
+		urls = append(urls, req.URL)
+		mutex.Unlock()
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			if !strings.HasPrefix(req.Header.Get("Transport"), "RTP/AVP/TCP;") {
+				return 461, "", ""
+			}
+			return 200, "Session: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			conn.Write([]byte(rtp))
+		}
+		return 200, "", ""
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := &standInTunnel{listener: listener, rtsp: server, gets: make(map[string]net.Conn)}
+	go tunnel.serve()
+	defer listener.Close()
+
+	client := ClientNew()
+	client.URL = "rtsp+http://admin:secret@" + listener.Addr().String() + "/live?channel=1"
+	client.Transport = TransportUDP
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	select {
+	case data := <-client.Outgoing:
+		if string(data) != rtp {
+			t.Errorf("outgoing = %x", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rtp through tunnel not delivered")
+	}
+
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	// GET (401), GET, POST
+	if len(tunnel.requests) != 3 {
+		t.Fatalf("http requests = %d", len(tunnel.requests))
+	}
+	get, post := tunnel.requests[1], tunnel.requests[2]
+	if get.Method != "GET" || post.Method != "POST" || get.URL.String() != "/live?channel=1" || post.URL.String() != "/live?channel=1" {
+		t.Errorf("requests = %s %s, %s %s", get.Method, get.URL, post.Method, post.URL)
+	}
+	if cookie := get.Header.Get("x-sessioncookie"); cookie == "" || cookie != post.Header.Get("x-sessioncookie") {
+		t.Errorf("cookies = %q %q", cookie, post.Header.Get("x-sessioncookie"))
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if want := "rtsp://" + listener.Addr().String() + "/live?channel=1"; len(urls) == 0 || urls[0] != want {
+		t.Errorf("rtsp urls = %q, want %s", urls, want)
+	}
+}
+
+func TestHTTPTunnelReopenPost(t *testing.T) {
+	var mutex sync.Mutex
+	var options int
+	server := &testutil.RTSPServer{Handler: func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		mutex.Lock()
+		options++
+		mutex.Unlock()
+		return 200, "", ""
+	}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel := &standInTunnel{listener: listener, rtsp: server, gets: make(map[string]net.Conn)}
+	go tunnel.serve()
+	defer listener.Close()
+
+	client := ClientNew()
+	if err := client.ParseURL("rtsp+http://admin:secret@" + listener.Addr().String() + "/live"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.dialTunnel(&net.Dialer{Timeout: time.Second}, listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 每个请求编码后约 100 字节, 总量是一个 POST 的数倍
+	const requests = 1000
+	reader := bufio.NewReader(conn)
+	for i := 0; i < requests; i++ {
+		request := "OPTIONS rtsp://" + listener.Addr().String() + "/live RTSP/1.0\r\nCSeq: " + strconv.Itoa(i) + "\r\n\r\n"
+		if _, err := conn.Write([]byte(request)); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := readResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if resp.CSeq() != i {
+			t.Fatalf("request %d: cseq %d", i, resp.CSeq())
+		}
+	}
+
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	cookie := tunnel.requests[1].Header.Get("x-sessioncookie")
+	posts := 0
+	for _, req := range tunnel.requests[2:] {
+		if req.Method != "POST" || req.Header.Get("x-sessioncookie") != cookie || req.ContentLength != tunnelPostLength {
+			t.Errorf("request %s cookie %q length %d", req.Method, req.Header.Get("x-sessioncookie"), req.ContentLength)
+		}
+		posts++
+	}
+	if posts < 3 {
+		t.Errorf("posts = %d", posts)
+	}
+}
@@ -0,0 +1,271 @@
+package rtsp
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RTSP 传输方式
+const (
+	TransportTCP = "tcp" // RTP/AVP/TCP interleaved
+	TransportUDP = "udp" // RTP/AVP unicast, 无数据或 461 时回退 TCP
+)
+
+// udp 相关参数
+const (
+	udpPortMin       = 10000
+	udpPortMax       = 60000
+	udpFirstTimeout  = 5 * time.Second // 首包超时默认值, 超时后回退 TCP
+	udpReadBufSize   = 16384
+	udpAllocAttempts = 100
+)
+
+// ErrNoUDPPackets UDP 传输在超时时间内未收到任何数据
+var ErrNoUDPPackets = errors.New("no rtp packets received over udp")
+
+// transportHeader SETUP 回复中的 Transport 头
+type transportHeader struct {
+	multicast   bool
+	clientPorts [2]int
+	serverPorts [2]int
+	interleaved [2]int
+	destination string
+	source      string
+	ports       [2]int
+	ttl         int
+}
+
+// parseTransport 解析 Transport 头的值
+func parseTransport(value string) (th transportHeader) {
+	th.interleaved = [2]int{-1, -1}
+	for _, field := range strings.Split(value, ";") {
+		field = strings.TrimSpace(field)
+		keyval := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(keyval[0])
+		val := ""
+		if len(keyval) == 2 {
+			val = strings.Trim(keyval[1], `"`)
+		}
+		switch key {
+		case "multicast":
+			th.multicast = true
+		case "client_port":
+			th.clientPorts = parsePortRange(val)
+		case "server_port":
+			th.serverPorts = parsePortRange(val)
+		case "interleaved":
+			th.interleaved = parsePortRange(val)
+		case "destination":
+			th.destination = val
+		case "source":
+			th.source = val
+		case "port":
+			th.ports = parsePortRange(val)
+		case "ttl":
+			th.ttl, _ = strconv.Atoi(val)
+		}
+	}
+	return
+}
+
+// parsePortRange 解析 "a-b" 或 "a", 单值时第二个端口为 a+1
+func parsePortRange(val string) (ports [2]int) {
+	pair := strings.SplitN(val, "-", 2)
+	ports[0], _ = strconv.Atoi(pair[0])
+	if len(pair) == 2 {
+		ports[1], _ = strconv.Atoi(pair[1])
+	} else {
+		ports[1] = ports[0] + 1
+	}
+	return
+}
+
+// udpPair 一个媒体的 RTP/RTCP socket
+type udpPair struct {
+	rtp        *net.UDPConn
+	rtcp       *net.UDPConn
+	serverRTP  *net.UDPAddr
+	serverRTCP *net.UDPAddr
+	source     net.IP // 只接收该地址发来的包, 为 nil 时不过滤
+}
+
+// listenUDPPair 分配相邻的偶数/奇数端口
+func listenUDPPair() (*udpPair, error) {
+	var lastErr error
+	for i := 0; i < udpAllocAttempts; i++ {
+		port := (udpPortMin + rand.Intn(udpPortMax-udpPortMin)) &^ 1
+		rtp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		rtcp, err := net.ListenUDP("udp", &net.UDPAddr{Port: port + 1})
+		if err != nil {
+			rtp.Close()
+			lastErr = err
+			continue
+		}
+		return &udpPair{rtp: rtp, rtcp: rtcp}, nil
+	}
+	return nil, lastErr
+}
+
+// ports 本地 RTP/RTCP 端口
+func (pair *udpPair) ports() (int, int) {
+	return pair.rtp.LocalAddr().(*net.UDPAddr).Port, pair.rtcp.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Close 关闭 socket
+func (pair *udpPair) Close() {
+	pair.rtp.Close()
+	pair.rtcp.Close()
+}
+
+// setupUDP 为 track 申请端口并发送 SETUP, profile 与 keyMgmt 见 srtpOffer
+func (client *Client) setupUDP(track, profile, keyMgmt string) error {
+	pair, err := listenUDPPair()
+	if err != nil {
+		return err
+	}
+	rtpPort, rtcpPort := pair.ports()
+	if err := client.Write("SETUP", "/"+track, "Transport: "+profile+";unicast;client_port="+strconv.Itoa(rtpPort)+"-"+strconv.Itoa(rtcpPort)+"\r\n"+keyMgmt, false, false); err != nil {
+		pair.Close()
+		return err
+	}
+	host := client.socket.RemoteAddr().(*net.TCPAddr).IP
+	th := client.transport
+	// 媒体默认由 RTSP 服务器发出, Transport 中的 source= 指定其他发送地址
+	pair.source = host
+	if source := net.ParseIP(th.source); source != nil {
+		pair.source = source
+	}
+	if th.serverPorts[0] != 0 {
+		pair.serverRTP = &net.UDPAddr{IP: host, Port: th.serverPorts[0]}
+		pair.serverRTCP = &net.UDPAddr{IP: host, Port: th.serverPorts[1]}
+	}
+	client.udp = append(client.udp, pair)
+	return nil
+}
+
+// udpLoop UDP 模式下接收数据, 并维持 RTSP 控制连接
+func (client *Client) udpLoop() {
+	var received int32
+	for i, pair := range client.udp {
+		// 先发一个包打通 NAT
+		if pair.serverRTP != nil {
+			pair.rtp.WriteToUDP([]byte{0x80, 0, 0, 0}, pair.serverRTP)
+			pair.rtcp.WriteToUDP([]byte{0x80, 0xc9, 0, 1, 0, 0, 0, 0}, pair.serverRTCP)
+		}
+		go client.udpRead(pair.rtp, pair.source, byte(2*i), &received)
+		go client.udpRead(pair.rtcp, pair.source, byte(2*i+1), &received)
+	}
+	go client.readControl()
+
+	keepalive := time.NewTicker(time.Duration(client.keepalivetime) * time.Second)
+	defer keepalive.Stop()
+	check := time.NewTicker(time.Second)
+	defer check.Stop()
+	reports := time.NewTicker(rtcpInterval)
+	defer reports.Stop()
+	start := time.Now()
+	last := int32(0)
+	lastAt := start
+	for {
+		select {
+		case <-client.closed:
+			return
+		case <-keepalive.C:
+			if err := client.sendKeepalive(); err != nil {
+				client.exit(err)
+				return
+			}
+		case resp := <-client.replies:
+			if client.controlReply(resp) {
+				if err := client.sendKeepalive(); err != nil {
+					client.exit(err)
+					return
+				}
+			}
+		case <-client.keyframeRequests:
+			if err := client.sendKeyframeRequest(); err != nil {
+				client.exit(err)
+				return
+			}
+		case now := <-reports.C:
+			client.sendReceiverReportsUDP(now)
+		case now := <-check.C:
+			n := atomic.LoadInt32(&received)
+			if n != last {
+				last = n
+				lastAt = now
+			} else if n == 0 && now.Sub(start) > client.udpFirstTimeout {
+				client.exit(ErrNoUDPPackets)
+				return
+			} else if now.Sub(lastAt) > client.rtptimeout*time.Second {
+				client.exit(errors.New("udp rtp timeout"))
+				return
+			}
+		}
+	}
+}
+
+// udpRead 读取 UDP 包并封装成 interleaved 帧送入 Outgoing; 丢弃不是 source 发来的包,
+// received 只统计 RTP, 只有 RTCP 到达时仍按无数据回退 TCP
+func (client *Client) udpRead(conn *net.UDPConn, source net.IP, channel byte, received *int32) {
+	buffer := make([]byte, udpReadBufSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			client.exit(err)
+			return
+		}
+		if source != nil && !addr.IP.Equal(source) {
+			if client.Debug {
+				log.Println("drop udp packet from", addr, "expected", source)
+			}
+			continue
+		}
+		if channel%2 == 0 {
+			if n < 12 {
+				continue
+			}
+			atomic.AddInt32(received, 1)
+		}
+		packet, ok := client.unprotect(channel, buffer[:n])
+		if !ok {
+			continue
+		}
+		client.observe(channel, packet, time.Now())
+		frame := make([]byte, 4+len(packet))
+		frame[0] = 36
+		frame[1] = channel
+		frame[2] = byte(len(packet) >> 8)
+		frame[3] = byte(len(packet))
+		copy(frame[4:], packet)
+		client.Outgoing <- frame
+	}
+}
+
+// readControl UDP 模式下读取控制连接上的应答, 交给 udpLoop 处理
+func (client *Client) readControl() {
+	client.socket.SetReadDeadline(time.Time{})
+	for {
+		resp, err := readResponse(client.reader, nil)
+		if err != nil {
+			client.exit(err)
+			return
+		}
+		select {
+		case client.replies <- resp:
+		case <-client.closed:
+			return
+		}
+	}
+}
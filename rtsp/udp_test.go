@@ -0,0 +1,201 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTransport(t *testing.T) {
+	tests := []struct {
+		value string
+		want  transportHeader
+	}{
+		{
+			"RTP/AVP;unicast;client_port=10000-10001;server_port=6970-6971;source=10.0.0.2",
+			transportHeader{clientPorts: [2]int{10000, 10001}, serverPorts: [2]int{6970, 6971}, interleaved: [2]int{-1, -1}, source: "10.0.0.2"},
+		},
+		{
+			"RTP/AVP/TCP;unicast;Interleaved=2-3",
+			transportHeader{interleaved: [2]int{2, 3}},
+		},
+		{
+			`RTP/AVP;multicast;destination="239.1.2.3";port=5000;ttl=16`,
+			transportHeader{multicast: true, destination: "239.1.2.3", ports: [2]int{5000, 5001}, ttl: 16, interleaved: [2]int{-1, -1}},
+		},
+	}
+	for _, test := range tests {
+		if th := parseTransport(test.value); th != test.want {
+			t.Errorf("%q:\n got %+v\nwant %+v", test.value, th, test.want)
+		}
+	}
+}
+
+func TestUDPFallback461(t *testing.T) {
+	rtp := "$\x00\x00\x0e" + string(rtpPacket(1, 3600, 0x1234)) + "\x65\xaa"
+	var mutex sync.Mutex
+	var transports []string
+	server := testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			mutex.Lock()
+			transports = append(transports, req.Header.Get("Transport"))
+			mutex.Unlock()
+			if !strings.Contains(req.Header.Get("Transport"), "/TCP;") {
+				return 461, "", ""
+			}
+			return 200, "Session: 1\r\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\r\n", ""
+		case "PLAY":
+			conn.Write([]byte(rtp))
+		}
+		return 200, "", ""
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	client.Transport = TransportUDP
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.Transport != TransportTCP {
+		t.Errorf("transport = %s", client.Transport)
+	}
+	select {
+	case data := <-client.Outgoing:
+		if string(data) != rtp {
+			t.Errorf("outgoing = %x", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("rtp over tcp fallback not delivered")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(transports) != 2 || !strings.Contains(transports[0], "client_port=") || !strings.HasPrefix(transports[1], "RTP/AVP/TCP;") {
+		t.Errorf("setup transports = %q", transports)
+	}
+}
+
+// udpStandInServer 接受 UDP SETUP 的替身服务器, PLAY 后把 client_port 交给 play
+func udpStandInServer(t *testing.T, source string, play func(rtpPort, rtcpPort int)) *testutil.RTSPServer {
+	var ports [2]int
+	return testutil.NewRTSPServer(t, func(req *testutil.Request, conn net.Conn) (int, string, string) {
+		switch req.Method {
+		case "DESCRIBE":
+			return 200, "", testutil.SDP
+		case "SETUP":
+			ports = parseTransport(req.Header.Get("Transport")).clientPorts
+			reply := "RTP/AVP;unicast;client_port=" + strconv.Itoa(ports[0]) + "-" + strconv.Itoa(ports[1])
+			if source != "" {
+				reply += ";source=" + source
+			}
+			return 200, "Session: 1\r\nTransport: " + reply + "\r\n", ""
+		case "PLAY":
+			go play(ports[0], ports[1])
+		}
+		return 200, "", ""
+	})
+}
+
+func TestUDPNoRTPPackets(t *testing.T) {
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	done := make(chan struct{})
+	defer close(done)
+	// 只有 RTCP 发送者报告, 没有 RTP
+	server := udpStandInServer(t, "", func(rtpPort, rtcpPort int) {
+		report := []byte{0x80, 200, 0, 6, 0, 0, 0x12, 0x34, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+		for {
+			sender.WriteToUDP(report, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rtcpPort})
+			select {
+			case <-done:
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+	defer server.Close()
+
+	client := ClientNew()
+	client.URL = server.URL("/live")
+	client.Transport = TransportUDP
+	client.udpFirstTimeout = 200 * time.Millisecond
+	if err := client.Open(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	rtcp := false
+	deadline := time.After(3 * time.Second)
+	for {
+		select {
+		case data := <-client.Outgoing:
+			rtcp = rtcp || data[1] == 1
+		case <-client.Signals:
+			if client.Err() != ErrNoUDPPackets {
+				t.Fatalf("err = %v", client.Err())
+			}
+			if !rtcp {
+				t.Error("rtcp not delivered")
+			}
+			return
+		case <-deadline:
+			t.Fatal("rtcp only session did not fall back")
+		}
+	}
+}
+
+func TestUDPSourceFilter(t *testing.T) {
+	stranger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2)})
+	if err != nil {
+		t.Skip("cannot bind 127.0.0.2:", err)
+	}
+	defer stranger.Close()
+	sender, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	for _, source := range []string{"", "127.0.0.2"} {
+		t.Run("source="+source, func(t *testing.T) {
+			// 没有 source= 时只接收 RTSP 服务器 (127.0.0.1) 的包
+			from, spoof := sender, stranger
+			if source != "" {
+				from, spoof = stranger, sender
+			}
+			server := udpStandInServer(t, source, func(rtpPort, rtcpPort int) {
+				dst := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: rtpPort}
+				spoof.WriteToUDP(append(rtpPacket(1, 3600, 0x6666), 0x65), dst)
+				time.Sleep(50 * time.Millisecond)
+				from.WriteToUDP(append(rtpPacket(2, 3600, 0x1234), 0x65), dst)
+			})
+			defer server.Close()
+
+			client := ClientNew()
+			client.URL = server.URL("/live")
+			client.Transport = TransportUDP
+			if err := client.Open(); err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			select {
+			case data := <-client.Outgoing:
+				if ssrc := string(data[12:16]); ssrc != "\x00\x00\x12\x34" {
+					t.Errorf("delivered packet from ssrc %x", ssrc)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("rtp from expected source not delivered")
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package rtsp
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	psdp "github.com/pion/sdp/v2"
+	"github.com/pion/webrtc/v2"
+	"github.com/pion/webrtc/v2/pkg/media"
+	log "github.com/sirupsen/logrus"
+)
+
+// viewerQueueSize 每个观看者的发送队列长度
+const viewerQueueSize = 256
+
+// Viewer 一个 WebRTC 观看者, 拥有独立的 track 与发送队列
+type Viewer struct {
+	ID             string
+	codecs         streamCodecs // 协商时流的编码, 重连后编码变化时关闭
+	peerConnection *webrtc.PeerConnection
+	videoTrack     *webrtc.Track
+	videoSender    *webrtc.RTPSender
+	audioTrack     *webrtc.Track // 浏览器不支持流的音频编码时为 nil
+	queue          chan *Frame
+	audioQueue     chan *rtp.Packet
+	audioSamples   chan media.Sample // 转码后的音频
+	audioSeq       uint16
+	done           chan struct{}
+	closeOnce      sync.Once
+	connected      chan struct{} // ICE 与 DTLS 连通后关闭, 之前写入 track 的数据会被 pion 丢弃
+	live           bool          // 已连通, 接收直播帧与音频 (hub.mutex 保护)
+	synced         bool          // 已收到关键帧, 可以开始发送
+	dropped        int
+	lastKeyframe   time.Time // 上次响应 PLI/FIR 的时间
+}
+
+// newViewer 根据浏览器 offer 创建 PeerConnection, 返回观看者与 answer sdp
+func newViewer(offerSdp string, stun *StunConfig, codecs streamCodecs) (viewer *Viewer, answerSdp string, err error) {
+	parsed := psdp.SessionDescription{}
+	if err := parsed.Unmarshal([]byte(offerSdp)); err != nil {
+		return nil, "", err
+	}
+	videoCodec, err := videoCodecFromOffer(&parsed, codecs.video)
+	if err != nil {
+		return nil, "", err
+	}
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterCodec(videoCodec)
+	audioCodec := audioCodecFromOffer(&parsed, codecs.audio)
+	if audioCodec != nil {
+		mediaEngine.RegisterCodec(audioCodec)
+	}
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine))
+
+	peerConnection, err := api.NewPeerConnection(stun.webrtcConfiguration())
+	if err != nil {
+		return nil, "", err
+	}
+	viewer = &Viewer{
+		ID:             fmt.Sprintf("%016x", rand.Uint64()),
+		codecs:         codecs,
+		peerConnection: peerConnection,
+		queue:          make(chan *Frame, viewerQueueSize),
+		audioQueue:     make(chan *rtp.Packet, viewerQueueSize),
+		audioSamples:   make(chan media.Sample, viewerQueueSize),
+		done:           make(chan struct{}),
+		connected:      make(chan struct{}),
+	}
+	viewer.videoTrack, err = peerConnection.NewTrack(videoCodec.PayloadType, rand.Uint32(), "video", "pion2")
+	if err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	if viewer.videoSender, err = peerConnection.AddTrack(viewer.videoTrack); err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	if audioCodec != nil {
+		viewer.audioTrack, err = peerConnection.NewTrack(audioCodec.PayloadType, rand.Uint32(), "audio", "pion2")
+		if err != nil {
+			peerConnection.Close()
+			return nil, "", err
+		}
+		if _, err = peerConnection.AddTrack(viewer.audioTrack); err != nil {
+			peerConnection.Close()
+			return nil, "", err
+		}
+	}
+	log.Debugf("offer sdp\n%+v", offerSdp)
+
+	offer := webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offerSdp,
+	}
+	if err = peerConnection.SetRemoteDescription(offer); err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		peerConnection.Close()
+		return nil, "", err
+	}
+	log.Debugf("answer sdp\n%+v", answer.SDP)
+	return viewer, answer.SDP, nil
+}
+
+// WriteFrame 实现 FrameSink, 非阻塞投递, 队列满时丢弃并等待下一个关键帧, 慢观看者不会阻塞其他人; 连通之前忽略
+func (viewer *Viewer) WriteFrame(frame *Frame) {
+	if !viewer.live {
+		return
+	}
+	if !viewer.synced {
+		if !frame.Keyframe {
+			return
+		}
+		viewer.synced = true
+	}
+	select {
+	case viewer.queue <- frame:
+	default:
+		viewer.synced = false
+		viewer.dropped++
+		if viewer.dropped%100 == 1 {
+			log.Warnf("viewer %s queue full, dropped %d frames", viewer.ID, viewer.dropped)
+		}
+	}
+}
+
+// sendAudio 透传音频 RTP, 改写 payload type, SSRC 与序号
+func (viewer *Viewer) sendAudio(packet *rtp.Packet) {
+	if viewer.audioTrack == nil || !viewer.live {
+		return
+	}
+	out := *packet
+	out.PayloadType = viewer.audioTrack.PayloadType()
+	out.SSRC = viewer.audioTrack.SSRC()
+	out.SequenceNumber = viewer.audioSeq
+	viewer.audioSeq++
+	select {
+	case viewer.audioQueue <- &out:
+	default:
+	}
+}
+
+// sendAudioSample 投递转码后的音频帧, 由 track 负责打包
+func (viewer *Viewer) sendAudioSample(sample media.Sample) {
+	if viewer.audioTrack == nil || !viewer.live {
+		return
+	}
+	select {
+	case viewer.audioSamples <- sample:
+	default:
+	}
+}
+
+// writeLoop 连通后将队列中的数据写入 track
+func (viewer *Viewer) writeLoop() {
+	select {
+	case <-viewer.done:
+		return
+	case <-viewer.connected:
+	}
+	for {
+		select {
+		case <-viewer.done:
+			return
+		case frame := <-viewer.queue:
+			sample := media.Sample{Data: frame.AnnexB(), Samples: frame.samples(videoClockRate)}
+			if err := viewer.videoTrack.WriteSample(sample); err != nil && err != io.ErrClosedPipe {
+				log.Debugf("viewer %s write sample: %v", viewer.ID, err)
+			}
+		case packet := <-viewer.audioQueue:
+			if err := viewer.audioTrack.WriteRTP(packet); err != nil && err != io.ErrClosedPipe {
+				log.Debugf("viewer %s write audio: %v", viewer.ID, err)
+			}
+		case sample := <-viewer.audioSamples:
+			if err := viewer.audioTrack.WriteSample(sample); err != nil && err != io.ErrClosedPipe {
+				log.Debugf("viewer %s write audio sample: %v", viewer.ID, err)
+			}
+		}
+	}
+}
+
+// Close 关闭观看者
+func (viewer *Viewer) Close() {
+	viewer.closeOnce.Do(func() {
+		close(viewer.done)
+		if err := viewer.peerConnection.Close(); err != nil {
+			log.Debugf("viewer %s close: %v", viewer.ID, err)
+		}
+	})
+}
+
+// AddICECandidate 添加对端 trickle ICE 候选, candidate 为 sdp 中 a= 之后的内容
+func (viewer *Viewer) AddICECandidate(candidate string) error {
+	return viewer.peerConnection.AddICECandidate(webrtc.ICECandidateInit{Candidate: candidate})
+}
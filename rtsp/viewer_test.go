@@ -0,0 +1,133 @@
+package rtsp
+
+import (
+	"RTSPtoWebRTC/internal/testutil"
+	sdp "RTSPtoWebRTC/rtsp/sdp"
+	"testing"
+	"time"
+
+	"github.com/deepch/av"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v2"
+)
+
+func TestViewerReplayAfterConnect(t *testing.T) {
+	server := testutil.NewRTSPServer(t, testutil.Camera(testutil.SDP))
+	defer server.Close()
+	hub := HubNew("replay", server.URL("/live"), &StunConfig{})
+	hub.Start()
+	defer hub.Stop()
+	// 会话开始时清空 GOP 缓存, 等 DESCRIBE 完成后再写入
+	if err := hub.demand(); err != nil {
+		t.Fatal(err)
+	}
+	// pion 接收端用第一个包确定 payload type 后丢弃, 关键帧带上参数集
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x67, 0x42, 0x00}, {0x68, 0xce}, {0x65, 0x88, 0x84}}, Keyframe: true, Duration: 40 * time.Millisecond})
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41, 0x9a, 0x02}}, Duration: 40 * time.Millisecond})
+
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+	received := make(chan []byte, 16)
+	browser.OnTrack(func(track *webrtc.Track, receiver *webrtc.RTPReceiver) {
+		for {
+			packet, err := track.ReadRTP()
+			if err != nil {
+				return
+			}
+			received <- packet.Payload
+		}
+	})
+
+	viewer, answer, err := hub.AddViewer(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 连通之前的直播帧不入队, 缓存的 GOP 也还没有回放
+	hub.WriteFrame(&Frame{NALUs: [][]byte{{0x41, 0x9a, 0x03}}, Duration: 40 * time.Millisecond})
+	hub.mutex.RLock()
+	queued := len(viewer.queue)
+	hub.mutex.RUnlock()
+	if queued != 0 {
+		t.Fatalf("queued %d frames before connected", queued)
+	}
+	if err := browser.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case payload := <-received:
+			switch payload[0] & h264NALMask {
+			case h264NALSPS, h264NALPPS:
+			case 5:
+				return
+			default:
+				t.Fatalf("nal type %d before the replayed keyframe", payload[0]&h264NALMask)
+			}
+		case <-deadline:
+			t.Fatal("replayed keyframe not received")
+		}
+	}
+}
+
+func TestViewerSendAudio(t *testing.T) {
+	track, err := webrtc.NewTrack(8, 0xCAFE, "audio", "pion2", webrtc.NewRTPCodec(webrtc.RTPCodecTypeAudio, codecPCMA, 8000, 0, "", 8, &g711Payloader{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer := &Viewer{audioTrack: track, audioQueue: make(chan *rtp.Packet, viewerQueueSize), audioSeq: 0xFFFE}
+	packet := func(seq uint16, ts uint32) *rtp.Packet {
+		return &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 97, SSRC: 0x1234, SequenceNumber: seq, Timestamp: ts}, Payload: []byte{0xD5}}
+	}
+	viewer.sendAudio(packet(100, 0)) // 连通之前丢弃
+	viewer.live = true
+	// 摄像机序号跳变 (重连) 后, 发给浏览器的序号仍然连续并回绕
+	for i, source := range []*rtp.Packet{packet(101, 160), packet(102, 320), packet(7, 480)} {
+		viewer.sendAudio(source)
+		out := <-viewer.audioQueue
+		if want := uint16(0xFFFE + i); out.SequenceNumber != want || out.PayloadType != 8 || out.SSRC != 0xCAFE || out.Timestamp != source.Timestamp {
+			t.Errorf("packet %d = seq %d pt %d ssrc %#x ts %d, want seq %d", i, out.SequenceNumber, out.PayloadType, out.SSRC, out.Timestamp, want)
+		}
+		if source.PayloadType != 97 || source.SSRC != 0x1234 {
+			t.Errorf("source packet %d modified", i)
+		}
+	}
+	if len(viewer.audioQueue) != 0 {
+		t.Errorf("queued %d packets", len(viewer.audioQueue))
+	}
+}
+
+func TestViewerAudioTrack(t *testing.T) {
+	// 浏览器只支持 Opus: 流的音频为 PCMA 时只转发视频
+	mediaEngine := webrtc.MediaEngine{}
+	mediaEngine.RegisterCodec(webrtc.NewRTPH264Codec(webrtc.DefaultPayloadTypeH264, 90000))
+	mediaEngine.RegisterCodec(webrtc.NewRTPOpusCodec(webrtc.DefaultPayloadTypeOpus, 48000))
+	browser, err := webrtc.NewAPI(webrtc.WithMediaEngine(mediaEngine)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer browser.Close()
+	for _, kind := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
+		if _, err := browser.AddTransceiver(kind, webrtc.RtpTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer, err := browser.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		audio    int
+		hasTrack bool
+	}{{av.PCM_ALAW, false}, {sdp.OPUS, true}} {
+		viewer, _, err := newViewer(offer.SDP, &StunConfig{}, streamCodecs{video: av.H264, audio: test.audio})
+		if err != nil {
+			t.Fatal(err)
+		}
+		viewer.Close()
+		if (viewer.audioTrack != nil) != test.hasTrack {
+			t.Errorf("stream audio %d: audio track %v", test.audio, viewer.audioTrack != nil)
+		}
+	}
+}
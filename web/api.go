@@ -0,0 +1,161 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"RTSPtoWebRTC/config"
+	"RTSPtoWebRTC/rtsp"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxStreamBodySize 流配置请求体的最大长度
+const maxStreamBodySize = 64 * 1024
+
+// streamResponse 流配置 (密码已隐藏) 与运行状态
+type streamResponse struct {
+	Name   string          `json:"name"`
+	Config config.Stream   `json:"config"`
+	Status *rtsp.HubStatus `json:"status,omitempty"`
+}
+
+// streamAPI 流增删改查接口
+type streamAPI struct {
+	store *config.Store
+}
+
+// routeAPI 注册 /api/streams 接口
+func routeAPI(r *mux.Router, store *config.Store) {
+	api := &streamAPI{store: store}
+	r.HandleFunc("/api/streams", api.list).Methods(http.MethodGet)
+	r.HandleFunc("/api/streams", api.create).Methods(http.MethodPost)
+	r.HandleFunc("/api/streams/{name}", api.get).Methods(http.MethodGet)
+	r.HandleFunc("/api/streams/{name}", api.patch).Methods(http.MethodPatch)
+	r.HandleFunc("/api/streams/{name}", api.remove).Methods(http.MethodDelete)
+}
+
+// response 组装单路流的返回
+func (api *streamAPI) response(name string, stream config.Stream) streamResponse {
+	resp := streamResponse{Name: name, Config: stream.Redacted()}
+	if hub := rtsp.GetHub(name); hub != nil {
+		status := hub.Status()
+		status.URL = resp.Config.RTSPURL()
+		resp.Status = &status
+	}
+	return resp
+}
+
+// list GET /api/streams
+func (api *streamAPI) list(w http.ResponseWriter, r *http.Request) {
+	streams := []streamResponse{}
+	for _, name := range api.store.Names() {
+		if stream, ok := api.store.Get(name); ok {
+			streams = append(streams, api.response(name, stream))
+		}
+	}
+	writeJSON(w, http.StatusOK, streams)
+}
+
+// get GET /api/streams/{name}
+func (api *streamAPI) get(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	stream, ok := api.store.Get(name)
+	if !ok {
+		http.Error(w, config.ErrStreamNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, api.response(name, stream))
+}
+
+// create POST /api/streams, 请求体为 {"name": ..., "config": {...}}
+func (api *streamAPI) create(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name   string        `json:"name"`
+		Config config.Stream `json:"config"`
+	}
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := api.store.Add(req.Name, req.Config); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Infof("stream %s added", req.Name)
+	w.Header().Set("Location", "/api/streams/"+req.Name)
+	writeJSON(w, http.StatusCreated, api.response(req.Name, req.Config))
+}
+
+// patch PATCH /api/streams/{name}, 请求体中的字段覆盖现有配置, 随后按新配置重新拉流
+func (api *streamAPI) patch(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	stored, ok := api.store.Get(name)
+	if !ok {
+		http.Error(w, config.ErrStreamNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	// Get 返回副本, 请求体覆盖到另一个副本上, stored 中的 ICE 等指针字段不受影响
+	stream, _ := api.store.Get(name)
+	if !readJSON(w, r, &stream) {
+		return
+	}
+	// GET 返回的配置原样提交时保留原密码
+	stream = stream.Unredacted(stored)
+	if err := api.store.Update(name, stream); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Infof("stream %s updated", name)
+	writeJSON(w, http.StatusOK, api.response(name, stream))
+}
+
+// remove DELETE /api/streams/{name}, 断开拉流并关闭所有观看者
+func (api *streamAPI) remove(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := api.store.Remove(name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	log.Infof("stream %s removed", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readJSON 解析请求体, 失败时写 400
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxStreamBodySize))
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeJSON 写 json 返回
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("write json: %v", err)
+	}
+}
+
+// writeStoreError 按错误类型返回状态码
+func writeStoreError(w http.ResponseWriter, err error) {
+	if _, ok := err.(*config.SaveError); ok {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch err {
+	case config.ErrStreamNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case config.ErrStreamExists:
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"RTSPtoWebRTC/config"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStreamAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	store := config.StoreNew(path, &config.Config{Streams: map[string]config.Stream{}})
+	router := mux.NewRouter()
+	routeAPI(router, store)
+	request := func(method, target, body string) *http.Response {
+		return whepRequest(router, method, target, "application/json", body).Result()
+	}
+
+	created := `{"name": "api", "config": {"url": "rtsp://admin:pw@127.0.0.1:1/live", "password": "pw", "ice": {"url": "turn:127.0.0.1", "username": "u", "password": "turnpw"}}}`
+	resp := request(http.MethodPost, "/api/streams", created)
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Location") != "/api/streams/api" {
+		t.Fatalf("create = %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	defer store.Remove("api")
+	tests := []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPost, "/api/streams", created, http.StatusConflict},
+		{http.MethodPost, "/api/streams", `{"name": "bad", "config": {"url": "http://127.0.0.1/"}}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/streams", `{"name": `, http.StatusBadRequest},
+		{http.MethodGet, "/api/streams/missing", "", http.StatusNotFound},
+		{http.MethodPatch, "/api/streams/missing", `{}`, http.StatusNotFound},
+		{http.MethodPatch, "/api/streams/api", `{"transport": "sctp"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/api/streams/missing", "", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if resp := request(test.method, test.target, test.body); resp.StatusCode != test.status {
+			t.Errorf("%s %s %s = %d, want %d", test.method, test.target, test.body, resp.StatusCode, test.status)
+		}
+	}
+
+	var list []streamResponse
+	if resp := request(http.MethodGet, "/api/streams", ""); resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&list) != nil {
+		t.Fatalf("list = %d", resp.StatusCode)
+	}
+	if len(list) != 1 || list[0].Name != "api" || list[0].Status == nil {
+		t.Fatalf("list = %+v", list)
+	}
+
+	// GET 返回的配置隐藏密码, 修改一个字段后原样 PATCH 回去, 原密码保留
+	var got streamResponse
+	if resp := request(http.MethodGet, "/api/streams/api", ""); resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&got) != nil {
+		t.Fatalf("get = %d", resp.StatusCode)
+	}
+	if got.Config.Password == "pw" || strings.Contains(got.Config.URL, ":pw@") || got.Config.ICE.Password == "turnpw" {
+		t.Fatalf("secrets not redacted: %+v %+v", got.Config, got.Config.ICE)
+	}
+	got.Config.Transport = "udp"
+	body, err := json.Marshal(got.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp := request(http.MethodPatch, "/api/streams/api", string(body)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("patch = %d", resp.StatusCode)
+	}
+	saved, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := store.Get("api")
+	for _, stream := range []config.Stream{current, saved.Streams["api"]} {
+		if stream.Transport != "udp" || stream.Password != "pw" || stream.URL != "rtsp://admin:pw@127.0.0.1:1/live" || stream.ICE.Password != "turnpw" {
+			t.Errorf("stream after patch = %+v %+v", stream, stream.ICE)
+		}
+	}
+
+	if resp := request(http.MethodDelete, "/api/streams/api", ""); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("delete = %d", resp.StatusCode)
+	}
+	if names := store.Names(); len(names) != 0 {
+		t.Errorf("streams after delete = %v", names)
+	}
+}
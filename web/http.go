@@ -1,87 +1,75 @@
-package main
+package web
 
 import (
-	"fmt"
-	"log"
-	"math/rand"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
 
-	"encoding/base64"
+	"RTSPtoWebRTC/config"
+	"RTSPtoWebRTC/rtsp"
 
 	"github.com/gorilla/mux"
-	"github.com/pion/webrtc/v2"
-	//ice "github.com/pions/webrtc/internal/ice"
+	log "github.com/sirupsen/logrus"
 )
 
-// var DataChanelTest chan<- webrtc.RTCSample
-var videoTrack *webrtc.Track
-
-func main() {
-	go StartHTTPServer()
-	select {}
-}
-
-// StartHTTPServer 启动 http
-func StartHTTPServer() {
+// StartHTTPServer 启动 http, store 不为空时开放 /api/streams 管理接口
+func StartHTTPServer(addr, staticDir string, store *config.Store) {
 	r := mux.NewRouter()
-	// r.HandleFunc("/recive", HTTPHome)
-	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir("static/"))))
+	r.HandleFunc("/recive/{name}", HTTPHome).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/stream/{name}/status", HTTPStatus).Methods(http.MethodGet)
+	routeWHEP(r)
+	if store != nil {
+		routeAPI(r, store)
+	}
+	r.PathPrefix("/").Handler(http.StripPrefix("/", http.FileServer(http.Dir(staticDir))))
 
 	go func() {
-		err := http.ListenAndServe(":8080", r)
-		if err != nil {
+		log.Infof("http listen %s", addr)
+		if err := http.ListenAndServe(addr, r); err != nil {
+			log.Error(err)
 		}
 	}()
 }
 
-// HTTPHome 处理 token
+// HTTPHome 接收浏览器 base64 offer, 返回 base64 answer
 func HTTPHome(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	data := r.FormValue("data")
-	sd, err := base64.StdEncoding.DecodeString(data)
-	if err != nil {
-		log.Println(err)
+	if r.Method == http.MethodOptions {
 		return
 	}
-	// webrtc.RegisterDefaultCodecs()
-	//peerConnection, err := webrtc.New(webrtc.RTCConfiguration{
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.l.google.com:19302"},
-			},
-		},
-	})
-	if err != nil {
-		panic(err)
-	}
-	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
-		fmt.Printf("Connection State has changed %s \n", connectionState.String())
-	})
-	vp8Track, err := peerConnection.NewTrack(webrtc.DefaultPayloadTypeH264, rand.Uint32(), "video", "pion2")
-	if err != nil {
-		log.Println(err)
+	name := mux.Vars(r)["name"]
+	hub := rtsp.GetHub(name)
+	if hub == nil {
+		http.Error(w, "stream not found", http.StatusNotFound)
 		return
 	}
-	_, err = peerConnection.AddTrack(vp8Track)
+	sd, err := base64.StdEncoding.DecodeString(r.FormValue("data"))
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
+		http.Error(w, "bad sdp", http.StatusBadRequest)
 		return
 	}
-	offer := webrtc.SessionDescription{
-		Type: webrtc.SDPTypeOffer,
-		SDP:  string(sd),
-	}
-	if err := peerConnection.SetRemoteDescription(offer); err != nil {
-		log.Println(err)
+	_, answer, err := hub.AddViewer(string(sd))
+	if err != nil {
+		log.Error(err)
+		status := http.StatusInternalServerError
+		if err == rtsp.ErrStreamStopped || err == rtsp.ErrStreamNotReady {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
-	answer, err := peerConnection.CreateAnswer(nil)
-	if err != nil {
-		log.Println(err)
+	w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(answer))))
+}
+
+// HTTPStatus 返回流状态与重连统计
+func HTTPStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	hub := rtsp.GetHub(mux.Vars(r)["name"])
+	if hub == nil {
+		http.Error(w, "stream not found", http.StatusNotFound)
 		return
 	}
-	w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(answer.SDP))))
-	// DataChanelTest = vp8Track.Samples
-	videoTrack = vp8Track
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.Status())
 }
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"RTSPtoWebRTC/internal/testutil"
+	"RTSPtoWebRTC/rtsp"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHTTPHome(t *testing.T) {
+	_, remove := newTestHub(t, "recive")
+	defer remove()
+	router := mux.NewRouter()
+	router.HandleFunc("/recive/{name}", HTTPHome).Methods(http.MethodPost, http.MethodOptions)
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+
+	form := url.Values{"data": {base64.StdEncoding.EncodeToString([]byte(offer))}}
+	req := httptest.NewRequest(http.MethodPost, "/recive/recive", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d %s", rec.Code, rec.Body)
+	}
+	answer, err := base64.StdEncoding.DecodeString(rec.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"m=video ", "a=fingerprint:", "H264/90000"} {
+		if !strings.Contains(string(answer), want) {
+			t.Errorf("answer missing %q:\n%s", want, answer)
+		}
+	}
+	testutil.ConnectBrowser(t, browser, string(answer))
+
+	tests := []struct {
+		target, data string
+		status       int
+	}{
+		{"/recive/missing", form.Get("data"), http.StatusNotFound},
+		{"/recive/recive", "not base64!", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(url.Values{"data": {test.data}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %q = %d, want %d", test.target, test.data, rec.Code, test.status)
+		}
+	}
+}
+
+func TestHTTPHomeUnavailable(t *testing.T) {
+	stopped, remove := newTestHub(t, "recive-stopped")
+	defer remove()
+	stopped.Stop()
+	// 已注册但未启动拉流, 没有可等待的 DESCRIBE
+	idle := rtsp.HubNew("recive-idle", "rtsp://127.0.0.1:1/live", &rtsp.StunConfig{})
+	rtsp.RegisterHub(idle)
+	defer rtsp.UnregisterHub(idle)
+	router := mux.NewRouter()
+	router.HandleFunc("/recive/{name}", HTTPHome).Methods(http.MethodPost, http.MethodOptions)
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+
+	data := url.Values{"data": {base64.StdEncoding.EncodeToString([]byte(offer))}}.Encode()
+	for _, target := range []string{"/recive/recive-stopped", "/recive/recive-idle"} {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(data))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s = %d %s, want 503", target, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package web
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"RTSPtoWebRTC/rtsp"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// WHEP (WebRTC-HTTP Egress Protocol) 相关的 content type
+const (
+	contentTypeSDP     = "application/sdp"
+	contentTypeSDPFrag = "application/trickle-ice-sdpfrag"
+)
+
+// maxSDPSize offer 与 sdpfrag 的最大长度
+const maxSDPSize = 64 * 1024
+
+// routeWHEP 注册 WHEP 接口
+func routeWHEP(r *mux.Router) {
+	r.HandleFunc("/whep/{name}", WHEPOffer).Methods(http.MethodPost, http.MethodOptions)
+	r.HandleFunc("/whep/{name}/{id}", WHEPPatch).Methods(http.MethodPatch)
+	r.HandleFunc("/whep/{name}/{id}", WHEPDelete).Methods(http.MethodDelete)
+	r.HandleFunc("/whep/{name}/{id}", whepCORS).Methods(http.MethodOptions)
+}
+
+// whepCORS 设置跨域头, 浏览器播放器需要读取 Location
+func whepCORS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, If-Match, Authorization")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Accept-Patch, ETag")
+	w.Header().Set("Accept-Patch", contentTypeSDPFrag)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Accept-Post", contentTypeSDP)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// hasContentType 判断请求的 Content-Type
+func hasContentType(r *http.Request, want string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == want
+}
+
+// WHEPOffer 接收 application/sdp offer, 返回 201 与 answer
+func WHEPOffer(w http.ResponseWriter, r *http.Request) {
+	whepCORS(w, r)
+	if r.Method == http.MethodOptions {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	hub := rtsp.GetHub(name)
+	if hub == nil {
+		http.Error(w, "stream not found", http.StatusNotFound)
+		return
+	}
+	if !hasContentType(r, contentTypeSDP) {
+		http.Error(w, "content type must be "+contentTypeSDP, http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	viewer, answer, err := hub.AddViewer(string(offer))
+	if err != nil {
+		log.Errorf("[%s] whep offer: %v", name, err)
+		status := http.StatusBadRequest
+		if err == rtsp.ErrStreamStopped || err == rtsp.ErrStreamNotReady {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeSDP)
+	w.Header().Set("Location", "/whep/"+name+"/"+viewer.ID)
+	w.Header().Set("ETag", `"`+viewer.ID+`"`)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+// WHEPPatch trickle ICE, 将 sdpfrag 中的候选加入对应观看者
+func WHEPPatch(w http.ResponseWriter, r *http.Request) {
+	whepCORS(w, r)
+	_, viewer := whepViewer(w, r)
+	if viewer == nil {
+		return
+	}
+	if !hasContentType(r, contentTypeSDPFrag) {
+		http.Error(w, "content type must be "+contentTypeSDPFrag, http.StatusUnsupportedMediaType)
+		return
+	}
+	frag, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSDPSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for _, line := range strings.Split(string(frag), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "a=candidate:") {
+			continue
+		}
+		if err := viewer.AddICECandidate(line[2:]); err != nil {
+			log.Errorf("viewer %s add candidate %q: %v", viewer.ID, line, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WHEPDelete 拆除会话
+func WHEPDelete(w http.ResponseWriter, r *http.Request) {
+	whepCORS(w, r)
+	if hub, viewer := whepViewer(w, r); viewer != nil {
+		hub.RemoveViewer(viewer.ID)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// whepViewer 按 URL 查找流与观看者, 不存在时返回 404 与 nil
+func whepViewer(w http.ResponseWriter, r *http.Request) (*rtsp.Hub, *rtsp.Viewer) {
+	vars := mux.Vars(r)
+	hub := rtsp.GetHub(vars["name"])
+	if hub == nil {
+		http.Error(w, "stream not found", http.StatusNotFound)
+		return nil, nil
+	}
+	viewer := hub.Viewer(vars["id"])
+	if viewer == nil {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return nil, nil
+	}
+	return hub, viewer
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"RTSPtoWebRTC/internal/testutil"
+	"RTSPtoWebRTC/rtsp"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestHub 注册一个从替身摄像机拉流的测试 Hub, 返回注销并停止的函数
+func newTestHub(t *testing.T, name string) (*rtsp.Hub, func()) {
+	camera := testutil.NewRTSPServer(t, testutil.Camera(testutil.SDP))
+	hub := rtsp.HubNew(name, camera.URL("/live"), &rtsp.StunConfig{})
+	rtsp.RegisterHub(hub)
+	hub.Start()
+	return hub, func() {
+		rtsp.UnregisterHub(hub)
+		hub.Stop()
+		camera.Close()
+	}
+}
+
+// whepRequest 向 WHEP 路由发送请求
+func whepRequest(router http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWHEPSession(t *testing.T) {
+	_, remove := newTestHub(t, "whep")
+	defer remove()
+	router := mux.NewRouter()
+	routeWHEP(router)
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+
+	if rec := whepRequest(router, http.MethodPost, "/whep/whep", "text/plain", offer); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("post text/plain = %d", rec.Code)
+	}
+	rec := whepRequest(router, http.MethodPost, "/whep/whep", contentTypeSDP, offer)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("post = %d %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/whep/whep/") {
+		t.Fatalf("location = %q", location)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypeSDP {
+		t.Errorf("content type = %q", ct)
+	}
+	testutil.ConnectBrowser(t, browser, rec.Body.String())
+
+	candidate := "a=candidate:1 1 udp 2130706431 127.0.0.1 50000 typ host\r\n"
+	if rec := whepRequest(router, http.MethodPatch, location, contentTypeSDP, candidate); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("patch application/sdp = %d", rec.Code)
+	}
+	if rec := whepRequest(router, http.MethodPatch, location, contentTypeSDPFrag, candidate); rec.Code != http.StatusNoContent {
+		t.Errorf("patch = %d %s", rec.Code, rec.Body)
+	}
+	if rec := whepRequest(router, http.MethodDelete, location, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("delete = %d", rec.Code)
+	}
+	if rec := whepRequest(router, http.MethodDelete, location, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second delete = %d", rec.Code)
+	}
+}
+
+func TestWHEPNotFound(t *testing.T) {
+	_, remove := newTestHub(t, "whep-notfound")
+	defer remove()
+	router := mux.NewRouter()
+	routeWHEP(router)
+	tests := []struct {
+		method, target, contentType string
+	}{
+		{http.MethodPost, "/whep/missing", contentTypeSDP},
+		{http.MethodPatch, "/whep/whep-notfound/missing", contentTypeSDPFrag},
+		{http.MethodDelete, "/whep/whep-notfound/missing", ""},
+		{http.MethodDelete, "/whep/missing/missing", ""},
+	}
+	for _, test := range tests {
+		if rec := whepRequest(router, test.method, test.target, test.contentType, ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s = %d", test.method, test.target, rec.Code)
+		}
+	}
+}
+
+func TestWHEPStopped(t *testing.T) {
+	hub, remove := newTestHub(t, "whep-stopped")
+	defer remove()
+	hub.Stop()
+	router := mux.NewRouter()
+	routeWHEP(router)
+	browser, offer := testutil.NewBrowser(t)
+	defer browser.Close()
+	if rec := whepRequest(router, http.MethodPost, "/whep/whep-stopped", contentTypeSDP, offer); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("post to stopped stream = %d", rec.Code)
+	}
+}